@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/handlers"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/history"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/metrics"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/operations"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/quota"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/vnc"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/websocket"
 )
@@ -24,69 +34,183 @@ func main() {
 
 	gin.SetMode(mode)
 
-	// Initialize Kubernetes client
-	k8sClient, err := k8s.NewClient(namespace)
+	// Initialize Kubernetes clients
+	registry, err := newClusterRegistry(namespace)
 	if err != nil {
-		log.Fatalf("Failed to create Kubernetes client: %v", err)
+		log.Fatalf("Failed to create Kubernetes clients: %v", err)
+	}
+	for _, client := range registry.Clients() {
+		log.Printf("Registered cluster %s, watching all namespaces (default namespace: %s)", client.Name(), client.DefaultNamespace())
+	}
+
+	// Create context for graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start the shared informer caches backing all reads
+	registry.Start(ctx)
+
+	// Initialize authentication
+	authenticator, err := newAuthenticator(ctx)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+
+	// Optionally act as the authenticated user against the Kubernetes API
+	impersonate := os.Getenv("IMPERSONATE_USERS") == "true"
+	if impersonate {
+		log.Println("Kubernetes API calls impersonate the authenticated user")
+	}
+
+	// Initialize audit log
+	auditLogger, err := newAuditLogger(registry)
+	if err != nil {
+		log.Fatalf("Failed to configure audit log: %v", err)
+	}
+	auditDone := make(chan struct{})
+	go func() {
+		auditLogger.Run(ctx)
+		close(auditDone)
+	}()
+
+	// Initialize the bus shared with the other replicas
+	messageBus, err := newBus()
+	if err != nil {
+		log.Fatalf("Failed to configure message bus: %v", err)
 	}
-	log.Printf("Connected to Kubernetes cluster, watching namespace: %s", namespace)
+
+	// Initialize project quotas
+	quotas, err := newQuotaManager(registry, messageBus)
+	if err != nil {
+		log.Fatalf("Failed to configure project quotas: %v", err)
+	}
+
+	// Initialize the VM metrics history, collected by the leader
+	metricsHistory, err := newMetricsHistory()
+	if err != nil {
+		log.Fatalf("Failed to configure metrics history: %v", err)
+	}
+
+	// Initialize VNC session recording
+	recordings, err := newRecordingStore()
+	if err != nil {
+		log.Fatalf("Failed to configure VNC session recording: %v", err)
+	}
+
+	// Initialize VNC connection tokens
+	vncTokens, err := newTokenIssuer(messageBus)
+	if err != nil {
+		log.Fatalf("Failed to configure VNC connection tokens: %v", err)
+	}
+	wsOrigins := splitList(getEnv("CONSOLE_ALLOWED_ORIGINS", os.Getenv("CORS_ALLOWED_ORIGINS")))
 
 	// Initialize handlers
-	vmHandler := handlers.NewVMHandler(k8sClient)
-	snapshotHandler := handlers.NewSnapshotHandler(k8sClient)
-	vncProxy := vnc.NewVNCProxy(k8sClient, namespace)
+	clusterHandler := handlers.NewClusterHandler(registry)
+	projectHandler := handlers.NewProjectHandler(quotas, registry, impersonate)
+	auditReaderGroups := splitList(os.Getenv("AUDIT_READER_GROUPS"))
+	auditHandler := handlers.NewAuditHandler(auditLogger, auditReaderGroups)
+	historyHandler := handlers.NewHistoryHandler(metricsHistory)
+	vncProxy := vnc.NewVNCProxy(auditLogger, recordings, vncTokens, wsOrigins)
+	recordingHandler := vnc.NewRecordingHandler(recordings, auditLogger, auditReaderGroups, wsOrigins)
+	consoleProxy := vnc.NewConsoleProxy(auditLogger, messageBus, getEnvInt("CONSOLE_MAX_SESSIONS", vnc.DefaultConsoleSessions), wsOrigins)
 
 	// Initialize WebSocket hub
-	wsHub := websocket.NewHub(k8sClient)
-	wsHandler := handlers.NewWebSocketHandler(wsHub)
+	wsHub := websocket.NewHub(registry, messageBus)
 
-	// Create context for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// The metrics history collector also feeds the hub's metrics messages, unless WS_METRICS=false
+	var onSample history.SampleFunc
+	if os.Getenv("WS_METRICS") != "false" {
+		onSample = wsHub.PublishMetrics
+	}
+	collector := history.NewCollector(registry, metricsHistory, messageBus, onSample)
+	go collector.Receive(ctx)
+	wsHandler := handlers.NewWebSocketHandler(wsHub, wsOrigins, impersonate)
+	eventsHandler := handlers.NewEventsHandler(wsHub, impersonate)
+
+	// Initialize background operations, reported to WebSocket clients
+	ops, err := newOperationManager(messageBus, wsHub)
+	if err != nil {
+		log.Fatalf("Failed to configure operations: %v", err)
+	}
+	go ops.Receive(ctx)
+	vmHandler := handlers.NewVMHandler(quotas, ops)
+	snapshotHandler := handlers.NewSnapshotHandler(quotas, ops)
+	operationHandler := handlers.NewOperationHandler(ops, registry, impersonate)
 
-	// Start WebSocket hub
-	go wsHub.Run(ctx)
+	// Start WebSocket hub. It stops when the server shuts down, ending the
+	// WebSocket and Server-Sent Events streams that Shutdown would wait for.
+	hubCtx, stopHub := context.WithCancel(ctx)
+	defer stopHub()
+	go wsHub.Run(hubCtx)
+
+	// The leader publishes resource changes and collects metrics history for all replicas
+	lead := func(ctx context.Context) {
+		go collector.Run(ctx)
+		wsHub.Lead(ctx)
+	}
+	if lease, ok := leaseConfig(namespace); ok {
+		go registry.Default().RunLeaderElection(ctx, lease, lead)
+	} else {
+		go lead(ctx)
+	}
 
 	// Setup router
 	router := gin.Default()
+	router.Use(metrics.Middleware())
 
 	// CORS middleware
-	router.Use(corsMiddleware())
+	router.Use(corsMiddleware(splitList(os.Getenv("CORS_ALLOWED_ORIGINS"))))
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
-	// API routes
-	api := router.Group("/api")
+	// Prometheus metrics of the backend itself
+	router.GET("/metrics", metrics.Handler())
+
+	// API routes, all of which require authentication
+	api := router.Group("/api", auth.Middleware(authenticator))
 	{
-		// VM routes
-		vms := api.Group("/vms")
-		{
-			vms.GET("", vmHandler.ListVMs)
-			vms.GET("/stats", vmHandler.GetVMStats)
-			vms.POST("", vmHandler.CreateVM)
-			vms.GET("/:name", vmHandler.GetVM)
-			vms.POST("/:name/action", vmHandler.VMAction)
-			vms.GET("/:name/snapshots", snapshotHandler.ListSnapshotsByVM)
-
-			// VNC routes
-			vms.GET("/:name/vnc", vncProxy.HandleVNC)
-			vms.GET("/:name/vnc/info", vncProxy.GetVNCInfo)
-		}
-
-		// Snapshot routes
-		snapshots := api.Group("/snapshots")
-		{
-			snapshots.GET("", snapshotHandler.ListSnapshots)
-			snapshots.POST("", snapshotHandler.CreateSnapshot)
-			snapshots.POST("/:name/restore", snapshotHandler.RestoreSnapshot)
-			snapshots.DELETE("/:name", snapshotHandler.DeleteSnapshot)
+		// Identity of the caller
+		api.GET("/me", auth.WhoAmI)
+
+		// Cluster registry with connectivity health
+		api.GET("/clusters", clusterHandler.ListClusters)
+
+		// Projects and quota usage
+		api.GET("/projects", projectHandler.ListProjects)
+		api.GET("/projects/:id/quota", projectHandler.GetProjectQuota)
+
+		// Audit log of mutating operations and console sessions
+		api.GET("/audit", auditHandler.QueryAudit)
+
+		// Progress of background operations
+		api.GET("/operations", operationHandler.ListOperations)
+		api.GET("/operations/:id", operationHandler.GetOperation)
+
+		// Recorded VNC sessions
+		api.GET("/vnc-sessions", recordingHandler.ListRecordings)
+		api.GET("/vnc-sessions/:id/playback", recordingHandler.HandlePlayback)
+
+		// Resource routes, optionally prefixed by cluster and namespace.
+		// Without a cluster the default cluster is used; without a namespace
+		// single resources are in the default namespace and lists span all namespaces.
+		clusterScoped := handlers.ClusterMiddleware(registry, impersonate)
+		for _, prefix := range []string{
+			"",
+			"/namespaces/:namespace",
+			"/clusters/:cluster",
+			"/clusters/:cluster/namespaces/:namespace",
+		} {
+			registerResourceRoutes(api.Group(prefix, clusterScoped), vmHandler, snapshotHandler, historyHandler, vncProxy, consoleProxy, auditLogger)
 		}
 
 		// WebSocket route for real-time updates
 		api.GET("/ws", wsHandler.HandleWebSocket)
+
+		// Server-Sent Events stream of the same updates
+		api.GET("/events", eventsHandler.HandleEvents)
 	}
 
 	// Create HTTP server
@@ -94,6 +218,7 @@ func main() {
 		Addr:    ":" + port,
 		Handler: router,
 	}
+	srv.RegisterOnShutdown(stopHub)
 
 	// Start server in goroutine
 	go func() {
@@ -109,10 +234,8 @@ func main() {
 	<-quit
 	log.Println("Shutting down server...")
 
-	// Cancel context to stop WebSocket hub
-	cancel()
-
-	// Graceful shutdown with timeout
+	// Graceful shutdown with timeout. Requests in flight complete first; the hub is
+	// stopped as shutdown starts, so streaming requests end too.
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
 
@@ -120,9 +243,291 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Cancel context to stop the informers and background work, and wait for the
+	// audit events of the last requests to be written
+	cancel()
+	<-auditDone
+
 	log.Println("Server exited")
 }
 
+// registerResourceRoutes registers the VM and snapshot routes under the given group.
+// Mutating routes are recorded in the audit log.
+func registerResourceRoutes(rg *gin.RouterGroup, vmHandler *handlers.VMHandler, snapshotHandler *handlers.SnapshotHandler, historyHandler *handlers.HistoryHandler, vncProxy *vnc.VNCProxy, consoleProxy *vnc.ConsoleProxy, auditLogger *audit.Logger) {
+	// VM routes
+	vms := rg.Group("/vms")
+	{
+		vms.GET("", vmHandler.ListVMs)
+		vms.GET("/stats", vmHandler.GetVMStats)
+		vms.POST("", handlers.Audit(auditLogger, "Wukong", "vm.create"), vmHandler.CreateVM)
+		vms.GET("/:name", vmHandler.GetVM)
+		vms.PATCH("/:name", handlers.Audit(auditLogger, "Wukong", "vm.update"), vmHandler.UpdateVM)
+		vms.POST("/:name/action", handlers.Audit(auditLogger, "Wukong", "vm.action"), vmHandler.VMAction)
+		vms.GET("/:name/snapshots", snapshotHandler.ListSnapshotsByVM)
+		vms.GET("/:name/metrics", historyHandler.GetVMMetricsHistory)
+
+		// VNC routes
+		vms.GET("/:name/vnc", vncProxy.HandleVNC)
+		vms.GET("/:name/vnc/info", vncProxy.GetVNCInfo)
+		vms.GET("/:name/vnc/sessions", vncProxy.ListSessions)
+		vms.POST("/:name/vnc/sessions/control", handlers.Audit(auditLogger, "VirtualMachineInstance", "vnc.control"), vncProxy.Handover)
+
+		// Serial console route
+		vms.GET("/:name/console", consoleProxy.HandleConsole)
+	}
+
+	// Snapshot routes
+	snapshots := rg.Group("/snapshots")
+	{
+		snapshots.GET("", snapshotHandler.ListSnapshots)
+		snapshots.POST("", handlers.Audit(auditLogger, "WukongSnapshot", "snapshot.create"), snapshotHandler.CreateSnapshot)
+		snapshots.POST("/:name/restore", handlers.Audit(auditLogger, "WukongSnapshot", "snapshot.restore"), snapshotHandler.RestoreSnapshot)
+		snapshots.DELETE("/:name", handlers.Audit(auditLogger, "WukongSnapshot", "snapshot.delete"), snapshotHandler.DeleteSnapshot)
+	}
+}
+
+// newClusterRegistry creates the cluster registry from CLUSTERS_CONFIG, a cluster registry file,
+// or KUBECONFIG_CONTEXTS, a comma-separated list of kubeconfig contexts ("*" for all).
+// Without either, the in-cluster or KUBECONFIG cluster is the only cluster,
+// whose VM metrics are read from PROMETHEUS_URL if set.
+func newClusterRegistry(namespace string) (*k8s.Registry, error) {
+	if path := os.Getenv("CLUSTERS_CONFIG"); path != "" {
+		config, err := k8s.LoadRegistryConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		return k8s.NewRegistry(config, namespace)
+	}
+
+	if contexts := os.Getenv("KUBECONFIG_CONTEXTS"); contexts != "" {
+		var names []string
+		if contexts != "*" {
+			names = splitList(contexts)
+		}
+		config, err := k8s.RegistryConfigFromContexts(os.Getenv("KUBECONFIG"), names)
+		if err != nil {
+			return nil, err
+		}
+		return k8s.NewRegistry(config, namespace)
+	}
+
+	client, err := k8s.NewClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if url := os.Getenv("PROMETHEUS_URL"); url != "" {
+		client.UsePrometheus(url)
+		log.Printf("Reading VM metrics from Prometheus at %s", url)
+	}
+	return k8s.NewSingleClusterRegistry(client), nil
+}
+
+// newAuthenticator creates the authenticator for API requests. OIDC_ISSUER_URL enables
+// JWT validation against the issuer's JWKS and AUTH_TOKEN_FILE enables static tokens;
+// both may be combined. AUTH_DISABLED=true accepts anonymous requests instead.
+func newAuthenticator(ctx context.Context) (auth.Authenticator, error) {
+	if os.Getenv("AUTH_DISABLED") == "true" {
+		log.Println("WARNING: authentication is disabled, every request is served anonymously")
+		return auth.Anonymous(), nil
+	}
+
+	var authenticators []auth.Authenticator
+
+	if issuer := os.Getenv("OIDC_ISSUER_URL"); issuer != "" {
+		oidcAuth, err := auth.NewOIDCAuthenticator(ctx, auth.OIDCConfig{
+			IssuerURL:     issuer,
+			ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+			UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+			GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, oidcAuth)
+		log.Printf("OIDC authentication enabled, issuer: %s", issuer)
+	}
+
+	if path := os.Getenv("AUTH_TOKEN_FILE"); path != "" {
+		staticAuth, err := auth.NewStaticTokenAuthenticator(path)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, staticAuth)
+		log.Printf("Static token authentication enabled")
+	}
+
+	if len(authenticators) == 0 {
+		return nil, fmt.Errorf("no authentication configured: set OIDC_ISSUER_URL and/or AUTH_TOKEN_FILE, or AUTH_DISABLED=true for development")
+	}
+	return auth.Chain(authenticators...), nil
+}
+
+// newQuotaManager creates the project quota manager from PROJECTS_CONFIG.
+// Without it, no quota is enforced.
+func newQuotaManager(registry *k8s.Registry, messageBus bus.Bus) (*quota.Manager, error) {
+	path := os.Getenv("PROJECTS_CONFIG")
+	if path == "" {
+		return nil, nil
+	}
+
+	config, err := quota.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d projects from %s", len(config.Projects), path)
+	return quota.NewManager(registry, messageBus, config)
+}
+
+// newMetricsHistory creates the VM metrics history store from METRICS_HISTORY_RESOLUTIONS,
+// comma-separated step:retention pairs. VMs are sampled at the first step.
+func newMetricsHistory() (*history.Store, error) {
+	resolutions := history.DefaultResolutions
+	if value := os.Getenv("METRICS_HISTORY_RESOLUTIONS"); value != "" {
+		var err error
+		resolutions, err = history.ParseResolutions(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return history.NewStore(resolutions), nil
+}
+
+// newRecordingStore creates the VNC session recording store in VNC_RECORDING_DIR.
+// Without it, sessions are not recorded and nil is returned.
+func newRecordingStore() (*vnc.RecordingStore, error) {
+	dir := os.Getenv("VNC_RECORDING_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+
+	store, err := vnc.NewRecordingStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Recording VNC sessions to %s", dir)
+	return store, nil
+}
+
+// newTokenIssuer creates the VNC connection token issuer. Tokens are signed with
+// VNC_TOKEN_KEY, which all replicas must share, or else a random key, and are valid
+// for VNC_TOKEN_TTL. Consumed tokens are recorded on the bus, shared by the replicas.
+func newTokenIssuer(messageBus bus.Bus) (*vnc.TokenIssuer, error) {
+	ttl := vnc.DefaultTokenTTL
+	if value := os.Getenv("VNC_TOKEN_TTL"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VNC_TOKEN_TTL: %w", err)
+		}
+	}
+
+	key := []byte(os.Getenv("VNC_TOKEN_KEY"))
+	if len(key) == 0 {
+		key = vnc.NewRandomKey()
+		if os.Getenv("REDIS_URL") != "" {
+			log.Println("WARNING: VNC_TOKEN_KEY is not set, VNC tokens are only valid on the replica that issued them")
+		}
+	}
+	return vnc.NewTokenIssuer(key, ttl, messageBus), nil
+}
+
+// newOperationManager creates the operation manager, bounding operations by
+// OPERATION_TIMEOUT and broadcasting their updates through the hub
+func newOperationManager(messageBus bus.Bus, hub *websocket.Hub) (*operations.Manager, error) {
+	timeout := operations.DefaultTimeout
+	if value := os.Getenv("OPERATION_TIMEOUT"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid OPERATION_TIMEOUT: %q", value)
+		}
+	}
+	return operations.NewManager(messageBus, timeout, hub.BroadcastOperation), nil
+}
+
+// newBus creates the bus between replicas from REDIS_URL, e.g. redis://redis:6379/0.
+// Without it, the bus is in-process and only one replica may run.
+func newBus() (bus.Bus, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return bus.NewMemory(), nil
+	}
+
+	redisBus, err := bus.NewRedis(url)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Sharing updates with other replicas over Redis")
+	if os.Getenv("LEADER_ELECTION") != "true" {
+		log.Println("WARNING: LEADER_ELECTION is not enabled, every replica publishes updates")
+	}
+	return redisBus, nil
+}
+
+// leaseConfig returns the Lease electing the leader if LEADER_ELECTION=true.
+// LEADER_ELECTION_NAMESPACE defaults to the default namespace, LEADER_ELECTION_LEASE
+// to wukong-dashboard, and the identity is POD_NAME or the hostname.
+func leaseConfig(namespace string) (k8s.LeaseConfig, bool) {
+	if os.Getenv("LEADER_ELECTION") != "true" {
+		return k8s.LeaseConfig{}, false
+	}
+
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return k8s.LeaseConfig{
+		Namespace: getEnv("LEADER_ELECTION_NAMESPACE", namespace),
+		Name:      getEnv("LEADER_ELECTION_LEASE", "wukong-dashboard"),
+		Identity:  identity,
+	}, true
+}
+
+// auditMemoryEvents is the number of audit events kept in memory when no audit log file is configured
+const auditMemoryEvents = 10000
+
+// newAuditLogger creates the audit logger from AUDIT_LOG_FILE, a JSON-lines file,
+// AUDIT_KUBERNETES_EVENTS=true, which records Events on the target objects, and
+// AUDIT_WEBHOOK_URL with optional AUDIT_WEBHOOK_TOKEN. The file, or else the most
+// recent events kept in memory, serves audit queries.
+func newAuditLogger(registry *k8s.Registry) (*audit.Logger, error) {
+	var sinks []audit.Sink
+
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		fileSink, err := audit.NewFileSink(path)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, fileSink)
+		log.Printf("Audit log file: %s", path)
+	} else {
+		sinks = append(sinks, audit.NewMemorySink(auditMemoryEvents))
+	}
+
+	if os.Getenv("AUDIT_KUBERNETES_EVENTS") == "true" {
+		sinks = append(sinks, audit.NewEventSink(registry))
+		log.Println("Audit events are recorded as Kubernetes Events")
+	}
+
+	if url := os.Getenv("AUDIT_WEBHOOK_URL"); url != "" {
+		sinks = append(sinks, audit.NewWebhookSink(url, os.Getenv("AUDIT_WEBHOOK_TOKEN")))
+		log.Printf("Audit webhook: %s", url)
+	}
+
+	return audit.NewLogger(sinks...), nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -130,12 +535,44 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func corsMiddleware() gin.HandlerFunc {
+// getEnvInt returns the integer value of an environment variable, exiting if it is not an integer
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return n
+}
+
+// corsMiddleware allows cross-origin requests from the given origins only.
+// "*" allows any origin, but then credentials are not allowed.
+func corsMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	allowAny := false
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[origin] = true
+	}
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Vary", "Origin")
+		origin := c.Request.Header.Get("Origin")
+		switch {
+		case origin == "":
+		case allowed[origin]:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		case allowAny:
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/metrics"
+)
+
+// Results of an audited operation
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Context keys of the annotations handlers add to the audit event of a request
+const (
+	actionKey = "auditAction"
+	nameKey   = "auditName"
+)
+
+// Event is one audited operation
+type Event struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Groups     []string  `json:"groups,omitempty"`
+	SourceIP   string    `json:"sourceIp,omitempty"`
+	Action     string    `json:"action"`
+	Cluster    string    `json:"cluster,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name,omitempty"`
+	BodyDigest string    `json:"bodyDigest,omitempty"`
+	Result     string    `json:"result"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// Sink receives audit events
+type Sink interface {
+	Write(ctx context.Context, event Event) error
+}
+
+// Querier is a Sink that can be searched
+type Querier interface {
+	Query(ctx context.Context, filter Filter) ([]Event, error)
+}
+
+// Filter selects audit events. Empty fields match everything.
+type Filter struct {
+	Actor     string
+	Action    string
+	Cluster   string
+	Namespace string
+	Kind      string
+	Name      string
+	Result    string
+	Since     time.Time
+	Until     time.Time
+	// Limit caps the number of events returned, newest first
+	Limit int
+}
+
+// Matches reports whether the event is selected by the filter
+func (f Filter) Matches(e Event) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor:
+	case f.Action != "" && e.Action != f.Action && !strings.HasPrefix(e.Action, f.Action+"."):
+	case f.Cluster != "" && e.Cluster != f.Cluster:
+	case f.Namespace != "" && e.Namespace != f.Namespace:
+	case f.Kind != "" && e.Kind != f.Kind:
+	case f.Name != "" && e.Name != f.Name:
+	case f.Result != "" && e.Result != f.Result:
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+	default:
+		return true
+	}
+	return false
+}
+
+// Logger dispatches audit events to its sinks in the background
+type Logger struct {
+	sinks   []Sink
+	querier Querier
+	events  chan Event
+}
+
+// queueSize bounds the events waiting to be written to the sinks
+const queueSize = 1024
+
+// enqueueTimeout bounds how long Log waits for room in a full queue before dropping an event
+const enqueueTimeout = 2 * time.Second
+
+// drainTimeout bounds the time spent writing the queued events once the logger stops
+const drainTimeout = 5 * time.Second
+
+// NewLogger creates a logger writing to the given sinks.
+// Queries are served by the first sink implementing Querier.
+func NewLogger(sinks ...Sink) *Logger {
+	l := &Logger{
+		sinks:  sinks,
+		events: make(chan Event, queueSize),
+	}
+	for _, sink := range sinks {
+		if q, ok := sink.(Querier); ok {
+			l.querier = q
+			break
+		}
+	}
+	return l
+}
+
+// Run writes queued events to the sinks until ctx is done, then writes the events
+// still queued within drainTimeout before returning
+func (l *Logger) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			l.drain()
+			return
+		case event := <-l.events:
+			l.write(ctx, event)
+		}
+	}
+}
+
+// drain writes the queued events, with a context of its own since the context of Run is done
+func (l *Logger) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for {
+		select {
+		case event := <-l.events:
+			l.write(ctx, event)
+		default:
+			return
+		}
+	}
+}
+
+// write writes an event to every sink
+func (l *Logger) write(ctx context.Context, event Event) {
+	for _, sink := range l.sinks {
+		if err := sink.Write(ctx, event); err != nil {
+			log.Printf("Failed to write audit event %s to %T: %v", event.ID, sink, err)
+		}
+	}
+}
+
+// Log queues an event for the sinks, filling in its ID and time if unset.
+// When the queue is full, it waits up to enqueueTimeout for the sinks to catch up,
+// slowing down the request, before dropping the event.
+func (l *Logger) Log(event Event) {
+	if event.ID == "" {
+		event.ID = newID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	select {
+	case l.events <- event:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.events <- event:
+	case <-timer.C:
+		metrics.AuditDroppedEvents.Inc()
+		log.Printf("Audit queue full, dropping event: %s %s %s/%s by %s", event.Action, event.Kind, event.Namespace, event.Name, event.Actor)
+	}
+}
+
+// Query returns the events matching filter, newest first
+func (l *Logger) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	if l.querier == nil {
+		return []Event{}, nil
+	}
+	return l.querier.Query(ctx, filter)
+}
+
+// SetAction overrides the action recorded for the current request
+func SetAction(c *gin.Context, action string) {
+	c.Set(actionKey, action)
+}
+
+// SetName sets the target name recorded for the current request,
+// for routes that do not carry it as a :name parameter
+func SetName(c *gin.Context, name string) {
+	c.Set(nameKey, name)
+}
+
+// Annotations returns the action and name set by SetAction and SetName
+func Annotations(c *gin.Context) (action, name string) {
+	action = c.GetString(actionKey)
+	name = c.GetString(nameKey)
+	return action, name
+}
+
+// sortNewestFirst sorts events by descending time and applies the filter limit
+func sortNewestFirst(events []Event, limit int) []Event {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
+
+// newID returns a random event ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoggerDrainsOnStop(t *testing.T) {
+	sink := NewMemorySink(10)
+	logger := NewLogger(sink)
+	for _, name := range []string{"web-1", "web-2", "web-3"} {
+		logger.Log(Event{Action: "vm.create", Kind: "Wukong", Name: name, Result: ResultSuccess})
+	}
+
+	// The events queued when the logger stops are still written
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	logger.Run(ctx)
+
+	events, err := logger.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Errorf("wrote %d events, want the 3 queued", len(events))
+	}
+}
+
+func TestLoggerWaitsForRoom(t *testing.T) {
+	logger := NewLogger()
+	for i := 0; i < queueSize; i++ {
+		logger.Log(Event{Action: "vm.create"})
+	}
+
+	// With the queue full, an event waits for the sinks instead of being dropped
+	logged := make(chan struct{})
+	go func() {
+		logger.Log(Event{Action: "vm.delete"})
+		close(logged)
+	}()
+	<-logger.events
+	<-logged
+	if len(logger.events) != queueSize {
+		t.Errorf("queued %d events, want %d", len(logger.events), queueSize)
+	}
+}
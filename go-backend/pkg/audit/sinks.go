@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MemorySink keeps the most recent events in memory
+type MemorySink struct {
+	mu     sync.RWMutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewMemorySink creates a sink holding up to size events
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{events: make([]Event, size)}
+}
+
+// Write implements Sink
+func (s *MemorySink) Write(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[s.next] = event
+	s.next = (s.next + 1) % len(s.events)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// Query implements Querier
+func (s *MemorySink) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.events[:s.next]
+	if s.full {
+		stored = s.events
+	}
+
+	result := []Event{}
+	for _, e := range stored {
+		if filter.Matches(e) {
+			result = append(result, e)
+		}
+	}
+	return sortNewestFirst(result, filter.Limit), nil
+}
+
+// FileSink appends events to a JSON-lines file
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, file: file}, nil
+}
+
+// Write implements Sink
+func (s *FileSink) Write(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Query implements Querier by scanning the file
+func (s *FileSink) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := []Event{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if filter.Matches(e) {
+			result = append(result, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sortNewestFirst(result, filter.Limit), nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WebhookSink posts each event as JSON to a URL
+type WebhookSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink posting to url, with token as bearer token if set
+func NewWebhookSink(url, token string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Write implements Sink
+func (s *WebhookSink) Write(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// EventSink records each event as a Kubernetes Event on the target object
+type EventSink struct {
+	registry *k8s.Registry
+}
+
+// NewEventSink creates a sink recording Kubernetes Events in the cluster of each event
+func NewEventSink(registry *k8s.Registry) *EventSink {
+	return &EventSink{registry: registry}
+}
+
+// Write implements Sink
+func (s *EventSink) Write(ctx context.Context, event Event) error {
+	if event.Namespace == "" || event.Name == "" {
+		return nil
+	}
+	client, ok := s.registry.Get(event.Cluster)
+	if !ok {
+		return fmt.Errorf("cluster %q is not configured", event.Cluster)
+	}
+
+	eventType := corev1.EventTypeNormal
+	if event.Result != ResultSuccess {
+		eventType = corev1.EventTypeWarning
+	}
+	message := fmt.Sprintf("%s by %s: %s (%d) in %dms", event.Action, event.Actor, event.Result, event.Status, event.DurationMs)
+	if event.Error != "" {
+		message += ": " + event.Error
+	}
+
+	return client.RecordEvent(ctx, event.Kind, event.Namespace, event.Name, eventType, "Audit", message)
+}
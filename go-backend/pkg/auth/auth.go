@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userKey is the gin context key of the authenticated User
+const userKey = "authUser"
+
+// ErrNoToken is returned when a request carries no bearer token
+var ErrNoToken = errors.New("no bearer token")
+
+// User is the identity attached to an authenticated request
+type User struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Authenticator validates a bearer token and returns the identity it belongs to
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*User, error)
+}
+
+// chain tries each authenticator in turn
+type chain []Authenticator
+
+// Chain returns an Authenticator that accepts a token if any of the given authenticators does
+func Chain(authenticators ...Authenticator) Authenticator {
+	if len(authenticators) == 1 {
+		return authenticators[0]
+	}
+	return chain(authenticators)
+}
+
+// Authenticate implements Authenticator
+func (ch chain) Authenticate(ctx context.Context, token string) (*User, error) {
+	var errs []error
+	for _, a := range ch {
+		user, err := a.Authenticate(ctx, token)
+		if err == nil {
+			return user, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// anonymous accepts every request
+type anonymous struct{}
+
+// Anonymous returns an Authenticator that accepts every request, including those without a token.
+// It is meant for local development only.
+func Anonymous() Authenticator {
+	return anonymous{}
+}
+
+// Authenticate implements Authenticator
+func (anonymous) Authenticate(ctx context.Context, token string) (*User, error) {
+	return &User{Name: "anonymous", Groups: []string{"system:unauthenticated"}}, nil
+}
+
+// Middleware rejects requests that the authenticator does not accept and
+// attaches the authenticated User to the gin context
+func Middleware(authenticator Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := bearerToken(c.Request)
+		if err != nil && err != ErrNoToken {
+			c.Header("WWW-Authenticate", `Bearer realm="wukong-dashboard", error="invalid_request"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized: " + err.Error(),
+			})
+			return
+		}
+
+		// Authenticators reject the empty token, except Anonymous
+		user, err := authenticator.Authenticate(c.Request.Context(), token)
+		if err != nil {
+			message := "invalid token"
+			if token == "" {
+				message = ErrNoToken.Error()
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="wukong-dashboard", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized: " + message,
+			})
+			return
+		}
+
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// UserFrom returns the User attached by Middleware
+func UserFrom(c *gin.Context) (*User, bool) {
+	v, ok := c.Get(userKey)
+	if !ok {
+		return nil, false
+	}
+	user, ok := v.(*User)
+	return user, ok
+}
+
+// bearerToken extracts the bearer token from the Authorization header.
+// Browsers cannot set headers on WebSocket upgrades or EventSource streams,
+// so those may pass the token in the access_token query parameter instead.
+func bearerToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", errors.New("malformed Authorization header")
+		}
+		return strings.TrimSpace(token), nil
+	}
+
+	if isWebSocketUpgrade(r) || isEventStream(r) {
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			return token, nil
+		}
+	}
+
+	return "", ErrNoToken
+}
+
+// isWebSocketUpgrade reports whether r asks for a WebSocket upgrade
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// isEventStream reports whether r asks for a Server-Sent Events stream,
+// which browsers' EventSource opens without custom headers
+func isEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// WhoAmI handles GET /api/me and returns the authenticated User
+func WhoAmI(c *gin.Context) {
+	user, ok := UserFrom(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: " + ErrNoToken.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
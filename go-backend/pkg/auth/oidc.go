@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// OIDCConfig configures JWT validation against an OpenID Connect provider
+type OIDCConfig struct {
+	// IssuerURL is the issuer; its discovery document points to the JWKS
+	IssuerURL string
+	// ClientID is the expected audience of the tokens
+	ClientID string
+	// UsernameClaim is the claim used as the user name, defaults to "sub"
+	UsernameClaim string
+	// GroupsClaim is the claim holding the user groups, defaults to "groups"
+	GroupsClaim string
+}
+
+// OIDCAuthenticator validates JWT bearer tokens issued by an OpenID Connect provider
+type OIDCAuthenticator struct {
+	verifier      *oidc.IDTokenVerifier
+	usernameClaim string
+	groupsClaim   string
+}
+
+// NewOIDCAuthenticator runs OIDC discovery against the issuer and returns an
+// authenticator that verifies token signatures with the advertised JWKS
+func NewOIDCAuthenticator(ctx context.Context, config OIDCConfig) (*OIDCAuthenticator, error) {
+	if config.IssuerURL == "" || config.ClientID == "" {
+		return nil, fmt.Errorf("OIDC issuer URL and client ID are required")
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+
+	a := &OIDCAuthenticator{
+		verifier:      provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		usernameClaim: config.UsernameClaim,
+		groupsClaim:   config.GroupsClaim,
+	}
+	if a.usernameClaim == "" {
+		a.usernameClaim = "sub"
+	}
+	if a.groupsClaim == "" {
+		a.groupsClaim = "groups"
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, token string) (*User, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	idToken, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	name, ok := claims[a.usernameClaim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("token has no %q claim", a.usernameClaim)
+	}
+
+	user := &User{Name: name}
+	switch groups := claims[a.groupsClaim].(type) {
+	case string:
+		user.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if group, ok := g.(string); ok {
+				user.Groups = append(user.Groups, group)
+			}
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID Connect provider serving discovery and a JWKS with one RSA key
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	keyID  string
+}
+
+// newTestIssuer starts a test issuer, stopped when the test ends
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, keyID: "test-key"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.server.URL,
+			"jwks_uri":                              issuer.server.URL + "/keys",
+			"authorization_endpoint":                issuer.server.URL + "/auth",
+			"token_endpoint":                        issuer.server.URL + "/token",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": issuer.keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// sign returns an RS256 JWT carrying claims, signed with key
+func (i *testIssuer) sign(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": i.keyID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// claims returns valid claims for the issuer and the "dashboard" audience, with overrides applied
+func (i *testIssuer) claims(overrides map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":    i.server.URL,
+		"aud":    "dashboard",
+		"sub":    "alice",
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+		"groups": []string{"devs", "ops"},
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	issuer := newTestIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		config    OIDCConfig
+		key       *rsa.PrivateKey
+		overrides map[string]interface{}
+		want      *User
+		wantErr   bool
+	}{
+		{
+			name: "valid token",
+			want: &User{Name: "alice", Groups: []string{"devs", "ops"}},
+		},
+		{
+			name:      "single group string",
+			overrides: map[string]interface{}{"groups": "devs"},
+			want:      &User{Name: "alice", Groups: []string{"devs"}},
+		},
+		{
+			name:      "no groups",
+			overrides: map[string]interface{}{"groups": nil},
+			want:      &User{Name: "alice"},
+		},
+		{
+			name:      "custom claims",
+			config:    OIDCConfig{UsernameClaim: "email", GroupsClaim: "roles"},
+			overrides: map[string]interface{}{"email": "alice@example.com", "roles": []string{"admin"}},
+			want:      &User{Name: "alice@example.com", Groups: []string{"admin"}},
+		},
+		{
+			name:      "missing username claim",
+			config:    OIDCConfig{UsernameClaim: "email"},
+			overrides: map[string]interface{}{},
+			wantErr:   true,
+		},
+		{
+			name:      "wrong audience",
+			overrides: map[string]interface{}{"aud": "other"},
+			wantErr:   true,
+		},
+		{
+			name:      "wrong issuer",
+			overrides: map[string]interface{}{"iss": "https://issuer.invalid"},
+			wantErr:   true,
+		},
+		{
+			name:      "expired",
+			overrides: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()},
+			wantErr:   true,
+		},
+		{
+			name:    "signed by another key",
+			key:     otherKey,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.IssuerURL = issuer.server.URL
+			config.ClientID = "dashboard"
+			a, err := NewOIDCAuthenticator(context.Background(), config)
+			if err != nil {
+				t.Fatalf("NewOIDCAuthenticator() error = %v", err)
+			}
+
+			key := tt.key
+			if key == nil {
+				key = issuer.key
+			}
+			user, err := a.Authenticate(context.Background(), issuer.sign(t, key, issuer.claims(tt.overrides)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate() = %+v, want error", user)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if !reflect.DeepEqual(user, tt.want) {
+				t.Errorf("Authenticate() = %+v, want %+v", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCAuthenticatorNoToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	a, err := NewOIDCAuthenticator(context.Background(), OIDCConfig{IssuerURL: issuer.server.URL, ClientID: "dashboard"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(context.Background(), ""); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Authenticate(\"\") error = %v, want ErrNoToken", err)
+	}
+}
+
+func TestNewOIDCAuthenticatorDiscoveryFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := NewOIDCAuthenticator(context.Background(), OIDCConfig{IssuerURL: server.URL, ClientID: "dashboard"}); err == nil {
+		t.Error("NewOIDCAuthenticator() succeeded without a discovery document")
+	}
+	if _, err := NewOIDCAuthenticator(context.Background(), OIDCConfig{IssuerURL: server.URL}); err == nil {
+		t.Error("NewOIDCAuthenticator() succeeded without a client ID")
+	}
+}
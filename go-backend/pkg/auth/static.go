@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// staticToken is one entry of a static token file
+type staticToken struct {
+	digest [sha256.Size]byte
+	user   User
+}
+
+// StaticTokenAuthenticator accepts a fixed set of tokens, meant for automation
+type StaticTokenAuthenticator struct {
+	tokens []staticToken
+}
+
+// NewStaticTokenAuthenticator loads tokens from a CSV file in the Kubernetes static token format:
+//
+//	token,user,uid,"group1,group2"
+//
+// The uid and groups columns are optional.
+func NewStaticTokenAuthenticator(path string) (*StaticTokenAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	a := &StaticTokenAuthenticator{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("%s:%d: token and user are required", path, line)
+		}
+
+		entry := staticToken{
+			digest: sha256.Sum256([]byte(record[0])),
+			user:   User{Name: record[1]},
+		}
+		if len(record) > 3 {
+			for _, group := range strings.Split(record[3], ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					entry.user.Groups = append(entry.user.Groups, group)
+				}
+			}
+		}
+		a.tokens = append(a.tokens, entry)
+	}
+
+	if len(a.tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", path)
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *StaticTokenAuthenticator) Authenticate(ctx context.Context, token string) (*User, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	// Compare digests in constant time so the token length does not leak either
+	digest := sha256.Sum256([]byte(token))
+	for _, entry := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], entry.digest[:]) == 1 {
+			user := entry.user
+			return &user, nil
+		}
+	}
+	return nil, errors.New("unknown static token")
+}
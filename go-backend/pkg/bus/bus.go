@@ -0,0 +1,123 @@
+// Package bus distributes messages between the replicas of the backend
+package bus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Message is a published message with the sequence number assigned by the bus
+type Message struct {
+	Seq  uint64
+	Data []byte
+}
+
+// Bus is a publish/subscribe channel between the replicas of the backend.
+// The messages of a topic get increasing sequence numbers and are delivered
+// in that order to every subscriber of the topic, on every replica.
+type Bus interface {
+	// Publish sends data to the subscribers of topic
+	Publish(ctx context.Context, topic string, data []byte) error
+	// Subscribe calls handler with the messages of topic until ctx is done.
+	// It returns an error if the subscription fails.
+	Subscribe(ctx context.Context, topic string, handler func(Message)) error
+	// Claim records key for ttl, for one-time actions shared between replicas.
+	// It returns false if key is already claimed.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Renew extends the claim of key to ttl from now, for claims held while an action
+	// lasts. It returns false if key is no longer claimed.
+	Renew(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Release removes the claim of key before it expires
+	Release(ctx context.Context, key string) error
+}
+
+// Memory is a Bus within a single process, for running one replica
+type Memory struct {
+	mu       sync.Mutex
+	seqs     map[string]uint64
+	handlers map[string]map[*func(Message)]struct{}
+	// claims holds the expiry of claimed keys
+	claims map[string]time.Time
+}
+
+// NewMemory creates an in-process bus
+func NewMemory() *Memory {
+	return &Memory{
+		seqs:     make(map[string]uint64),
+		handlers: make(map[string]map[*func(Message)]struct{}),
+		claims:   make(map[string]time.Time),
+	}
+}
+
+// Publish delivers data to the subscribers of topic before returning
+func (m *Memory) Publish(ctx context.Context, topic string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seqs[topic]++
+	msg := Message{Seq: m.seqs[topic], Data: data}
+	for handler := range m.handlers[topic] {
+		(*handler)(msg)
+	}
+	return nil
+}
+
+// Subscribe calls handler with the messages of topic until ctx is done
+func (m *Memory) Subscribe(ctx context.Context, topic string, handler func(Message)) error {
+	key := &handler
+	m.mu.Lock()
+	if m.handlers[topic] == nil {
+		m.handlers[topic] = make(map[*func(Message)]struct{})
+	}
+	m.handlers[topic][key] = struct{}{}
+	m.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.mu.Lock()
+	delete(m.handlers[topic], key)
+	m.mu.Unlock()
+	return nil
+}
+
+// Claim records key for ttl, returning false if key is already claimed
+func (m *Memory) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, expiry := range m.claims {
+		if now.After(expiry) {
+			delete(m.claims, k)
+		}
+	}
+	if _, ok := m.claims[key]; ok {
+		return false, nil
+	}
+	m.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Renew extends the claim of key to ttl from now, returning false if it has expired
+func (m *Memory) Renew(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	expiry, ok := m.claims[key]
+	if !ok || now.After(expiry) {
+		delete(m.claims, key)
+		return false, nil
+	}
+	m.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Release removes the claim of key
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claims, key)
+	return nil
+}
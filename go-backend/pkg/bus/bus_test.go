@@ -0,0 +1,166 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// subscribe subscribes to topic until the test ends and returns the received messages.
+// It returns once the subscription is registered.
+func subscribe(t *testing.T, m *Memory, topic string) func() []Message {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	var received []Message
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Subscribe(ctx, topic, func(msg Message) {
+			mu.Lock()
+			received = append(received, msg)
+			mu.Unlock()
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Subscribe registers the handler before blocking
+	for {
+		m.mu.Lock()
+		n := len(m.handlers[topic])
+		m.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() []Message {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Message(nil), received...)
+	}
+}
+
+func TestMemoryOrdering(t *testing.T) {
+	m := NewMemory()
+	first := subscribe(t, m, "hub")
+	second := subscribe(t, m, "hub")
+	other := subscribe(t, m, "other")
+
+	for i := 1; i <= 100; i++ {
+		if err := m.Publish(context.Background(), "hub", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Publish(context.Background(), "other", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, received := range [][]Message{first(), second()} {
+		if len(received) != 100 {
+			t.Fatalf("received %d messages, want 100", len(received))
+		}
+		for i, msg := range received {
+			if msg.Seq != uint64(i+1) || string(msg.Data) != fmt.Sprint(i+1) {
+				t.Fatalf("message %d = %d %q, want sequence %d", i, msg.Seq, msg.Data, i+1)
+			}
+		}
+	}
+
+	// Topics are sequenced independently
+	if received := other(); len(received) != 1 || received[0].Seq != 1 {
+		t.Errorf("other topic received %+v, want one message with sequence 1", received)
+	}
+}
+
+func TestMemoryConcurrentPublish(t *testing.T) {
+	m := NewMemory()
+	received := subscribe(t, m, "hub")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				m.Publish(context.Background(), "hub", nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Concurrent publishers never produce gaps or reordering
+	msgs := received()
+	if len(msgs) != 500 {
+		t.Fatalf("received %d messages, want 500", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Seq != uint64(i+1) {
+			t.Fatalf("message %d has sequence %d, want %d", i, msg.Seq, i+1)
+		}
+	}
+}
+
+func TestMemoryUnsubscribe(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	calls := 0
+	go func() {
+		done <- m.Subscribe(ctx, "hub", func(Message) { calls++ })
+	}()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	m.Publish(context.Background(), "hub", nil)
+	if calls != 0 {
+		t.Errorf("handler called %d times after its subscription ended", calls)
+	}
+	if n := len(m.handlers["hub"]); n != 0 {
+		t.Errorf("%d handlers left after the subscription ended", n)
+	}
+}
+
+func TestMemoryClaim(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	if claimed, err := m.Claim(ctx, "token", 20*time.Millisecond); err != nil || !claimed {
+		t.Fatalf("Claim() = %v, %v, want the first claim to succeed", claimed, err)
+	}
+	if claimed, _ := m.Claim(ctx, "token", time.Minute); claimed {
+		t.Error("Claim() succeeded twice for the same key")
+	}
+	if claimed, _ := m.Claim(ctx, "other", time.Minute); !claimed {
+		t.Error("Claim() failed for another key")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if renewed, _ := m.Renew(ctx, "token", time.Minute); renewed {
+		t.Error("Renew() succeeded after the claim expired")
+	}
+	if claimed, _ := m.Claim(ctx, "token", 20*time.Millisecond); !claimed {
+		t.Error("Claim() failed after the previous claim expired")
+	}
+	if renewed, err := m.Renew(ctx, "token", time.Minute); err != nil || !renewed {
+		t.Fatalf("Renew() = %v, %v, want the held claim to be renewed", renewed, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if claimed, _ := m.Claim(ctx, "token", time.Minute); claimed {
+		t.Error("Claim() succeeded while the renewed claim was held")
+	}
+
+	if err := m.Release(ctx, "token"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if claimed, _ := m.Claim(ctx, "token", time.Minute); !claimed {
+		t.Error("Claim() failed after the previous claim was released")
+	}
+}
@@ -0,0 +1,124 @@
+package bus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the keys and channels of the bus
+const redisKeyPrefix = "wukong-dashboard:bus:"
+
+// redisPublish assigns the next sequence number of a topic and publishes the message
+// atomically, so subscribers receive the messages in sequence order.
+// Published payloads are "<seq>:<data>".
+var redisPublish = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', ARGV[1], seq .. ':' .. ARGV[2])
+return seq
+`)
+
+// Redis is a Bus over Redis pub/sub, shared by all replicas connected to the same server.
+// Messages published while a replica is disconnected are lost; subscribers notice
+// the gap in sequence numbers.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis creates a bus connected to the Redis server at url, e.g. redis://redis:6379/0
+func NewRedis(url string) (*Redis, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return &Redis{client: redis.NewClient(options)}, nil
+}
+
+// Publish sends data to the subscribers of topic on every replica
+func (r *Redis) Publish(ctx context.Context, topic string, data []byte) error {
+	keys := []string{redisKeyPrefix + topic + ":seq"}
+	if err := redisPublish.Run(ctx, r.client, keys, redisKeyPrefix+topic, data).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Subscribe calls handler with the messages of topic until ctx is done.
+// The subscription is re-established after connection failures.
+func (r *Redis) Subscribe(ctx context.Context, topic string, handler func(Message)) error {
+	pubsub := r.client.Subscribe(ctx, redisKeyPrefix+topic)
+	defer pubsub.Close()
+
+	// Wait for the confirmation, so that a misconfigured server fails the subscription
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case payload, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			msg, err := parseRedisPayload(payload.Payload)
+			if err != nil {
+				log.Printf("Bus topic %s: dropping message: %v", topic, err)
+				continue
+			}
+			handler(msg)
+		}
+	}
+}
+
+// Claim records key for ttl with SET NX, so that only one replica claims it
+func (r *Redis) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	claimed, err := r.client.SetNX(ctx, redisKeyPrefix+"claim:"+key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim %s: %w", key, err)
+	}
+	return claimed, nil
+}
+
+// Renew extends the claim of key with PEXPIRE, returning false if it has expired
+func (r *Redis) Renew(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	renewed, err := r.client.PExpire(ctx, redisKeyPrefix+"claim:"+key, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew %s: %w", key, err)
+	}
+	return renewed, nil
+}
+
+// Release deletes the claim of key
+func (r *Redis) Release(ctx context.Context, key string) error {
+	if err := r.client.Del(ctx, redisKeyPrefix+"claim:"+key).Err(); err != nil {
+		return fmt.Errorf("failed to release %s: %w", key, err)
+	}
+	return nil
+}
+
+// Close closes the connection to the Redis server
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// parseRedisPayload splits a published payload into its sequence number and data
+func parseRedisPayload(payload string) (Message, error) {
+	data := []byte(payload)
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return Message{}, fmt.Errorf("missing sequence number")
+	}
+	seq, err := strconv.ParseUint(payload[:i], 10, 64)
+	if err != nil {
+		return Message{}, fmt.Errorf("invalid sequence number: %w", err)
+	}
+	return Message{Seq: seq, Data: data[i+1:]}, nil
+}
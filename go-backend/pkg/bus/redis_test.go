@@ -0,0 +1,39 @@
+package bus
+
+import (
+	"testing"
+)
+
+func TestParseRedisPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    Message
+		wantErr bool
+	}{
+		{payload: `1:{"type":"update"}`, want: Message{Seq: 1, Data: []byte(`{"type":"update"}`)}},
+		{payload: `42:a:b:c`, want: Message{Seq: 42, Data: []byte("a:b:c")}},
+		{payload: `18446744073709551615:`, want: Message{Seq: 18446744073709551615, Data: []byte{}}},
+		{payload: `no sequence`, wantErr: true},
+		{payload: `:data`, wantErr: true},
+		{payload: `-1:data`, wantErr: true},
+		{payload: `x1:data`, wantErr: true},
+		{payload: `18446744073709551616:data`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRedisPayload(tt.payload)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRedisPayload(%q) = %+v, want error", tt.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRedisPayload(%q) error = %v", tt.payload, err)
+			continue
+		}
+		if got.Seq != tt.want.Seq || string(got.Data) != string(tt.want.Data) {
+			t.Errorf("parseRedisPayload(%q) = %d %q, want %d %q", tt.payload, got.Seq, got.Data, tt.want.Seq, tt.want.Data)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+)
+
+// Bounds of the number of events returned by GET /api/audit
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// maxAuditedBodySize bounds the body of an audited request, which is read whole to be digested
+const maxAuditedBodySize = 1 << 20
+
+// maxAuditedErrorSize bounds the error response kept to fill in the error of a failed request
+const maxAuditedErrorSize = 4096
+
+// Audit returns middleware recording an audit event for the mutating route it wraps.
+// The target is the :name resource of kind in the request cluster and namespace;
+// handlers refine the action and name with audit.SetAction and audit.SetName.
+func Audit(logger *audit.Logger, kind, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		digest, err := bodyDigest(c.Writer, c.Request)
+		if err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			c.AbortWithStatusJSON(status, gin.H{
+				"error": "Failed to read request body: " + err.Error(),
+			})
+			return
+		}
+
+		writer := &errorRecorder{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		event := audit.Event{
+			Time:       start.UTC(),
+			SourceIP:   c.ClientIP(),
+			Action:     action,
+			Cluster:    ClientFrom(c).Name(),
+			Namespace:  RequestNamespace(c),
+			Kind:       kind,
+			Name:       c.Param("name"),
+			BodyDigest: digest,
+			Result:     audit.ResultSuccess,
+			Status:     c.Writer.Status(),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if user, ok := auth.UserFrom(c); ok {
+			event.Actor = user.Name
+			event.Groups = user.Groups
+		}
+		setAction, setName := audit.Annotations(c)
+		if setAction != "" {
+			event.Action = setAction
+		}
+		if setName != "" {
+			event.Name = setName
+		}
+		if event.Status >= http.StatusBadRequest {
+			event.Result = audit.ResultFailure
+			if len(c.Errors) > 0 {
+				event.Error = c.Errors.String()
+			} else {
+				event.Error = writer.message()
+			}
+		}
+
+		logger.Log(event)
+	}
+}
+
+// errorRecorder keeps the start of an error response, whose "error" field handlers
+// fill in instead of adding the error to the gin context
+type errorRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write implements gin.ResponseWriter
+func (w *errorRecorder) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *errorRecorder) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// record keeps data if the response is an error, up to maxAuditedErrorSize
+func (w *errorRecorder) record(data []byte) {
+	if w.Status() < http.StatusBadRequest {
+		return
+	}
+	if room := maxAuditedErrorSize - w.body.Len(); room > 0 {
+		w.body.Write(data[:min(len(data), room)])
+	}
+}
+
+// message returns the "error" field of the recorded JSON error response, if any
+func (w *errorRecorder) message() string {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(w.body.Bytes(), &response); err != nil {
+		return ""
+	}
+	return response.Error
+}
+
+// bodyDigest returns the SHA-256 digest of the request body and leaves the body readable.
+// Bodies larger than maxAuditedBodySize are refused with an *http.MaxBytesError.
+func bodyDigest(w http.ResponseWriter, r *http.Request) (string, error) {
+	if r.Body == nil {
+		return "", nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuditedBodySize))
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(body) == 0 {
+		return "", nil
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// AuditHandler handles audit log HTTP requests
+type AuditHandler struct {
+	logger *audit.Logger
+	// readerGroups are the groups allowed to read the audit log
+	readerGroups map[string]bool
+}
+
+// NewAuditHandler creates a new audit handler. Only members of readerGroups can read
+// the audit log, so nobody can if it is empty.
+func NewAuditHandler(logger *audit.Logger, readerGroups []string) *AuditHandler {
+	groups := make(map[string]bool)
+	for _, group := range readerGroups {
+		groups[group] = true
+	}
+	return &AuditHandler{logger: logger, readerGroups: groups}
+}
+
+// QueryAudit handles GET /api/audit
+func (h *AuditHandler) QueryAudit(c *gin.Context) {
+	if !h.canRead(c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Forbidden: reading the audit log requires membership in an audit reader group",
+		})
+		return
+	}
+
+	filter := audit.Filter{
+		Actor:     c.Query("actor"),
+		Action:    c.Query("action"),
+		Cluster:   c.Query("cluster"),
+		Namespace: c.Query("namespace"),
+		Kind:      c.Query("kind"),
+		Name:      c.Query("name"),
+		Result:    c.Query("result"),
+		Limit:     defaultAuditLimit,
+	}
+
+	var err error
+	if filter.Since, err = parseTimeQuery(c, "since"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Until, err = parseTimeQuery(c, "until"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit: " + limit})
+			return
+		}
+		if filter.Limit > maxAuditLimit {
+			filter.Limit = maxAuditLimit
+		}
+	}
+
+	events, err := h.logger.Query(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to query audit log: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// canRead reports whether the authenticated user may read the audit log
+func (h *AuditHandler) canRead(c *gin.Context) bool {
+	return InGroups(c, h.readerGroups)
+}
+
+// InGroups reports whether the authenticated user is a member of one of groups.
+// No user is if groups is empty.
+func InGroups(c *gin.Context, groups map[string]bool) bool {
+	user, ok := auth.UserFrom(c)
+	if !ok {
+		return false
+	}
+	for _, group := range user.Groups {
+		if groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimeQuery parses an optional RFC 3339 time query parameter
+func parseTimeQuery(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s: %s (expected RFC 3339 time)", key, value)
+	}
+	return t, nil
+}
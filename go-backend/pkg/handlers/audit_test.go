@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+)
+
+func TestAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		body       string
+		handler    gin.HandlerFunc
+		wantStatus int
+		wantResult string
+		wantError  string
+	}{
+		{
+			name:       "success",
+			body:       `{"name":"web-1"}`,
+			handler:    func(c *gin.Context) { c.JSON(http.StatusCreated, gin.H{"success": true}) },
+			wantStatus: http.StatusCreated,
+			wantResult: audit.ResultSuccess,
+		},
+		{
+			name: "error response",
+			body: `{"name":"web-1"}`,
+			handler: func(c *gin.Context) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Failed to create VM: already exists"})
+			},
+			wantStatus: http.StatusConflict,
+			wantResult: audit.ResultFailure,
+			wantError:  "Failed to create VM: already exists",
+		},
+		{
+			name: "gin error",
+			handler: func(c *gin.Context) {
+				c.Error(errors.New("denied"))
+				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			},
+			wantStatus: http.StatusForbidden,
+			wantResult: audit.ResultFailure,
+			wantError:  "Error #01: denied\n",
+		},
+		{
+			name:       "body too large",
+			body:       strings.Repeat("x", maxAuditedBodySize+1),
+			handler:    func(c *gin.Context) { t.Error("handler called for a body too large") },
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := audit.NewMemorySink(10)
+			logger := audit.NewLogger(sink)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				logger.Run(ctx)
+				close(done)
+			}()
+
+			router := gin.New()
+			router.POST("/vms/:name", func(c *gin.Context) {
+				c.Set(clusterClientKey, &k8s.Client{})
+			}, Audit(logger, "Wukong", "vm.create"), tt.handler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/vms/web-1?namespace=vms", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			// Stopping the logger writes the queued event
+			cancel()
+			<-done
+			events, err := sink.Query(context.Background(), audit.Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantResult == "" {
+				if len(events) != 0 {
+					t.Errorf("recorded %+v for a refused request", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("recorded %d events, want 1", len(events))
+			}
+			event := events[0]
+			if event.Result != tt.wantResult || event.Error != tt.wantError || event.Status != tt.wantStatus ||
+				event.Name != "web-1" || event.Namespace != "vms" {
+				t.Errorf("event = %+v, want result %q with error %q", event, tt.wantResult, tt.wantError)
+			}
+			if tt.body != "" && !strings.HasPrefix(event.BodyDigest, "sha256:") {
+				t.Errorf("body digest = %q, want a SHA-256 digest", event.BodyDigest)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+)
+
+// clusterClientKey is the gin context key of the k8s.Client resolved for a request
+const clusterClientKey = "k8sClient"
+
+// ClusterMiddleware resolves the :cluster route parameter to its k8s.Client.
+// Routes without a :cluster parameter are served by the default cluster.
+// With impersonate, the client acts as the authenticated user against the Kubernetes API.
+func ClusterMiddleware(registry *k8s.Registry, impersonate bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("cluster")
+		client, ok := registry.Get(name)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "Cluster not found: " + name,
+			})
+			return
+		}
+
+		if impersonate {
+			user, ok := auth.UserFrom(c)
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": "Unauthorized: no authenticated user to impersonate",
+				})
+				return
+			}
+
+			var err error
+			client, err = client.Impersonate(user.Name, user.Groups)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to impersonate user: " + err.Error(),
+				})
+				return
+			}
+		}
+
+		c.Set(clusterClientKey, client)
+		c.Next()
+	}
+}
+
+// ClientFrom returns the k8s.Client resolved by ClusterMiddleware
+func ClientFrom(c *gin.Context) *k8s.Client {
+	return c.MustGet(clusterClientKey).(*k8s.Client)
+}
+
+// ClusterHandler handles cluster registry HTTP requests
+type ClusterHandler struct {
+	registry *k8s.Registry
+}
+
+// NewClusterHandler creates a new cluster handler
+func NewClusterHandler(registry *k8s.Registry) *ClusterHandler {
+	return &ClusterHandler{registry: registry}
+}
+
+// ListClusters handles GET /api/clusters
+func (h *ClusterHandler) ListClusters(c *gin.Context) {
+	c.JSON(http.StatusOK, h.registry.Health(c.Request.Context()))
+}
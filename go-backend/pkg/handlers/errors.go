@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/quota"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// statusForError returns the HTTP status for a Kubernetes API error, or fallback
+// if the error has no more specific status
+func statusForError(err error, fallback int) int {
+	switch {
+	case apierrors.IsNotFound(err):
+		return http.StatusNotFound
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return http.StatusConflict
+	case apierrors.IsForbidden(err):
+		return http.StatusForbidden
+	case apierrors.IsInvalid(err):
+		return http.StatusUnprocessableEntity
+	default:
+		return fallback
+	}
+}
+
+// respondQuotaError writes the response for a failed quota check.
+// Exceeded limits are reported as 403 with the structured quota error, and
+// labels selecting no single project as 422.
+func respondQuotaError(c *gin.Context, err error) {
+	var exceeded *quota.ExceededError
+	var labelErr *quota.LabelError
+	if errors.As(err, &labelErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"error":   labelErr.Error(),
+			"reason":  "ProjectLabelsRequired",
+		})
+		return
+	}
+	if errors.As(err, &exceeded) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   exceeded.Error(),
+			"reason":  "QuotaExceeded",
+			"quota":   exceeded,
+		})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"success": false,
+		"error":   "Failed to check quota: " + err.Error(),
+	})
+}
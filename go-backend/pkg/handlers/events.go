@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ws "github.com/kuihuar/wukong-dashboard/go-backend/pkg/websocket"
+)
+
+// sseHeartbeatInterval is how often an idle event stream sends a comment,
+// so that proxies do not close it
+const sseHeartbeatInterval = 15 * time.Second
+
+// EventsHandler streams hub messages as Server-Sent Events
+type EventsHandler struct {
+	hub *ws.Hub
+	// impersonate limits the messages of each user to the resources it may list
+	impersonate bool
+}
+
+// NewEventsHandler creates a new Server-Sent Events handler. With impersonate, users
+// receive the messages about the resources the cluster's RBAC lets them list.
+func NewEventsHandler(hub *ws.Hub, impersonate bool) *EventsHandler {
+	return &EventsHandler{hub: hub, impersonate: impersonate}
+}
+
+// HandleEvents handles GET /api/events
+// Query: resource, cluster, namespace, name (all but cluster comma-separated lists)
+// and labelSelector filter the messages.
+// Reconnecting clients resume after the Last-Event-ID header or lastEventId query parameter.
+func (h *EventsHandler) HandleEvents(c *gin.Context) {
+	filter, err := ws.Subscription{
+		Resources:     splitQuery(c.Query("resource")),
+		Cluster:       c.Query("cluster"),
+		Namespaces:    splitQuery(c.Query("namespace")),
+		Names:         splitQuery(c.Query("name")),
+		LabelSelector: c.Query("labelSelector"),
+	}.Filter()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter: " + err.Error()})
+		return
+	}
+
+	user := streamUser(c, h.impersonate)
+	if err := h.hub.CheckAccess(c.Request.Context(), user, filter); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: " + err.Error()})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	var resumeAfter uint64
+	if lastEventID != "" {
+		resumeAfter, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID: " + lastEventID})
+			return
+		}
+	}
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming is not supported"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Disable response buffering in nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	flusher.Flush()
+
+	stream := ws.NewStream(h.hub, filter, resumeAfter, user)
+	h.hub.Register(stream)
+	defer h.hub.Unregister(stream)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.hub.Done():
+			// The server is shutting down; the client reconnects to another replica
+			return
+		case event, ok := <-stream.Events():
+			if !ok {
+				// Fell behind; the client reconnects with its Last-Event-ID
+				return
+			}
+			if !stream.Allowed(event) {
+				continue
+			}
+			// Unsequenced messages, such as metrics, leave the Last-Event-ID unchanged
+			if event.Seq > 0 {
+				if _, err := fmt.Fprintf(c.Writer, "id: %d\n", event.Seq); err != nil {
+					return
+				}
+			}
+			if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", event.Data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// splitQuery splits a comma-separated query parameter, dropping empty entries
+func splitQuery(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/history"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+)
+
+const (
+	// defaultHistoryRange is the range of a metrics query without from
+	defaultHistoryRange = time.Hour
+
+	// maxHistoryPoints bounds the points of a metrics query without step
+	maxHistoryPoints = 300
+)
+
+// HistoryHandler handles historical VM metrics HTTP requests
+type HistoryHandler struct {
+	store *history.Store
+}
+
+// NewHistoryHandler creates a new history handler
+func NewHistoryHandler(store *history.Store) *HistoryHandler {
+	return &HistoryHandler{store: store}
+}
+
+// GetVMMetricsHistory handles GET /api/vms/:name/metrics?from=&to=&step=
+// from and to are RFC 3339 times or Unix seconds, step a duration (e.g. 5m) or seconds
+func (h *HistoryHandler) GetVMMetricsHistory(c *gin.Context) {
+	now := time.Now()
+
+	to, err := parseHistoryTime(c.Query("to"), now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to: " + err.Error()})
+		return
+	}
+	from, err := parseHistoryTime(c.Query("from"), to.Add(-defaultHistoryRange))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from: " + err.Error()})
+		return
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range: from must be before to"})
+		return
+	}
+
+	step := to.Sub(from) / maxHistoryPoints
+	if value := c.Query("step"); value != "" {
+		step, err = parseHistoryStep(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step: " + err.Error()})
+			return
+		}
+	}
+
+	// The history is kept by the dashboard, so it is authorized like a cached read of the VM
+	client := ClientFrom(c)
+	key := history.Key{
+		Cluster:   client.Name(),
+		Namespace: RequestNamespace(c),
+		Name:      c.Param("name"),
+	}
+	access := k8s.Access{Verb: "get", Resource: k8s.WukongGVR, Namespace: key.Namespace}
+	if err := client.Authorize(c.Request.Context(), access); err != nil {
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
+			"error": "Failed to get VM metrics history: " + err.Error(),
+		})
+		return
+	}
+	points, step := h.store.Query(key, from, to, step)
+
+	c.JSON(http.StatusOK, gin.H{
+		"cluster":   key.Cluster,
+		"namespace": key.Namespace,
+		"name":      key.Name,
+		"from":      from.UnixMilli(),
+		"to":        to.UnixMilli(),
+		"step":      int64(step.Seconds()),
+		"points":    points,
+	})
+}
+
+// parseHistoryTime parses an RFC 3339 time or Unix seconds, or returns def if value is empty
+func parseHistoryTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMilli(int64(seconds * 1000)), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseHistoryStep parses a duration or a number of seconds
+func parseHistoryStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		value = fmt.Sprintf("%gs", seconds)
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if step <= 0 {
+		return 0, fmt.Errorf("step must be positive")
+	}
+	return step, nil
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RequestNamespace returns the namespace targeted by a single-resource request:
+// the :namespace route parameter, then the ?namespace= query, then the cluster default
+func RequestNamespace(c *gin.Context) string {
+	if namespace := c.Param("namespace"); namespace != "" {
+		return namespace
+	}
+	return c.DefaultQuery("namespace", ClientFrom(c).DefaultNamespace())
+}
+
+// listNamespace returns the namespace targeted by a list request.
+// Without a :namespace route parameter or ?namespace= query, lists span all namespaces.
+func listNamespace(c *gin.Context) string {
+	if namespace := c.Param("namespace"); namespace != "" {
+		return namespace
+	}
+	return c.DefaultQuery("namespace", metav1.NamespaceAll)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/operations"
+)
+
+// OperationHandler reports the progress of background operations
+type OperationHandler struct {
+	operations *operations.Manager
+	registry   *k8s.Registry
+	// impersonate limits the operations of each user to the resources it may list
+	impersonate bool
+}
+
+// NewOperationHandler creates a new operation handler. With impersonate, users see the
+// operations on the resources the cluster's RBAC lets them list.
+func NewOperationHandler(operations *operations.Manager, registry *k8s.Registry, impersonate bool) *OperationHandler {
+	return &OperationHandler{operations: operations, registry: registry, impersonate: impersonate}
+}
+
+// ListOperations handles GET /api/operations
+func (h *OperationHandler) ListOperations(c *gin.Context) {
+	ops := h.operations.List(operations.Filter{
+		Cluster:   c.Query("cluster"),
+		Namespace: c.Query("namespace"),
+		Resource:  c.Query("resource"),
+		Name:      c.Query("name"),
+		State:     c.Query("state"),
+	})
+
+	visible := []operations.Operation{}
+	for _, op := range ops {
+		if h.allowed(c, op) {
+			visible = append(visible, op)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// GetOperation handles GET /api/operations/:id
+func (h *OperationHandler) GetOperation(c *gin.Context) {
+	op, ok := h.operations.Get(c.Param("id"))
+	// Operations the user may not see are reported as missing, like unknown ones
+	if !ok || !h.allowed(c, op) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Operation not found: " + c.Param("id")})
+		return
+	}
+	c.JSON(http.StatusOK, op)
+}
+
+// allowed reports whether the user of the request may list the resources of the type
+// and namespace of an operation, the same check as the cached lists. Without
+// impersonation, every user acts as the dashboard and sees every operation.
+func (h *OperationHandler) allowed(c *gin.Context, op operations.Operation) bool {
+	if !h.impersonate {
+		return true
+	}
+	user, ok := auth.UserFrom(c)
+	gvr, known := k8s.ResourceTypeGVRs[op.Resource]
+	client, found := h.registry.Get(op.Cluster)
+	if !ok || !known || !found {
+		return false
+	}
+
+	allowed, err := client.UserAllowed(c.Request.Context(), user.Name, user.Groups, k8s.Access{
+		Verb:      "list",
+		Resource:  gvr,
+		Namespace: op.Namespace,
+	})
+	if err != nil {
+		log.Printf("Cluster %s: %v", op.Cluster, err)
+		return false
+	}
+	return allowed
+}
+
+// reserveOperation reserves an operation of the given type on a resource of the request,
+// before the action it tracks is made. It responds 409 with body, which may be nil, and
+// the operation in progress, and returns false if the resource has one, or 503 if the
+// reservation could not be recorded. The reservation must be started with
+// startOperation, or failed with the error of the action.
+func reserveOperation(c *gin.Context, m *operations.Manager, opType, resource, name string, body gin.H) (*operations.Reservation, bool) {
+	op := operations.Operation{
+		Type:      opType,
+		Cluster:   ClientFrom(c).Name(),
+		Namespace: RequestNamespace(c),
+		Resource:  resource,
+		Name:      name,
+	}
+	if user, ok := auth.UserFrom(c); ok {
+		op.Actor = user.Name
+	}
+
+	r, err := m.Reserve(c.Request.Context(), op)
+	if err != nil {
+		if body == nil {
+			body = gin.H{}
+		}
+		body["success"] = false
+		body["error"] = "Failed to track operation: " + err.Error()
+		if !errors.Is(err, operations.ErrBusy) {
+			c.JSON(http.StatusServiceUnavailable, body)
+			return nil, false
+		}
+		if active, ok := m.Active(op.Cluster, op.Namespace, resource, name); ok {
+			body["error"] = "Operation " + active.ID + " (" + active.Type + ") is in progress on " + name
+			body["operation"] = active
+		}
+		c.JSON(http.StatusConflict, body)
+		return nil, false
+	}
+	return r, true
+}
+
+// startOperation runs a reserved operation and responds 202 with body and the operation
+func startOperation(c *gin.Context, r *operations.Reservation, run operations.Func, body gin.H) {
+	op := r.Start(run)
+	body["success"] = true
+	body["operation"] = op
+	c.Header("Location", "/api/operations/"+op.ID)
+	c.JSON(http.StatusAccepted, body)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/quota"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ProjectHandler handles project and quota HTTP requests
+type ProjectHandler struct {
+	quotas   *quota.Manager
+	registry *k8s.Registry
+	// impersonate limits the projects of each user to those whose VMs it may list
+	impersonate bool
+}
+
+// NewProjectHandler creates a new project handler. With impersonate, users see the
+// projects whose namespaces the cluster's RBAC lets them list VMs in.
+func NewProjectHandler(quotas *quota.Manager, registry *k8s.Registry, impersonate bool) *ProjectHandler {
+	return &ProjectHandler{quotas: quotas, registry: registry, impersonate: impersonate}
+}
+
+// ListProjects handles GET /api/projects
+func (h *ProjectHandler) ListProjects(c *gin.Context) {
+	projects := []*quota.Project{}
+	for _, p := range h.quotas.Projects() {
+		if h.allowed(c, p) {
+			projects = append(projects, p)
+		}
+	}
+	c.JSON(http.StatusOK, projects)
+}
+
+// allowed reports whether the user of the request may list the VMs of every namespace
+// of a project, or of all namespaces for a project without namespaces. Without
+// impersonation, every user acts as the dashboard and sees every project.
+func (h *ProjectHandler) allowed(c *gin.Context, p *quota.Project) bool {
+	if !h.impersonate {
+		return true
+	}
+	user, ok := auth.UserFrom(c)
+	client, found := h.registry.Get(p.Cluster)
+	if !ok || !found {
+		return false
+	}
+
+	namespaces := p.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, namespace := range namespaces {
+		allowed, err := client.UserAllowed(c.Request.Context(), user.Name, user.Groups, k8s.Access{
+			Verb:      "list",
+			Resource:  k8s.WukongGVR,
+			Namespace: namespace,
+		})
+		if err != nil {
+			log.Printf("Cluster %s: %v", p.Cluster, err)
+			return false
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// createWithinQuota assigns a new Wukong to its project, checks the project quota and
+// creates the Wukong, serialized with the other quota checks of the project.
+// On failure it writes the response, using failure to describe create errors.
+func createWithinQuota(c *gin.Context, quotas *quota.Manager, client *k8s.Client, wukong *unstructured.Unstructured, failure string) (*unstructured.Unstructured, error) {
+	ctx := c.Request.Context()
+	if err := quotas.Assign(client.Name(), wukong); err != nil {
+		respondQuotaError(c, err)
+		return nil, err
+	}
+
+	var created *unstructured.Unstructured
+	var quotaErr error
+	err := quotas.Serialize(ctx, client.Name(), wukong, func() error {
+		if quotaErr = quotas.CheckCreate(ctx, client, wukong); quotaErr != nil {
+			return quotaErr
+		}
+		var err error
+		created, err = client.CreateWukong(ctx, wukong.GetNamespace(), wukong)
+		return err
+	})
+	switch {
+	case quotaErr != nil:
+		respondQuotaError(c, quotaErr)
+	case err != nil:
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
+			"error": failure + ": " + err.Error(),
+		})
+	}
+	return created, err
+}
+
+// GetProjectQuota handles GET /api/projects/:id/quota
+func (h *ProjectHandler) GetProjectQuota(c *gin.Context) {
+	id := c.Param("id")
+	project, ok := h.quotas.Project(id)
+	// Projects the user may not see are reported as missing, like unknown ones
+	if !ok || !h.allowed(c, project) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Project not found: " + id,
+		})
+		return
+	}
+
+	used, err := h.quotas.Usage(c.Request.Context(), project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get quota usage: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"quota":   project.Quota,
+		"usage":   quota.UsageOf(used),
+	})
+}
@@ -4,27 +4,34 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/operations"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/quota"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // SnapshotHandler handles snapshot-related HTTP requests
+// The cluster client of each request is resolved by ClusterMiddleware.
 type SnapshotHandler struct {
-	client *k8s.Client
+	quotas     *quota.Manager
+	operations *operations.Manager
 }
 
-// NewSnapshotHandler creates a new snapshot handler
-func NewSnapshotHandler(client *k8s.Client) *SnapshotHandler {
-	return &SnapshotHandler{client: client}
+// NewSnapshotHandler creates a new snapshot handler tracking snapshots and restores with ops.
+// quotas may be nil to disable quota enforcement.
+func NewSnapshotHandler(quotas *quota.Manager, ops *operations.Manager) *SnapshotHandler {
+	return &SnapshotHandler{quotas: quotas, operations: ops}
 }
 
 // ListSnapshots handles GET /api/snapshots
 func (h *SnapshotHandler) ListSnapshots(c *gin.Context) {
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
-	snapshots, err := h.client.ListSnapshots(ctx)
+	snapshots, err := client.ListSnapshots(ctx, listNamespace(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"error": "Failed to list snapshots: " + err.Error(),
 		})
 		return
@@ -42,11 +49,13 @@ func (h *SnapshotHandler) ListSnapshots(c *gin.Context) {
 // ListSnapshotsByVM handles GET /api/vms/:name/snapshots
 func (h *SnapshotHandler) ListSnapshotsByVM(c *gin.Context) {
 	vmName := c.Param("name")
+	namespace := RequestNamespace(c)
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
-	snapshots, err := h.client.ListSnapshots(ctx)
+	snapshots, err := client.ListSnapshots(ctx, namespace)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"error": "Failed to list snapshots: " + err.Error(),
 		})
 		return
@@ -70,7 +79,7 @@ type CreateSnapshotRequest struct {
 	WukongName string `json:"wukongName" binding:"required"`
 }
 
-// CreateSnapshot handles POST /api/snapshots
+// CreateSnapshot handles POST /api/snapshots. The snapshot is tracked by an operation.
 func (h *SnapshotHandler) CreateSnapshot(c *gin.Context) {
 	var req CreateSnapshotRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -79,34 +88,42 @@ func (h *SnapshotHandler) CreateSnapshot(c *gin.Context) {
 		})
 		return
 	}
+	audit.SetName(c, req.Name)
 
 	ctx := c.Request.Context()
-	namespace := c.DefaultQuery("namespace", "default")
+	client := ClientFrom(c)
+	namespace := RequestNamespace(c)
 
 	// Verify the VM exists
-	_, err := h.client.GetWukong(ctx, req.WukongName)
+	_, err := client.GetWukong(ctx, namespace, req.WukongName)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
+		c.JSON(statusForError(err, http.StatusNotFound), gin.H{
 			"error": "Virtual machine not found: " + err.Error(),
 		})
 		return
 	}
 
+	reservation, ok := reserveOperation(c, h.operations, "snapshot.create", "snapshot", req.Name, nil)
+	if !ok {
+		return
+	}
+
 	snapshot := k8s.BuildSnapshotObject(req.Name, namespace, req.WukongName)
-	created, err := h.client.CreateSnapshot(ctx, snapshot)
+	created, err := client.CreateSnapshot(ctx, namespace, snapshot)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		reservation.Fail(err)
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"error": "Failed to create snapshot: " + err.Error(),
 		})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success": true,
-		"id":      string(created.GetUID()),
-		"name":    created.GetName(),
-		"message": "Snapshot created successfully",
-	})
+	startOperation(c, reservation,
+		operations.AwaitSnapshot(client, namespace, created.GetName()), gin.H{
+			"id":      string(created.GetUID()),
+			"name":    created.GetName(),
+			"message": "Snapshot creation started",
+		})
 }
 
 // RestoreSnapshotRequest represents the request body for restoring a snapshot
@@ -114,44 +131,31 @@ type RestoreSnapshotRequest struct {
 	NewVMName string `json:"newVmName,omitempty"`
 }
 
-// RestoreSnapshot handles POST /api/snapshots/:name/restore
+// RestoreSnapshot handles POST /api/snapshots/:name/restore. The restored VM is tracked
+// by an operation.
 func (h *SnapshotHandler) RestoreSnapshot(c *gin.Context) {
 	snapshotName := c.Param("name")
 	var req RestoreSnapshotRequest
 	c.ShouldBindJSON(&req) // Optional binding
 
+	namespace := RequestNamespace(c)
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
 	// Get the snapshot to find the original VM
-	snapshots, err := h.client.ListSnapshots(ctx)
+	snapshot, err := client.GetSnapshot(ctx, namespace, snapshotName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get snapshot: " + err.Error(),
-		})
-		return
-	}
-
-	var targetSnapshot *k8s.SnapshotInfo
-	for _, s := range snapshots {
-		obj := &unstructured.Unstructured{Object: s}
-		info := k8s.ConvertSnapshotToInfo(obj)
-		if obj.GetName() == snapshotName {
-			targetSnapshot = info
-			break
-		}
-	}
-
-	if targetSnapshot == nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Snapshot not found",
+		c.JSON(statusForError(err, http.StatusNotFound), gin.H{
+			"error": "Snapshot not found: " + err.Error(),
 		})
 		return
 	}
+	targetSnapshot := k8s.ConvertSnapshotToInfo(snapshot)
 
 	// Get the original VM spec
-	originalVM, err := h.client.GetWukong(ctx, targetSnapshot.WukongName)
+	originalVM, err := client.GetWukong(ctx, namespace, targetSnapshot.WukongName)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
+		c.JSON(statusForError(err, http.StatusNotFound), gin.H{
 			"error": "Original VM not found: " + err.Error(),
 		})
 		return
@@ -166,33 +170,45 @@ func (h *SnapshotHandler) RestoreSnapshot(c *gin.Context) {
 	spec, _, _ := unstructured.NestedMap(originalVM.Object, "spec")
 	spec["restoreFromSnapshot"] = snapshotName
 
-	namespace := c.DefaultQuery("namespace", "default")
 	newVM := k8s.BuildWukongObject(newName, namespace, spec)
+	if labels := originalVM.GetLabels(); len(labels) > 0 {
+		newVM.SetLabels(labels)
+	}
+
+	reservation, ok := reserveOperation(c, h.operations, "snapshot.restore", "vm", newName, nil)
+	if !ok {
+		return
+	}
 
-	created, err := h.client.CreateWukong(ctx, newVM)
+	created, err := createWithinQuota(c, h.quotas, client, newVM, "Failed to restore from snapshot")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to restore from snapshot: " + err.Error(),
-		})
+		reservation.Fail(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"id":      string(created.GetUID()),
-		"name":    created.GetName(),
-		"message": "VM restored from snapshot successfully",
-	})
+	// The restored VM settles in the running state of the original one
+	status := operations.VMStopped
+	if running, _, _ := unstructured.NestedBool(spec, "running"); running {
+		status = operations.VMRunning
+	}
+	startOperation(c, reservation,
+		operations.AwaitVM(client, namespace, created.GetName(), status), gin.H{
+			"id":      string(created.GetUID()),
+			"name":    created.GetName(),
+			"message": "VM restore from snapshot started",
+		})
 }
 
 // DeleteSnapshot handles DELETE /api/snapshots/:name
 func (h *SnapshotHandler) DeleteSnapshot(c *gin.Context) {
 	name := c.Param("name")
+	namespace := RequestNamespace(c)
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
-	err := h.client.DeleteSnapshot(ctx, name)
+	err := client.DeleteSnapshot(ctx, namespace, name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"success": false,
 			"error":   "Failed to delete snapshot: " + err.Error(),
 		})
@@ -4,29 +4,36 @@ import (
 	"net/http"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/operations"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/quota"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // VMHandler handles VM-related HTTP requests
+// The cluster client of each request is resolved by ClusterMiddleware.
 type VMHandler struct {
-	client *k8s.Client
+	quotas     *quota.Manager
+	operations *operations.Manager
 }
 
-// NewVMHandler creates a new VM handler
-func NewVMHandler(client *k8s.Client) *VMHandler {
-	return &VMHandler{client: client}
+// NewVMHandler creates a new VM handler tracking changes with ops. quotas may be nil to disable quota enforcement.
+func NewVMHandler(quotas *quota.Manager, ops *operations.Manager) *VMHandler {
+	return &VMHandler{quotas: quotas, operations: ops}
 }
 
 // ListVMs handles GET /api/vms
 func (h *VMHandler) ListVMs(c *gin.Context) {
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
-	wukongs, err := h.client.ListWukongs(ctx)
+	wukongs, err := client.ListWukongs(ctx, listNamespace(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"error": "Failed to list VMs: " + err.Error(),
 		})
 		return
@@ -35,27 +42,8 @@ func (h *VMHandler) ListVMs(c *gin.Context) {
 	var vms []*k8s.VMInfo
 	for _, w := range wukongs {
 		obj := &unstructured.Unstructured{Object: w}
-		vm := k8s.ConvertWukongToVMInfo(obj)
-		
-		// Get actual VM status from KubeVirt VM resource if vmName exists
-		status, _, _ := unstructured.NestedMap(w, "status")
-		if vmName, ok, _ := unstructured.NestedString(status, "vmName"); ok && vmName != "" {
-			actualStatus, err := h.client.GetVMStatus(ctx, vmName)
-			if err == nil && actualStatus != "" {
-				// Update status from actual VM resource
-				vm.Status = actualStatus
-			}
-			
-			// Get metrics if VM is running
-			if vm.Status == "Running" {
-				metrics, err := h.client.GetVMMetrics(ctx, vmName, vm.CPU, vm.Memory, obj)
-				if err == nil && metrics != nil {
-					vm.Metrics = metrics
-				}
-			}
-		}
-		
-		vms = append(vms, vm)
+		// Status from the KubeVirt VM resource, and metrics if the VM is running
+		vms = append(vms, client.GetVMInfo(ctx, obj, true))
 	}
 
 	c.JSON(http.StatusOK, vms)
@@ -64,36 +52,21 @@ func (h *VMHandler) ListVMs(c *gin.Context) {
 // GetVM handles GET /api/vms/:name
 func (h *VMHandler) GetVM(c *gin.Context) {
 	name := c.Param("name")
+	namespace := RequestNamespace(c)
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
-	wukong, err := h.client.GetWukong(ctx, name)
+	wukong, err := client.GetWukong(ctx, namespace, name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
+		c.JSON(statusForError(err, http.StatusNotFound), gin.H{
 			"error": "VM not found: " + err.Error(),
 		})
 		return
 	}
 
-	vm := k8s.ConvertWukongToVMInfo(wukong)
-	
-	// Get actual VM status from KubeVirt VM resource if vmName exists
-	status, _, _ := unstructured.NestedMap(wukong.Object, "status")
-	if vmName, ok, _ := unstructured.NestedString(status, "vmName"); ok && vmName != "" {
-		actualStatus, err := h.client.GetVMStatus(ctx, vmName)
-		if err == nil && actualStatus != "" {
-			// Update status from actual VM resource
-			vm.Status = actualStatus
-		}
-		
-		// Get metrics if VM is running
-		if vm.Status == "Running" {
-			metrics, err := h.client.GetVMMetrics(ctx, vmName, vm.CPU, vm.Memory, wukong)
-			if err == nil && metrics != nil {
-				vm.Metrics = metrics
-			}
-		}
-	}
-	
+	// Status from the KubeVirt VM resource, and metrics if the VM is running
+	vm := client.GetVMInfo(ctx, wukong, true)
+
 	c.JSON(http.StatusOK, vm)
 }
 
@@ -106,9 +79,10 @@ type CreateVMRequest struct {
 	Networks []map[string]interface{} `json:"networks"`
 	Disks    []map[string]interface{} `json:"disks" binding:"required"`
 	GPUs     []map[string]interface{} `json:"gpus,omitempty"`
+	Labels   map[string]string        `json:"labels,omitempty"`
 }
 
-// CreateVM handles POST /api/vms
+// CreateVM handles POST /api/vms. Provisioning is tracked by an operation.
 func (h *VMHandler) CreateVM(c *gin.Context) {
 	var req CreateVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -117,8 +91,9 @@ func (h *VMHandler) CreateVM(c *gin.Context) {
 		})
 		return
 	}
+	audit.SetName(c, req.Name)
 
-	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
 	// Build spec
 	spec := map[string]interface{}{
@@ -174,34 +149,42 @@ func (h *VMHandler) CreateVM(c *gin.Context) {
 		spec["gpus"] = req.GPUs
 	}
 
-	// Get namespace from query or use default
-	namespace := c.DefaultQuery("namespace", "default")
+	namespace := RequestNamespace(c)
 	wukong := k8s.BuildWukongObject(req.Name, namespace, spec)
+	if len(req.Labels) > 0 {
+		wukong.SetLabels(req.Labels)
+	}
+
+	reservation, ok := reserveOperation(c, h.operations, "vm.create", "vm", req.Name, nil)
+	if !ok {
+		return
+	}
 
-	created, err := h.client.CreateWukong(ctx, wukong)
+	created, err := createWithinQuota(c, h.quotas, client, wukong, "Failed to create VM")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create VM: " + err.Error(),
-		})
+		reservation.Fail(err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"success": true,
-		"id":      string(created.GetUID()),
-		"name":    created.GetName(),
-		"message": "Virtual machine created successfully",
-	})
+	startOperation(c, reservation,
+		operations.AwaitVM(client, namespace, created.GetName(), operations.VMRunning), gin.H{
+			"id":      string(created.GetUID()),
+			"name":    created.GetName(),
+			"message": "Virtual machine created, starting",
+		})
 }
 
 // VMActionRequest represents the request body for VM actions
 type VMActionRequest struct {
-	Action string `json:"action" binding:"required,oneof=start stop restart delete"`
+	Action string `json:"action" binding:"required,oneof=start stop shutdown force-stop restart soft-reboot pause unpause delete"`
+	// TimeoutSeconds bounds a graceful shutdown before the VM is forced off
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty" binding:"omitempty,min=1"`
 }
 
-// VMAction handles POST /api/vms/:name/action
+// VMAction handles POST /api/vms/:name/action. The action is tracked by an operation.
 func (h *VMHandler) VMAction(c *gin.Context) {
 	name := c.Param("name")
+	namespace := RequestNamespace(c)
 	var req VMActionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -209,47 +192,138 @@ func (h *VMHandler) VMAction(c *gin.Context) {
 		})
 		return
 	}
+	audit.SetAction(c, "vm."+req.Action)
+
+	timeout := operations.DefaultShutdownTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	if req.Action == "shutdown" && timeout >= h.operations.Timeout() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid request: timeoutSeconds must be less than the operation timeout of %s", h.operations.Timeout()),
+		})
+		return
+	}
+
+	// Reserved before the VM is changed, so that concurrent actions cannot both change it
+	reservation, ok := reserveOperation(c, h.operations, "vm."+req.Action, "vm", name, nil)
+	if !ok {
+		return
+	}
 
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
+
+	// Actions on the guest go to the KubeVirt VM and VMI, named by the Wukong status
+	var vmName string
+	switch req.Action {
+	case "shutdown", "force-stop", "soft-reboot", "pause", "unpause":
+		wukong, err := client.GetWukong(ctx, namespace, name)
+		if err != nil {
+			reservation.Fail(err)
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
+				"success": false,
+				"error":   "Action failed: " + err.Error(),
+			})
+			return
+		}
+		if vmName = k8s.VMNameOf(wukong); vmName == "" {
+			err := fmt.Errorf("the KubeVirt VM of %s has not been created yet", name)
+			reservation.Fail(err)
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"error":   "Action failed: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	var err error
 	var message string
+	// run drives the action to completion in the background, until the VM has targetStatus
+	var run operations.Func
+	var targetStatus string
 
 	switch req.Action {
 	case "start":
-		err = h.client.StartVM(ctx, name)
-		message = "Virtual machine started"
+		err = client.StartVM(ctx, namespace, name)
+		message = "Virtual machine starting"
+		targetStatus = operations.VMRunning
+		run = operations.AwaitVM(client, namespace, name, targetStatus)
 	case "stop":
-		err = h.client.StopVM(ctx, name)
-		message = "Virtual machine stopped"
+		err = client.StopVM(ctx, namespace, name)
+		message = "Virtual machine stopping"
+		targetStatus = operations.VMStopped
+		run = operations.AwaitVM(client, namespace, name, targetStatus)
+	case "shutdown":
+		// Stopping the VM sends the guest an ACPI power-off; the operation forces it off after timeout
+		err = client.StopVM(ctx, namespace, name)
+		message = fmt.Sprintf("Virtual machine shutting down, forced off after %s", timeout)
+		targetStatus = operations.VMStopped
+		run = operations.ShutdownVM(client, namespace, name, vmName, timeout)
+	case "force-stop":
+		// Stopped in the Wukong too, so that the operator does not start the VM again
+		if err = client.StopVM(ctx, namespace, name); err == nil {
+			err = client.ForceStopVM(ctx, namespace, vmName)
+		}
+		message = "Virtual machine forced off"
+		targetStatus = operations.VMStopped
+		run = operations.AwaitVM(client, namespace, name, targetStatus)
 	case "restart":
-		err = h.client.RestartVM(ctx, name)
-		message = "Virtual machine restarted"
+		// Stopped here, started again by the operation once the VM is down
+		err = client.StopVM(ctx, namespace, name)
+		message = "Virtual machine restarting"
+		targetStatus = operations.VMRunning
+		run = operations.RestartVM(client, namespace, name)
+	case "soft-reboot":
+		// The agent's last transition, read first, tells its reconnection after the reboot
+		var previous string
+		if previous, err = operations.AgentTransition(ctx, client, namespace, vmName); err == nil {
+			err = client.SoftRebootVMI(ctx, namespace, vmName)
+		}
+		message = "Guest OS rebooting"
+		targetStatus = operations.VMRunning
+		run = operations.AwaitReboot(client, namespace, vmName, previous)
+	case "pause":
+		err = client.PauseVMI(ctx, namespace, vmName)
+		message = "Virtual machine pausing"
+		targetStatus = operations.VMPaused
+		run = operations.AwaitVM(client, namespace, name, targetStatus)
+	case "unpause":
+		err = client.UnpauseVMI(ctx, namespace, vmName)
+		message = "Virtual machine resuming"
+		targetStatus = operations.VMRunning
+		run = operations.AwaitVM(client, namespace, name, targetStatus)
 	case "delete":
-		err = h.client.DeleteWukong(ctx, name)
-		message = "Virtual machine deleted"
+		err = client.DeleteWukong(ctx, namespace, name)
+		message = "Virtual machine deleting"
+		run = operations.AwaitVMDeleted(client, namespace, name)
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		reservation.Fail(err)
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"success": false,
 			"error":   "Action failed: " + err.Error(),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": message,
-	})
+	body := gin.H{"message": message}
+	if targetStatus != "" {
+		body["targetStatus"] = targetStatus
+	}
+	startOperation(c, reservation, run, body)
 }
 
 // GetVMStats handles GET /api/vms/stats
 func (h *VMHandler) GetVMStats(c *gin.Context) {
 	ctx := c.Request.Context()
+	client := ClientFrom(c)
 
-	wukongs, err := h.client.ListWukongs(ctx)
+	wukongs, err := client.ListWukongs(ctx, listNamespace(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
 			"error": "Failed to get stats: " + err.Error(),
 		})
 		return
@@ -261,6 +335,7 @@ func (h *VMHandler) GetVMStats(c *gin.Context) {
 		Stopped     int    `json:"stopped"`
 		Error       int    `json:"error"`
 		Pending     int    `json:"pending"`
+		Paused      int    `json:"paused"`
 		TotalCPU    int64  `json:"totalCpu"`
 		TotalMemory string `json:"totalMemory"`
 	}{}
@@ -268,17 +343,8 @@ func (h *VMHandler) GetVMStats(c *gin.Context) {
 	var totalMemoryGi int64
 	for _, w := range wukongs {
 		obj := &unstructured.Unstructured{Object: w}
-		vm := k8s.ConvertWukongToVMInfo(obj)
-
-		// Get actual VM status from KubeVirt VM resource if vmName exists
-		status, _, _ := unstructured.NestedMap(w, "status")
-		if vmName, ok, _ := unstructured.NestedString(status, "vmName"); ok && vmName != "" {
-			actualStatus, err := h.client.GetVMStatus(ctx, vmName)
-			if err == nil && actualStatus != "" {
-				// Update status from actual VM resource
-				vm.Status = actualStatus
-			}
-		}
+		// Status from the KubeVirt VM resource
+		vm := client.GetVMInfo(ctx, obj, false)
 
 		stats.Total++
 		stats.TotalCPU += vm.CPU
@@ -303,6 +369,8 @@ func (h *VMHandler) GetVMStats(c *gin.Context) {
 			stats.Running++
 		case statusLower == "stopped":
 			stats.Stopped++
+		case statusLower == "paused":
+			stats.Paused++
 		case statusLower == "error" || statusLower == "failed":
 			stats.Error++
 		case statusLower == "pending" || statusLower == "scheduling" || statusLower == "creating":
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/operations"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/quota"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// How a change of a VM spec takes effect
+const (
+	// ApplyLive changes are hot-applied to the running VM
+	ApplyLive = "live"
+	// ApplyRestart changes take effect when the running VM restarts
+	ApplyRestart = "restart"
+	// ApplyNextStart changes take effect when the stopped VM starts
+	ApplyNextStart = "nextStart"
+)
+
+// UpdateVMRequest represents the request body for updating a VM. Omitted fields are
+// left unchanged. A list replaces the current one; its items are matched by name with
+// the current items, whose fields they override, so that an item only needs its changes.
+type UpdateVMRequest struct {
+	CPU      *int64                   `json:"cpu" binding:"omitempty,min=1,max=64"`
+	Memory   *string                  `json:"memory"`
+	Networks []map[string]interface{} `json:"networks"`
+	Disks    []map[string]interface{} `json:"disks"`
+	GPUs     []map[string]interface{} `json:"gpus"`
+	// Restart restarts the VM if a change requires it
+	Restart bool `json:"restart"`
+}
+
+// SpecChange describes one change of a VM spec
+type SpecChange struct {
+	// Field is the changed field, e.g. "cpu" or "disks[data]"
+	Field string `json:"field"`
+	// Action is added, removed or changed
+	Action string `json:"action"`
+	// Apply is ApplyLive, ApplyRestart or ApplyNextStart
+	Apply string `json:"apply"`
+}
+
+// UpdateVM handles PATCH /api/vms/:name. A restart requested to apply the changes is
+// tracked by an operation.
+func (h *VMHandler) UpdateVM(c *gin.Context) {
+	name := c.Param("name")
+	namespace := RequestNamespace(c)
+	var req UpdateVMRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	// A requested restart is reserved before the patch, so that no other action on the
+	// VM starts in between. The reservation is failed or skipped if the VM is not restarted.
+	var reservation *operations.Reservation
+	if req.Restart {
+		var ok bool
+		if reservation, ok = reserveOperation(c, h.operations, "vm.restart", "vm", name, nil); !ok {
+			return
+		}
+	}
+	fail := func(err error) {
+		if reservation != nil {
+			reservation.Fail(err)
+		}
+	}
+
+	ctx := c.Request.Context()
+	client := ClientFrom(c)
+
+	// The quota check and the patch are serialized with the other changes of the VM's project
+	existing, err := client.GetWukong(ctx, namespace, name)
+	if err != nil {
+		fail(err)
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
+			"success": false,
+			"error":   "Failed to update VM: " + err.Error(),
+		})
+		return
+	}
+
+	// Validation and the quota check are repeated if the Wukong changes before the patch
+	var changes []SpecChange
+	err = h.quotas.Serialize(ctx, client.Name(), existing, func() error {
+		_, err := client.ModifyWukong(ctx, namespace, name, func(wukong *unstructured.Unstructured) (map[string]interface{}, error) {
+			current, _, _ := unstructured.NestedMap(wukong.Object, "spec")
+			if current == nil {
+				current = map[string]interface{}{}
+			}
+
+			running := client.GetVMInfo(ctx, wukong, false).Status == "Running"
+			changed, specChanges, err := diffSpec(current, &req, running)
+			if err != nil {
+				return nil, &invalidSpecError{err}
+			}
+			changes = specChanges
+			if len(changes) == 0 {
+				return nil, nil
+			}
+
+			updated := wukong.DeepCopy()
+			for field, value := range changed {
+				if err := unstructured.SetNestedField(updated.Object, value, "spec", field); err != nil {
+					return nil, err
+				}
+			}
+			if err := h.quotas.CheckUpdate(ctx, client, wukong, updated); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"spec": changed}, nil
+		})
+		return err
+	})
+
+	if err != nil {
+		fail(err)
+	}
+	var invalid *invalidSpecError
+	var exceeded *quota.ExceededError
+	var labelErr *quota.LabelError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request: " + invalid.Error(),
+		})
+		return
+	case errors.As(err, &exceeded), errors.As(err, &labelErr):
+		respondQuotaError(c, err)
+		return
+	case err != nil:
+		c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
+			"success": false,
+			"error":   "Failed to update VM: " + err.Error(),
+		})
+		return
+	case len(changes) == 0:
+		if reservation != nil {
+			reservation.Skip("No changes")
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":         true,
+			"message":         "No changes",
+			"changes":         changes,
+			"restartRequired": false,
+		})
+		return
+	}
+
+	restartRequired := false
+	for _, change := range changes {
+		if change.Apply == ApplyRestart {
+			restartRequired = true
+		}
+	}
+
+	if restartRequired && reservation != nil {
+		// Stopped here, started again by the operation once the VM is down
+		if err := client.StopVM(ctx, namespace, name); err != nil {
+			reservation.Fail(err)
+			c.JSON(statusForError(err, http.StatusInternalServerError), gin.H{
+				"success": false,
+				"error":   "Virtual machine updated, but restart failed: " + err.Error(),
+				"changes": changes,
+			})
+			return
+		}
+		startOperation(c, reservation, operations.RestartVM(client, namespace, name), gin.H{
+			"message":         "Virtual machine updated, restarting to apply all changes",
+			"changes":         changes,
+			"restartRequired": true,
+		})
+		return
+	}
+
+	if reservation != nil {
+		reservation.Skip("No change requires a restart")
+	}
+	message := "Virtual machine updated"
+	if restartRequired {
+		message = "Virtual machine updated, restart required to apply all changes"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"message":         message,
+		"changes":         changes,
+		"restartRequired": restartRequired,
+	})
+}
+
+// invalidSpecError reports a request that does not apply to the current spec of a VM
+type invalidSpecError struct {
+	err error
+}
+
+// Error implements error
+func (e *invalidSpecError) Error() string {
+	return e.err.Error()
+}
+
+// diffSpec validates a request against the current spec of a VM, returning the spec
+// fields to patch and the changes they make
+func diffSpec(current map[string]interface{}, req *UpdateVMRequest, running bool) (map[string]interface{}, []SpecChange, error) {
+	changed := map[string]interface{}{}
+	changes := []SpecChange{}
+	// apply picks how a change takes effect: live if the running VM supports it hot.
+	// CPU and memory changes need a restart, since the operator does not hotplug them.
+	apply := func(live bool) string {
+		switch {
+		case !running:
+			return ApplyNextStart
+		case live:
+			return ApplyLive
+		default:
+			return ApplyRestart
+		}
+	}
+
+	if req.CPU != nil {
+		old := quota.ToInt64(current["cpu"])
+		if *req.CPU != old {
+			changed["cpu"] = *req.CPU
+			changes = append(changes, SpecChange{Field: "cpu", Action: "changed", Apply: apply(false)})
+		}
+	}
+
+	if req.Memory != nil {
+		memory, err := resource.ParseQuantity(*req.Memory)
+		if err != nil || memory.Sign() <= 0 {
+			return nil, nil, fmt.Errorf("invalid memory %q", *req.Memory)
+		}
+		old, _ := current["memory"].(string)
+		oldMemory, err := resource.ParseQuantity(old)
+		if err != nil || memory.Cmp(oldMemory) != 0 {
+			changed["memory"] = *req.Memory
+			changes = append(changes, SpecChange{Field: "memory", Action: "changed", Apply: apply(false)})
+		}
+	}
+
+	if req.Networks != nil {
+		if len(req.Networks) == 0 {
+			return nil, nil, fmt.Errorf("a VM needs at least one network")
+		}
+		items, listChanges, err := diffList("networks", current["networks"], req.Networks, apply(false), func(old, item map[string]interface{}) (string, error) {
+			return apply(old == nil), nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(listChanges) > 0 {
+			changed["networks"] = items
+			changes = append(changes, listChanges...)
+		}
+	}
+
+	if req.Disks != nil {
+		items, listChanges, err := diffList("disks", current["disks"], req.Disks, apply(false), func(old, item map[string]interface{}) (string, error) {
+			return diffDisk(old, item, apply)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, disk := range diffListRemoved(current["disks"], req.Disks) {
+			if boot, _ := disk["boot"].(bool); boot {
+				return nil, nil, fmt.Errorf("the boot disk %q cannot be removed", disk["name"])
+			}
+		}
+		if len(listChanges) > 0 {
+			changed["disks"] = items
+			changes = append(changes, listChanges...)
+		}
+	}
+
+	if req.GPUs != nil {
+		items, listChanges, err := diffList("gpus", current["gpus"], req.GPUs, apply(false), func(old, item map[string]interface{}) (string, error) {
+			if deviceName, _ := item["deviceName"].(string); deviceName == "" {
+				return "", fmt.Errorf("GPU %q needs a deviceName", item["name"])
+			}
+			return apply(false), nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(listChanges) > 0 {
+			changed["gpus"] = items
+			changes = append(changes, listChanges...)
+		}
+	}
+
+	return changed, changes, nil
+}
+
+// diffDisk validates a new or changed disk, returning how the change takes effect.
+// Disks can be added and grown live; their storage class and boot flag cannot change.
+func diffDisk(old, disk map[string]interface{}, apply func(live bool) string) (string, error) {
+	size, _ := disk["size"].(string)
+	newSize, err := resource.ParseQuantity(size)
+	if err != nil || newSize.Sign() <= 0 {
+		return "", fmt.Errorf("invalid size %q of disk %q", size, disk["name"])
+	}
+
+	if old == nil {
+		if boot, _ := disk["boot"].(bool); boot {
+			return "", fmt.Errorf("disk %q cannot be added as a boot disk", disk["name"])
+		}
+		return apply(true), nil
+	}
+
+	if !reflect.DeepEqual(old["boot"], disk["boot"]) {
+		return "", fmt.Errorf("the boot flag of disk %q cannot be changed", disk["name"])
+	}
+	if !reflect.DeepEqual(old["storageClassName"], disk["storageClassName"]) {
+		return "", fmt.Errorf("the storage class of disk %q cannot be changed", disk["name"])
+	}
+	oldSize, _ := old["size"].(string)
+	if oldQuantity, err := resource.ParseQuantity(oldSize); err == nil {
+		switch newSize.Cmp(oldQuantity) {
+		case -1:
+			return "", fmt.Errorf("disk %q cannot shrink from %s to %s", disk["name"], oldSize, size)
+		case 1:
+			// Only the size changed: the volume is expanded live
+			rest := copyMap(disk)
+			rest["size"] = oldSize
+			if reflect.DeepEqual(normalizeJSON(rest), normalizeJSON(old)) {
+				return apply(true), nil
+			}
+		}
+	}
+	return apply(false), nil
+}
+
+// diffList merges the requested items of a spec list with the current ones by name and
+// validates them with check, returning the new list and its changes. check is called
+// with a nil old item for added items; removed items take effect as removed says.
+func diffList(field string, current interface{}, requested []map[string]interface{}, removed string, check func(old, item map[string]interface{}) (string, error)) ([]interface{}, []SpecChange, error) {
+	byName := map[string]map[string]interface{}{}
+	for _, item := range quota.MapSlice(current) {
+		if name, _ := item["name"].(string); name != "" {
+			byName[name] = item
+		}
+	}
+
+	items := make([]interface{}, 0, len(requested))
+	changes := []SpecChange{}
+	seen := map[string]bool{}
+	for _, item := range requested {
+		name, _ := item["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			return nil, nil, fmt.Errorf("every item of %s needs a name", field)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("duplicate name %q in %s", name, field)
+		}
+		seen[name] = true
+
+		old := byName[name]
+		merged := copyMap(old)
+		for k, v := range item {
+			merged[k] = v
+		}
+		items = append(items, merged)
+
+		if old != nil && reflect.DeepEqual(normalizeJSON(merged), normalizeJSON(old)) {
+			continue
+		}
+		apply, err := check(old, merged)
+		if err != nil {
+			return nil, nil, err
+		}
+		action := "changed"
+		if old == nil {
+			action = "added"
+		}
+		changes = append(changes, SpecChange{Field: field + "[" + name + "]", Action: action, Apply: apply})
+	}
+
+	for _, old := range diffListRemoved(current, requested) {
+		changes = append(changes, SpecChange{Field: fmt.Sprintf("%s[%v]", field, old["name"]), Action: "removed", Apply: removed})
+	}
+	return items, changes, nil
+}
+
+// diffListRemoved returns the current items of a spec list missing from the requested ones
+func diffListRemoved(current interface{}, requested []map[string]interface{}) []map[string]interface{} {
+	kept := map[string]bool{}
+	for _, item := range requested {
+		if name, _ := item["name"].(string); name != "" {
+			kept[name] = true
+		}
+	}
+	var removed []map[string]interface{}
+	for _, item := range quota.MapSlice(current) {
+		if name, _ := item["name"].(string); !kept[name] {
+			removed = append(removed, item)
+		}
+	}
+	return removed
+}
+
+// copyMap returns a shallow copy of m, empty if m is nil
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// normalizeJSON round-trips a value through JSON, so that values decoded from the
+// cluster (int64) and from a request (float64) compare equal
+func normalizeJSON(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var result interface{}
+	json.Unmarshal(data, &result)
+	return result
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testSpec returns the spec of a VM with a boot disk, a data disk and one network,
+// as decoded from the cluster
+func testSpec() map[string]interface{} {
+	return map[string]interface{}{
+		"cpu":    int64(2),
+		"memory": "4Gi",
+		"disks": []interface{}{
+			map[string]interface{}{"name": "root", "size": "20Gi", "boot": true, "storageClassName": "fast"},
+			map[string]interface{}{"name": "data", "size": "50Gi", "storageClassName": "fast"},
+		},
+		"networks": []interface{}{
+			map[string]interface{}{"name": "default", "type": "pod"},
+		},
+	}
+}
+
+func TestDiffSpec(t *testing.T) {
+	cpu := func(n int64) *int64 { return &n }
+	memory := func(s string) *string { return &s }
+	disks := func(items ...map[string]interface{}) []map[string]interface{} {
+		return append([]map[string]interface{}{{"name": "root"}}, items...)
+	}
+
+	tests := []struct {
+		name    string
+		req     UpdateVMRequest
+		stopped bool
+		want    []SpecChange
+		wantErr bool
+	}{
+		{
+			name: "unchanged",
+			req:  UpdateVMRequest{CPU: cpu(2), Memory: memory("4096Mi"), Disks: disks(map[string]interface{}{"name": "data", "size": "50Gi"})},
+			want: []SpecChange{},
+		},
+		{
+			name: "more CPU and memory need a restart",
+			req:  UpdateVMRequest{CPU: cpu(4), Memory: memory("8Gi")},
+			want: []SpecChange{
+				{Field: "cpu", Action: "changed", Apply: ApplyRestart},
+				{Field: "memory", Action: "changed", Apply: ApplyRestart},
+			},
+		},
+		{
+			name:    "stopped VM",
+			req:     UpdateVMRequest{CPU: cpu(1)},
+			stopped: true,
+			want:    []SpecChange{{Field: "cpu", Action: "changed", Apply: ApplyNextStart}},
+		},
+		{
+			name:    "invalid memory",
+			req:     UpdateVMRequest{Memory: memory("lots")},
+			wantErr: true,
+		},
+		{
+			name: "grown disk",
+			req:  UpdateVMRequest{Disks: disks(map[string]interface{}{"name": "data", "size": "100Gi"})},
+			want: []SpecChange{{Field: "disks[data]", Action: "changed", Apply: ApplyLive}},
+		},
+		{
+			name: "added disk",
+			req: UpdateVMRequest{Disks: disks(
+				map[string]interface{}{"name": "data"},
+				map[string]interface{}{"name": "logs", "size": "10Gi"},
+			)},
+			want: []SpecChange{{Field: "disks[logs]", Action: "added", Apply: ApplyLive}},
+		},
+		{
+			name: "removed disk",
+			req:  UpdateVMRequest{Disks: disks()},
+			want: []SpecChange{{Field: "disks[data]", Action: "removed", Apply: ApplyRestart}},
+		},
+		{
+			name: "grown disk with another change",
+			req:  UpdateVMRequest{Disks: disks(map[string]interface{}{"name": "data", "size": "100Gi", "readOnly": true})},
+			want: []SpecChange{{Field: "disks[data]", Action: "changed", Apply: ApplyRestart}},
+		},
+		{
+			name:    "shrunk disk",
+			req:     UpdateVMRequest{Disks: disks(map[string]interface{}{"name": "data", "size": "10Gi"})},
+			wantErr: true,
+		},
+		{
+			name:    "changed storage class",
+			req:     UpdateVMRequest{Disks: disks(map[string]interface{}{"name": "data", "storageClassName": "slow"})},
+			wantErr: true,
+		},
+		{
+			name:    "removed boot disk",
+			req:     UpdateVMRequest{Disks: []map[string]interface{}{{"name": "data"}}},
+			wantErr: true,
+		},
+		{
+			name:    "added boot disk",
+			req:     UpdateVMRequest{Disks: disks(map[string]interface{}{"name": "data"}, map[string]interface{}{"name": "os", "size": "10Gi", "boot": true})},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate disk",
+			req:     UpdateVMRequest{Disks: disks(map[string]interface{}{"name": "data"}, map[string]interface{}{"name": "data"})},
+			wantErr: true,
+		},
+		{
+			name: "added and changed networks",
+			req: UpdateVMRequest{Networks: []map[string]interface{}{
+				{"name": "default", "type": "bridge"},
+				{"name": "storage", "type": "multus"},
+			}},
+			want: []SpecChange{
+				{Field: "networks[default]", Action: "changed", Apply: ApplyRestart},
+				{Field: "networks[storage]", Action: "added", Apply: ApplyLive},
+			},
+		},
+		{
+			name:    "no network",
+			req:     UpdateVMRequest{Networks: []map[string]interface{}{}},
+			wantErr: true,
+		},
+		{
+			name: "added GPU",
+			req:  UpdateVMRequest{GPUs: []map[string]interface{}{{"name": "gpu0", "deviceName": "nvidia.com/A100"}}},
+			want: []SpecChange{{Field: "gpus[gpu0]", Action: "added", Apply: ApplyRestart}},
+		},
+		{
+			name:    "GPU without device",
+			req:     UpdateVMRequest{GPUs: []map[string]interface{}{{"name": "gpu0"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, changes, err := diffSpec(testSpec(), &tt.req, !tt.stopped)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("diffSpec() = %v, want error", changes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("diffSpec() error = %v", err)
+			}
+			if !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("diffSpec() changes = %v, want %v", changes, tt.want)
+			}
+			if len(changed) == 0 && len(changes) > 0 {
+				t.Error("diffSpec() reported changes without fields to patch")
+			}
+		})
+	}
+}
+
+func TestDiffSpecMergesItems(t *testing.T) {
+	req := UpdateVMRequest{Disks: []map[string]interface{}{{"name": "root"}, {"name": "data", "size": "100Gi"}}}
+	changed, _, err := diffSpec(testSpec(), &req, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requested items only carry their changes, the rest comes from the current items
+	want := []interface{}{
+		map[string]interface{}{"name": "root", "size": "20Gi", "boot": true, "storageClassName": "fast"},
+		map[string]interface{}{"name": "data", "size": "100Gi", "storageClassName": "fast"},
+	}
+	if !reflect.DeepEqual(changed["disks"], want) {
+		t.Errorf("diffSpec() disks = %v, want %v", changed["disks"], want)
+	}
+}
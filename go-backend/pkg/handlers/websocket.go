@@ -2,33 +2,89 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
 	ws "github.com/kuihuar/wukong-dashboard/go-backend/pkg/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins in development
-	},
+// CheckOrigin returns an upgrader origin check accepting browser connections from
+// allowedOrigins, where "*" allows any origin. Without allowed origins it returns nil,
+// the default check accepting same-origin connections only. Requests without an Origin
+// header do not come from a browser and are accepted. Since upgrades authenticate with
+// the access_token query parameter, other origins must not open connections.
+func CheckOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+
+	allowAny := false
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[origin] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowAny || allowed[origin]
+	}
 }
 
 // WebSocketHandler handles WebSocket connections
 type WebSocketHandler struct {
-	hub *ws.Hub
+	hub      *ws.Hub
+	upgrader websocket.Upgrader
+	// impersonate limits the messages of each user to the resources it may list
+	impersonate bool
 }
 
-// NewWebSocketHandler creates a new WebSocket handler
-func NewWebSocketHandler(hub *ws.Hub) *WebSocketHandler {
-	return &WebSocketHandler{hub: hub}
+// NewWebSocketHandler creates a new WebSocket handler accepting connections from
+// allowedOrigins. With impersonate, users receive the messages about the resources the
+// cluster's RBAC lets them list.
+func NewWebSocketHandler(hub *ws.Hub, allowedOrigins []string, impersonate bool) *WebSocketHandler {
+	return &WebSocketHandler{
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     CheckOrigin(allowedOrigins),
+		},
+		impersonate: impersonate,
+	}
+}
+
+// streamUser returns the user whose RBAC limits a stream of hub messages, nil without
+// impersonation since every user then acts as the dashboard
+func streamUser(c *gin.Context, impersonate bool) *auth.User {
+	if !impersonate {
+		return nil
+	}
+	user, ok := auth.UserFrom(c)
+	if !ok {
+		// Unauthenticated requests are rejected before, never stream everything
+		return &auth.User{}
+	}
+	return user
 }
 
 // HandleWebSocket handles WebSocket upgrade requests
+// Reconnecting clients resume after the lastSeq query parameter.
 func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	var resumeAfter uint64
+	if lastSeq := c.Query("lastSeq"); lastSeq != "" {
+		var err error
+		resumeAfter, err = strconv.ParseUint(lastSeq, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lastSeq: " + lastSeq})
+			return
+		}
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to upgrade connection: " + err.Error(),
@@ -36,7 +92,7 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	client := ws.NewClient(h.hub, conn)
+	client := ws.NewClient(h.hub, conn, resumeAfter, streamUser(c, h.impersonate))
 	h.hub.Register(client)
 
 	// Start read and write pumps in separate goroutines
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	if CheckOrigin(nil) != nil {
+		t.Error("CheckOrigin(nil) is not the default same-origin check")
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"allowed origin", []string{"https://dash.example.com"}, "https://dash.example.com", true},
+		{"other origin", []string{"https://dash.example.com"}, "https://evil.example.com", false},
+		{"scheme mismatch", []string{"https://dash.example.com"}, "http://dash.example.com", false},
+		{"any origin", []string{"*"}, "https://evil.example.com", true},
+		{"no Origin header", []string{"https://dash.example.com"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := CheckOrigin(tt.allowed)(r); got != tt.want {
+				t.Errorf("CheckOrigin(%v) for %q = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// busTopic is the bus topic of collected samples
+const busTopic = "metrics-history"
+
+// subscribeRetryInterval is the delay before retrying a failed bus subscription
+const subscribeRetryInterval = 5 * time.Second
+
+// sample is the usage of one VM in a batch
+type sample struct {
+	Cluster   string  `json:"cluster"`
+	Namespace string  `json:"namespace"`
+	Name      string  `json:"name"`
+	CPU       float64 `json:"cpu"`
+	Memory    float64 `json:"memory"`
+	Disk      float64 `json:"disk"`
+}
+
+// batch holds the samples of one collection, published on the bus
+type batch struct {
+	Time    time.Time `json:"time"`
+	Samples []sample  `json:"samples"`
+}
+
+// SampleFunc receives the full metrics of each VM sampled by a collector
+type SampleFunc func(cluster string, wukong *unstructured.Unstructured, metrics *k8s.MetricsInfo)
+
+// Collector samples the metrics of every running VM and shares them over a bus,
+// so that the store of every replica holds the same history
+type Collector struct {
+	registry *k8s.Registry
+	store    *Store
+	bus      bus.Bus
+	onSample SampleFunc
+}
+
+// NewCollector creates a collector sampling every cluster of the registry.
+// If onSample is not nil, it is called with the metrics of every sampled VM,
+// so that other consumers do not fetch them again.
+func NewCollector(registry *k8s.Registry, store *Store, messageBus bus.Bus, onSample SampleFunc) *Collector {
+	return &Collector{registry: registry, store: store, bus: messageBus, onSample: onSample}
+}
+
+// Run samples at the store's finest resolution until ctx is done.
+// Only one replica should run it at a time.
+func (c *Collector) Run(ctx context.Context) {
+	interval := c.store.Interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			collectCtx, cancel := context.WithTimeout(ctx, interval)
+			samples := c.collect(collectCtx)
+			cancel()
+			c.publish(ctx, batch{Time: now, Samples: samples})
+		}
+	}
+}
+
+// Receive adds the samples published on the bus to the store until ctx is done
+func (c *Collector) Receive(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := c.bus.Subscribe(ctx, busTopic, func(m bus.Message) {
+			var b batch
+			if err := json.Unmarshal(m.Data, &b); err != nil {
+				log.Printf("Failed to decode metrics history batch: %v", err)
+				return
+			}
+			for _, s := range b.Samples {
+				key := Key{Cluster: s.Cluster, Namespace: s.Namespace, Name: s.Name}
+				c.store.Add(key, b.Time, s.CPU, s.Memory, s.Disk)
+			}
+			c.store.Prune(b.Time)
+		})
+		if err != nil {
+			log.Printf("Metrics history bus subscription failed, retrying in %s: %v", subscribeRetryInterval, err)
+			select {
+			case <-time.After(subscribeRetryInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// publish sends a batch to the stores of all replicas
+func (c *Collector) publish(ctx context.Context, b batch) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		log.Printf("Failed to marshal metrics history batch: %v", err)
+		return
+	}
+	if err := c.bus.Publish(ctx, busTopic, data); err != nil {
+		log.Printf("Failed to publish metrics history batch: %v", err)
+	}
+}
+
+// collect samples all clusters concurrently
+func (c *Collector) collect(ctx context.Context) []sample {
+	var mu sync.Mutex
+	var samples []sample
+	var wg sync.WaitGroup
+	for _, client := range c.registry.Clients() {
+		wg.Add(1)
+		go func(client *k8s.Client) {
+			defer wg.Done()
+			clusterSamples := c.collectCluster(ctx, client)
+			mu.Lock()
+			samples = append(samples, clusterSamples...)
+			mu.Unlock()
+		}(client)
+	}
+	wg.Wait()
+	return samples
+}
+
+// collectCluster samples the running VMs of one cluster
+func (c *Collector) collectCluster(ctx context.Context, client *k8s.Client) []sample {
+	wukongs, err := client.ListWukongs(ctx, "")
+	if err != nil {
+		log.Printf("Cluster %s: failed to list VMs for metrics history: %v", client.Name(), err)
+		return nil
+	}
+
+	var samples []sample
+	for _, obj := range wukongs {
+		if ctx.Err() != nil {
+			log.Printf("Cluster %s: metrics collection did not finish within %s", client.Name(), c.store.Interval())
+			break
+		}
+
+		wukong := &unstructured.Unstructured{Object: obj}
+		metrics, err := client.GetWukongMetrics(ctx, wukong)
+		if err != nil || metrics == nil {
+			continue
+		}
+		if c.onSample != nil {
+			c.onSample(client.Name(), wukong, metrics)
+		}
+
+		samples = append(samples, sample{
+			Cluster:   client.Name(),
+			Namespace: wukong.GetNamespace(),
+			Name:      wukong.GetName(),
+			CPU:       float64(metrics.CPUUsage),
+			Memory:    float64(metrics.MemoryUsage),
+			Disk:      float64(metrics.DiskUsage),
+		})
+	}
+	return samples
+}
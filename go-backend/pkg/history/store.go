@@ -0,0 +1,263 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolution is one downsampling tier: samples are averaged into buckets
+// of Step and kept for Retention
+type Resolution struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// DefaultResolutions keeps 30s samples for 6 hours and 5m averages for 7 days
+var DefaultResolutions = []Resolution{
+	{Step: 30 * time.Second, Retention: 6 * time.Hour},
+	{Step: 5 * time.Minute, Retention: 7 * 24 * time.Hour},
+}
+
+// ParseResolutions parses a comma-separated list of step:retention pairs,
+// e.g. "30s:6h,5m:168h". Steps must increase and be multiples of the first.
+func ParseResolutions(value string) ([]Resolution, error) {
+	var resolutions []Resolution
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		stepStr, retentionStr, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid resolution %q, expected step:retention", item)
+		}
+		step, err := time.ParseDuration(stepStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolution step %q: %w", stepStr, err)
+		}
+		retention, err := time.ParseDuration(retentionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolution retention %q: %w", retentionStr, err)
+		}
+		if step <= 0 || retention < step {
+			return nil, fmt.Errorf("invalid resolution %q, retention must be at least one step", item)
+		}
+		if n := len(resolutions); n > 0 {
+			if step <= resolutions[n-1].Step || step%resolutions[0].Step != 0 {
+				return nil, fmt.Errorf("invalid resolution %q, steps must increase in multiples of %s", item, resolutions[0].Step)
+			}
+		}
+		resolutions = append(resolutions, Resolution{Step: step, Retention: retention})
+	}
+	if len(resolutions) == 0 {
+		return nil, fmt.Errorf("no resolutions configured")
+	}
+	return resolutions, nil
+}
+
+// Key identifies the series of one VM
+type Key struct {
+	Cluster   string
+	Namespace string
+	Name      string
+}
+
+// Point is the average usage of a VM over one step
+type Point struct {
+	Timestamp   int64   `json:"timestamp"`   // Start of the step, Unix milliseconds
+	CPUUsage    float64 `json:"cpuUsage"`    // Percentage (0-100)
+	MemoryUsage float64 `json:"memoryUsage"` // Percentage (0-100)
+	DiskUsage   float64 `json:"diskUsage"`   // Percentage (0-100)
+}
+
+// bucket accumulates the samples of one step
+type bucket struct {
+	start             int64
+	count             int
+	cpu, memory, disk float64
+}
+
+// point returns the average of the bucket
+func (b bucket) point() Point {
+	n := float64(b.count)
+	return Point{
+		Timestamp:   b.start,
+		CPUUsage:    b.cpu / n,
+		MemoryUsage: b.memory / n,
+		DiskUsage:   b.disk / n,
+	}
+}
+
+// ring is a fixed-size ring buffer of buckets of one resolution, oldest first
+type ring struct {
+	step    int64
+	buckets []bucket
+	next    int
+	size    int
+}
+
+// add adds a sample at t (Unix milliseconds) to its bucket
+func (r *ring) add(t int64, cpu, memory, disk float64) {
+	start := t - t%r.step
+	if r.size > 0 {
+		last := &r.buckets[(r.next-1+len(r.buckets))%len(r.buckets)]
+		if last.start == start {
+			last.count++
+			last.cpu += cpu
+			last.memory += memory
+			last.disk += disk
+			return
+		}
+		if start < last.start {
+			// Out of order sample, the bucket has already been closed
+			return
+		}
+	}
+
+	r.buckets[r.next] = bucket{start: start, count: 1, cpu: cpu, memory: memory, disk: disk}
+	r.next = (r.next + 1) % len(r.buckets)
+	if r.size < len(r.buckets) {
+		r.size++
+	}
+}
+
+// each calls fn for every bucket, oldest first
+func (r *ring) each(fn func(b bucket)) {
+	first := (r.next - r.size + len(r.buckets)) % len(r.buckets)
+	for i := 0; i < r.size; i++ {
+		fn(r.buckets[(first+i)%len(r.buckets)])
+	}
+}
+
+// last returns the start of the newest bucket
+func (r *ring) last() int64 {
+	if r.size == 0 {
+		return 0
+	}
+	return r.buckets[(r.next-1+len(r.buckets))%len(r.buckets)].start
+}
+
+// series holds one ring per resolution
+type series struct {
+	rings []*ring
+}
+
+// Store is an in-memory time-series store of VM usage with downsampling
+type Store struct {
+	mu          sync.RWMutex
+	resolutions []Resolution
+	series      map[Key]*series
+}
+
+// NewStore creates a store with the given resolutions, finest first
+func NewStore(resolutions []Resolution) *Store {
+	return &Store{
+		resolutions: resolutions,
+		series:      make(map[Key]*series),
+	}
+}
+
+// Interval returns the finest resolution step, at which samples should be added
+func (s *Store) Interval() time.Duration {
+	return s.resolutions[0].Step
+}
+
+// Add records a usage sample of a VM
+func (s *Store) Add(key Key, t time.Time, cpu, memory, disk float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ser, ok := s.series[key]
+	if !ok {
+		ser = &series{}
+		for _, res := range s.resolutions {
+			ser.rings = append(ser.rings, &ring{
+				step:    res.Step.Milliseconds(),
+				buckets: make([]bucket, int(res.Retention/res.Step)),
+			})
+		}
+		s.series[key] = ser
+	}
+
+	ms := t.UnixMilli()
+	for _, r := range ser.rings {
+		r.add(ms, cpu, memory, disk)
+	}
+}
+
+// Query returns the points of a VM between from and to, averaged over step.
+// The finest resolution that covers from and is not finer than step is used;
+// step is rounded up to a multiple of that resolution's step.
+// Steps without samples are omitted.
+func (s *Store) Query(key Key, from, to time.Time, step time.Duration) ([]Point, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.resolutionFor(from, step)
+	res := s.resolutions[i]
+	if step < res.Step {
+		step = res.Step
+	}
+	step = (step + res.Step - 1) / res.Step * res.Step
+
+	points := []Point{}
+	ser, ok := s.series[key]
+	if !ok {
+		return points, step
+	}
+
+	fromMs, toMs, stepMs := from.UnixMilli(), to.UnixMilli(), step.Milliseconds()
+	var acc *bucket
+	ser.rings[i].each(func(b bucket) {
+		if b.start < fromMs-fromMs%stepMs || b.start > toMs {
+			return
+		}
+		start := b.start - b.start%stepMs
+		if acc != nil && acc.start != start {
+			points = append(points, acc.point())
+			acc = nil
+		}
+		if acc == nil {
+			acc = &bucket{start: start}
+		}
+		// Weigh each bucket by its samples, so the result is the mean of all samples
+		acc.count += b.count
+		acc.cpu += b.cpu
+		acc.memory += b.memory
+		acc.disk += b.disk
+	})
+	if acc != nil {
+		points = append(points, acc.point())
+	}
+	return points, step
+}
+
+// resolutionFor returns the index of the finest resolution retaining from
+// whose step does not exceed step, or the coarsest resolution
+func (s *Store) resolutionFor(from time.Time, step time.Duration) int {
+	age := time.Since(from)
+	best := len(s.resolutions) - 1
+	for i := len(s.resolutions) - 1; i >= 0; i-- {
+		res := s.resolutions[i]
+		if res.Retention >= age && (res.Step <= step || i == 0) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Prune drops the series without samples within the longest retention
+func (s *Store) Prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	longest := s.resolutions[len(s.resolutions)-1].Retention
+	cutoff := now.Add(-longest).UnixMilli()
+	for key, ser := range s.series {
+		if ser.rings[0].last() < cutoff {
+			delete(s.series, key)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseResolutions(t *testing.T) {
+	got, err := ParseResolutions(" 30s:6h, 5m:168h ,")
+	if err != nil {
+		t.Fatalf("ParseResolutions() error = %v", err)
+	}
+	want := []Resolution{{Step: 30 * time.Second, Retention: 6 * time.Hour}, {Step: 5 * time.Minute, Retention: 168 * time.Hour}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseResolutions() = %v, want %v", got, want)
+	}
+
+	for _, value := range []string{"", "30s", "x:6h", "30s:x", "30s:10s", "0s:1h", "1m:1h,30s:6h", "30s:6h,45s:12h"} {
+		if _, err := ParseResolutions(value); err == nil {
+			t.Errorf("ParseResolutions(%q) succeeded, want error", value)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := &ring{step: 10, buckets: make([]bucket, 3)}
+	r.add(0, 10, 0, 0)
+	r.add(5, 20, 0, 0)
+	r.add(12, 30, 0, 0)
+	// Out of order samples of closed buckets are dropped
+	r.add(8, 1000, 0, 0)
+	r.add(25, 40, 0, 0)
+	r.add(31, 50, 0, 0)
+
+	var starts []int64
+	var cpu []float64
+	r.each(func(b bucket) {
+		starts = append(starts, b.start)
+		cpu = append(cpu, b.point().CPUUsage)
+	})
+	// The oldest bucket was overwritten
+	if !reflect.DeepEqual(starts, []int64{10, 20, 30}) || !reflect.DeepEqual(cpu, []float64{30, 40, 50}) {
+		t.Errorf("ring holds buckets %v with CPU %v, want [10 20 30] with [30 40 50]", starts, cpu)
+	}
+	if r.last() != 30 {
+		t.Errorf("last() = %d, want 30", r.last())
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s := NewStore([]Resolution{
+		{Step: 10 * time.Second, Retention: time.Hour},
+		{Step: time.Minute, Retention: 24 * time.Hour},
+	})
+	key := Key{Cluster: "prod", Namespace: "vms", Name: "web-1"}
+	base := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+
+	// Two samples per 10s step over two minutes, CPU rising by one per sample
+	for i := 0; i < 24; i++ {
+		s.Add(key, base.Add(time.Duration(i)*5*time.Second), float64(i), 50, 10)
+	}
+
+	points, step := s.Query(key, base, base.Add(2*time.Minute), 0)
+	if step != 10*time.Second || len(points) != 12 {
+		t.Fatalf("Query() returned %d points at %s, want 12 at 10s", len(points), step)
+	}
+	if want := (Point{Timestamp: base.UnixMilli(), CPUUsage: 0.5, MemoryUsage: 50, DiskUsage: 10}); points[0] != want {
+		t.Errorf("first point = %+v, want %+v", points[0], want)
+	}
+
+	// Steps are rounded up to a multiple of the resolution and average all their samples
+	points, step = s.Query(key, base, base.Add(2*time.Minute), 25*time.Second)
+	if step != 30*time.Second || len(points) != 4 {
+		t.Fatalf("Query() returned %d points at %s, want 4 at 30s", len(points), step)
+	}
+	if points[1].Timestamp != base.Add(30*time.Second).UnixMilli() || points[1].CPUUsage != 8.5 {
+		t.Errorf("second point = %+v, want the mean CPU 8.5 of samples 6 to 11", points[1])
+	}
+
+	// A coarser step is served from the coarser resolution
+	points, step = s.Query(key, base, base.Add(2*time.Minute), time.Minute)
+	if step != time.Minute || len(points) != 2 || points[0].CPUUsage != 5.5 || points[1].CPUUsage != 17.5 {
+		t.Errorf("Query() = %+v at %s, want two 1m points with CPU 5.5 and 17.5", points, step)
+	}
+
+	// Points outside the range and unknown VMs are left out
+	points, _ = s.Query(key, base.Add(time.Minute), base.Add(90*time.Second), 0)
+	if len(points) != 4 || points[0].Timestamp != base.Add(time.Minute).UnixMilli() {
+		t.Errorf("Query() returned %+v, want the 4 points from %d", points, base.Add(time.Minute).UnixMilli())
+	}
+	points, _ = s.Query(Key{Cluster: "prod", Namespace: "vms", Name: "other"}, base, base.Add(time.Hour), 0)
+	if points == nil || len(points) != 0 {
+		t.Errorf("Query() of an unknown VM = %v, want an empty list", points)
+	}
+}
+
+func TestStoreQueryResolutionByAge(t *testing.T) {
+	s := NewStore([]Resolution{
+		{Step: 10 * time.Second, Retention: time.Hour},
+		{Step: time.Minute, Retention: 24 * time.Hour},
+	})
+	// A range starting before the retention of the fine resolution uses the coarse one
+	_, step := s.Query(Key{}, time.Now().Add(-2*time.Hour), time.Now(), 0)
+	if step != time.Minute {
+		t.Errorf("Query() step = %s, want 1m beyond the 1h retention of 10s samples", step)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	s := NewStore([]Resolution{{Step: time.Second, Retention: time.Minute}})
+	now := time.Now()
+	s.Add(Key{Name: "old"}, now.Add(-2*time.Minute), 1, 1, 1)
+	s.Add(Key{Name: "recent"}, now.Add(-30*time.Second), 1, 1, 1)
+
+	s.Prune(now)
+	if _, ok := s.series[Key{Name: "old"}]; ok {
+		t.Error("Prune() kept a series without samples within the retention")
+	}
+	if _, ok := s.series[Key{Name: "recent"}]; !ok {
+		t.Error("Prune() dropped a series with recent samples")
+	}
+}
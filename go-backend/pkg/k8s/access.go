@@ -0,0 +1,141 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// accessReviewTTL is how long the decision of a SubjectAccessReview is reused
+	accessReviewTTL = 30 * time.Second
+
+	// accessReviewTimeout bounds a SubjectAccessReview
+	accessReviewTimeout = 5 * time.Second
+
+	// accessCacheSize is the number of decisions above which expired ones are dropped
+	accessCacheSize = 4096
+)
+
+// Access is an action on a type of resource, checked against the cluster's RBAC
+type Access struct {
+	Verb        string
+	Resource    schema.GroupVersionResource
+	Subresource string
+	// Namespace is empty for all namespaces
+	Namespace string
+}
+
+// String describes the access in the words of the Kubernetes authorizer
+func (a Access) String() string {
+	resource := a.Resource.Resource
+	if a.Subresource != "" {
+		resource += "/" + a.Subresource
+	}
+	if a.Resource.Group != "" {
+		resource += "." + a.Resource.Group
+	}
+	if a.Namespace == "" {
+		return a.Verb + " " + resource + " at the cluster scope"
+	}
+	return a.Verb + " " + resource + " in namespace " + a.Namespace
+}
+
+// ResourceTypeGVRs are the resources behind the resource types of the API, whose
+// list permission decides which users see the updates and operations of a resource
+var ResourceTypeGVRs = map[string]schema.GroupVersionResource{
+	"vm":       WukongGVR,
+	"snapshot": WukongSnapshotGVR,
+}
+
+// impersonatedUser is the identity an impersonating Client acts as
+type impersonatedUser struct {
+	name   string
+	groups []string
+}
+
+// accessDecision is a cached SubjectAccessReview decision
+type accessDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// accessCache holds the SubjectAccessReview decisions of a cluster, shared by the
+// impersonating copies of its Client
+type accessCache struct {
+	mu        sync.Mutex
+	decisions map[string]accessDecision
+}
+
+// UserAllowed reports whether a user with the given groups may perform access. The
+// SubjectAccessReview is made with the dashboard's own credentials, and its decision
+// is reused for the same user and access for accessReviewTTL.
+func (c *Client) UserAllowed(ctx context.Context, userName string, groups []string, access Access) (bool, error) {
+	key := strings.Join([]string{userName, strings.Join(groups, ","), access.Verb,
+		access.Resource.Group, access.Resource.Resource, access.Subresource, access.Namespace}, "\x00")
+
+	c.access.mu.Lock()
+	decision, ok := c.access.decisions[key]
+	c.access.mu.Unlock()
+	if ok && time.Now().Before(decision.expires) {
+		return decision.allowed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, accessReviewTimeout)
+	defer cancel()
+	review, err := c.service.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   userName,
+			Groups: groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   access.Namespace,
+				Verb:        access.Verb,
+				Group:       access.Resource.Group,
+				Version:     access.Resource.Version,
+				Resource:    access.Resource.Resource,
+				Subresource: access.Subresource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to review access of %s: %w", userName, err)
+	}
+
+	now := time.Now()
+	c.access.mu.Lock()
+	if len(c.access.decisions) >= accessCacheSize {
+		for k, d := range c.access.decisions {
+			if now.After(d.expires) {
+				delete(c.access.decisions, k)
+			}
+		}
+	}
+	c.access.decisions[key] = accessDecision{allowed: review.Status.Allowed, expires: now.Add(accessReviewTTL)}
+	c.access.mu.Unlock()
+	return review.Status.Allowed, nil
+}
+
+// Authorize returns a Forbidden error if the user the client impersonates may not
+// perform access. A client that does not impersonate acts as the dashboard and is
+// not checked.
+func (c *Client) Authorize(ctx context.Context, access Access) error {
+	if c.user == nil {
+		return nil
+	}
+	allowed, err := c.UserAllowed(ctx, c.user.name, c.user.groups, access)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if !allowed {
+		return apierrors.NewForbidden(access.Resource.GroupResource(), "",
+			fmt.Errorf("user %q cannot %s", c.user.name, access))
+	}
+	return nil
+}
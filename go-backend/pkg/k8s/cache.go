@@ -0,0 +1,305 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// resyncPeriod is how often the informers replay their cache to event handlers
+	resyncPeriod = 10 * time.Minute
+
+	// cacheSyncTimeout bounds how long Start waits for the initial list of each informer
+	cacheSyncTimeout = 30 * time.Second
+
+	// virtLauncherSelector selects the pods that run KubeVirt VMIs
+	virtLauncherSelector = "kubevirt.io=virt-launcher"
+
+	// virtLauncherPrefix starts the names of virt-launcher pods
+	virtLauncherPrefix = "virt-launcher-"
+)
+
+// cachedGVRs are the custom resources served from the shared informer cache
+var cachedGVRs = []schema.GroupVersionResource{
+	WukongGVR,
+	WukongSnapshotGVR,
+	VirtualMachineGVR,
+	VirtualMachineInstanceGVR,
+}
+
+// podsGVR is the GroupVersionResource of the cached virt-launcher pods
+var podsGVR = corev1.SchemeGroupVersion.WithResource("pods")
+
+// informerCache holds the shared informers backing the Client read path
+type informerCache struct {
+	mu        sync.RWMutex
+	started   bool
+	informers map[schema.GroupVersionResource]informers.GenericInformer
+	pods      cache.SharedIndexInformer
+	podLister corelisters.PodLister
+}
+
+// Start starts the shared informers and waits for their initial sync.
+// Resources whose CRD is not installed are skipped and keep being read from the API server.
+func (c *Client) Start(ctx context.Context) {
+	c.cache.mu.Lock()
+	if c.cache.started {
+		c.cache.mu.Unlock()
+		return
+	}
+	c.cache.started = true
+
+	dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		c.dynamicClient, resyncPeriod, metav1.NamespaceAll, nil)
+	for _, gvr := range cachedGVRs {
+		available, err := c.resourceAvailable(gvr)
+		if err != nil {
+			// The cluster is unreachable, the informer keeps retrying until it is back
+			log.Printf("Cluster %s: failed to discover %s: %v", c.name, gvr.String(), err)
+		} else if !available {
+			log.Printf("Cluster %s: resource %s not found on the API server. It will not be cached. To enable, install its CRD and restart.", c.name, gvr.String())
+			continue
+		}
+		informer := dynamicFactory.ForResource(gvr)
+		c.countWatchErrors(informer.Informer(), gvr.Resource)
+		c.cache.informers[gvr] = informer
+	}
+
+	podFactory := informers.NewSharedInformerFactoryWithOptions(c.service, resyncPeriod,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = virtLauncherSelector
+		}))
+	podInformer := podFactory.Core().V1().Pods()
+	c.cache.pods = podInformer.Informer()
+	c.countWatchErrors(c.cache.pods, "pods")
+	c.cache.podLister = podInformer.Lister()
+	c.cache.mu.Unlock()
+
+	dynamicFactory.Start(ctx.Done())
+	podFactory.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+
+	for gvr, synced := range dynamicFactory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			log.Printf("Cluster %s: informer cache for %s did not sync, reads fall back to the API server", c.name, gvr.String())
+		}
+	}
+	for _, synced := range podFactory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			log.Printf("Cluster %s: informer cache for virt-launcher pods did not sync, reads fall back to the API server", c.name)
+		}
+	}
+}
+
+// countWatchErrors counts the watch failures of an informer, after which its reflector reconnects
+func (c *Client) countWatchErrors(informer cache.SharedIndexInformer, resource string) {
+	counter := metrics.WatchErrors.WithLabelValues(c.name, resource)
+	err := informer.SetWatchErrorHandlerWithContext(func(ctx context.Context, r *cache.Reflector, err error) {
+		counter.Inc()
+		cache.DefaultWatchErrorHandler(ctx, r, err)
+	})
+	if err != nil {
+		log.Printf("Cluster %s: failed to count watch errors of %s: %v", c.name, resource, err)
+	}
+}
+
+// resourceAvailable checks via discovery whether the API server serves the given resource.
+// An error is returned only if the API server could not be asked.
+func (c *Client) resourceAvailable(gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := c.service.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddEventHandler registers a handler on the shared informer of the given resource
+func (c *Client) AddEventHandler(gvr schema.GroupVersionResource, handler cache.ResourceEventHandler) error {
+	c.cache.mu.RLock()
+	informer, ok := c.cache.informers[gvr]
+	c.cache.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("resource %s is not cached", gvr.String())
+	}
+
+	_, err := informer.Informer().AddEventHandler(handler)
+	return err
+}
+
+// syncedLister returns the lister for gvr if its informer has completed the initial sync
+func (c *Client) syncedLister(gvr schema.GroupVersionResource) (cache.GenericLister, bool) {
+	c.cache.mu.RLock()
+	informer, ok := c.cache.informers[gvr]
+	c.cache.mu.RUnlock()
+	if !ok || !informer.Informer().HasSynced() {
+		return nil, false
+	}
+	return informer.Lister(), true
+}
+
+// listObjects lists gvr objects in namespace from the cache, or from the API server if the cache is not synced.
+// An empty namespace lists across all namespaces.
+func (c *Client) listObjects(ctx context.Context, gvr schema.GroupVersionResource, namespace string) ([]map[string]interface{}, error) {
+	lister, ok := c.syncedLister(gvr)
+	if !ok {
+		list, err := c.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		var results []map[string]interface{}
+		for _, item := range list.Items {
+			results = append(results, item.Object)
+		}
+		return results, nil
+	}
+
+	// A user who may not list across all namespaces gets the namespaces it may list
+	access := Access{Verb: "list", Resource: gvr, Namespace: namespace}
+	filter := false
+	if err := c.Authorize(ctx, access); err != nil {
+		if namespace != metav1.NamespaceAll || !apierrors.IsForbidden(err) {
+			return nil, err
+		}
+		filter = true
+	}
+
+	var objs []runtime.Object
+	var err error
+	if namespace == metav1.NamespaceAll {
+		objs, err = lister.List(labels.Everything())
+	} else {
+		objs, err = lister.ByNamespace(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]interface{}
+	allowed := make(map[string]bool)
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if filter {
+			ns := u.GetNamespace()
+			if _, checked := allowed[ns]; !checked {
+				access.Namespace = ns
+				err := c.Authorize(ctx, access)
+				if err != nil && !apierrors.IsForbidden(err) {
+					return nil, err
+				}
+				allowed[ns] = err == nil
+			}
+			if !allowed[ns] {
+				continue
+			}
+		}
+		results = append(results, u.DeepCopy().Object)
+	}
+	return results, nil
+}
+
+// getObject gets a gvr object from the cache, or from the API server if the cache is not synced
+func (c *Client) getObject(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	lister, ok := c.syncedLister(gvr)
+	if !ok {
+		return c.dynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	if err := c.Authorize(ctx, Access{Verb: "get", Resource: gvr, Namespace: namespace}); err != nil {
+		return nil, err
+	}
+
+	obj, err := lister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return toUnstructured(obj, gvr)
+}
+
+// findVirtLauncherPod finds the virt-launcher pod running the given KubeVirt VM.
+// Returns nil if no such pod exists.
+func (c *Client) findVirtLauncherPod(ctx context.Context, namespace, vmName string) (*corev1.Pod, error) {
+	var pods []*corev1.Pod
+
+	c.cache.mu.RLock()
+	podInformer, podLister := c.cache.pods, c.cache.podLister
+	c.cache.mu.RUnlock()
+
+	if podInformer != nil && podInformer.HasSynced() {
+		if err := c.Authorize(ctx, Access{Verb: "list", Resource: podsGVR, Namespace: namespace}); err != nil {
+			return nil, err
+		}
+		var err error
+		pods, err = podLister.Pods(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+	} else {
+		list, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: virtLauncherSelector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		for i := range list.Items {
+			pods = append(pods, &list.Items[i])
+		}
+	}
+
+	// Pod name format: virt-launcher-{vmName}-{hash}
+	expectedPrefix := virtLauncherPrefix + vmName + "-"
+	for _, pod := range pods {
+		if strings.HasPrefix(pod.Name, expectedPrefix) {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+// toUnstructured returns a deep copy of a cached object as Unstructured
+func toUnstructured(obj runtime.Object, gvr schema.GroupVersionResource) (*unstructured.Unstructured, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, apierrors.NewInternalError(fmt.Errorf("unexpected object type %T in %s cache", obj, gvr.Resource))
+	}
+	return u.DeepCopy(), nil
+}
+
+// ObjectFromEvent extracts the Unstructured object passed to an informer event handler,
+// unwrapping tombstones delivered for deletes that were missed while disconnected
+func ObjectFromEvent(obj interface{}) (*unstructured.Unstructured, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	return u, ok
+}
@@ -2,33 +2,57 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
-	corev1 "k8s.io/api/core/v1"
-	"encoding/json"
 )
 
+// FieldManager identifies the dashboard as the manager of the fields it patches
+const FieldManager = "wukong-dashboard"
+
 // Client wraps Kubernetes client for Wukong and KubeVirt resources
 type Client struct {
+	// name identifies the cluster in the Registry
+	name string
+	// clientset, dynamicClient, metricsClient and subresources make API calls as the
+	// impersonated user, if any
 	clientset     *kubernetes.Clientset
 	dynamicClient dynamic.Interface
 	metricsClient *metricsv.Clientset
-	restConfig    *rest.Config
-	namespace     string
+	// service is the dashboard's own clientset, for the calls that stay privileged:
+	// SubjectAccessReviews, the shared informers, discovery, leader election, the
+	// kubelet stats behind metrics and the Events recorded by the audit log
+	service *kubernetes.Clientset
+	// subresources calls the KubeVirt subresource API
+	subresources rest.Interface
+	restConfig   *rest.Config
+	// namespace is the default namespace for requests that do not name one
+	namespace string
+	cache     *informerCache
+	// user is the identity the client impersonates, nil for the dashboard's own.
+	// Reads served from the cache are authorized for it with SubjectAccessReviews.
+	user   *impersonatedUser
+	access *accessCache
+	// metrics provides VM usage, metrics-server unless replaced by SetMetricsProvider
+	metrics MetricsProvider
+	kubelet *kubeletCache
 }
 
 // WukongGVR is the GroupVersionResource for Wukong CRD
@@ -59,13 +83,19 @@ var VirtualMachineInstanceGVR = schema.GroupVersionResource{
 	Resource: "virtualmachineinstances",
 }
 
-// NewClient creates a new Kubernetes client
+// NewClient creates a new Kubernetes client for the in-cluster or KUBECONFIG cluster.
+// namespace is the default namespace for requests that do not name one; all namespaces are cached.
 func NewClient(namespace string) (*Client, error) {
 	config, err := getKubeConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
 	}
 
+	return NewClientForConfig(DefaultClusterName, config, namespace)
+}
+
+// NewClientForConfig creates a new Kubernetes client for the cluster named name
+func NewClientForConfig(name string, config *rest.Config, namespace string) (*Client, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create clientset: %w", err)
@@ -76,19 +106,34 @@ func NewClient(namespace string) (*Client, error) {
 		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
 
+	subresources, err := newSubresourceClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create KubeVirt subresource client: %w", err)
+	}
+
 	metricsClient, err := metricsv.NewForConfig(config)
 	if err != nil {
 		// Metrics client is optional, log warning but don't fail
-		fmt.Printf("Warning: Failed to create metrics client: %v. Metrics will not be available.\n", err)
+		log.Printf("Warning: Failed to create metrics client: %v. Metrics will not be available.", err)
 	}
 
-	return &Client{
+	client := &Client{
+		name:          name,
 		clientset:     clientset,
 		dynamicClient: dynamicClient,
 		metricsClient: metricsClient,
+		service:       clientset,
+		subresources:  subresources,
 		restConfig:    config,
 		namespace:     namespace,
-	}, nil
+		cache: &informerCache{
+			informers: make(map[schema.GroupVersionResource]informers.GenericInformer),
+		},
+		access:  &accessCache{decisions: make(map[string]accessDecision)},
+		kubelet: &kubeletCache{nodes: make(map[string]*nodeStats)},
+	}
+	client.metrics = client.MetricsServerProvider()
+	return client, nil
 }
 
 // getKubeConfig returns the Kubernetes config
@@ -114,9 +159,78 @@ func (c *Client) GetRestConfig() *rest.Config {
 	return c.restConfig
 }
 
-// ListWukongs lists all Wukong resources
-func (c *Client) ListWukongs(ctx context.Context) ([]map[string]interface{}, error) {
-	list, err := c.dynamicClient.Resource(WukongGVR).Namespace(c.namespace).List(ctx, metav1.ListOptions{})
+// Impersonate returns a client whose API calls are made as the given user and groups,
+// so that they are authorized by the cluster's own RBAC. Reads served from the shared
+// informer cache, and VM metrics, which the metrics provider reads with the dashboard's
+// own access, are authorized with SubjectAccessReviews for the same user.
+func (c *Client) Impersonate(userName string, groups []string) (*Client, error) {
+	config := rest.CopyConfig(c.restConfig)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: userName,
+		Groups:   groups,
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating clientset: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating dynamic client: %w", err)
+	}
+
+	subresources, err := newSubresourceClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating KubeVirt subresource client: %w", err)
+	}
+
+	// Optional as for the dashboard's own client, nil if it cannot be created
+	metricsClient, _ := metricsv.NewForConfig(config)
+
+	impersonated := *c
+	impersonated.clientset = clientset
+	impersonated.metricsClient = metricsClient
+	impersonated.dynamicClient = dynamicClient
+	impersonated.subresources = subresources
+	impersonated.restConfig = config
+	impersonated.user = &impersonatedUser{name: userName, groups: groups}
+	return &impersonated, nil
+}
+
+// Name returns the name of the cluster this client talks to
+func (c *Client) Name() string {
+	return c.name
+}
+
+// ServerVersion returns the Kubernetes version of the cluster, verifying connectivity
+func (c *Client) ServerVersion(ctx context.Context) (string, error) {
+	raw, err := c.service.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return "", err
+	}
+
+	var info version.Info
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return "", fmt.Errorf("failed to parse server version: %w", err)
+	}
+	return info.GitVersion, nil
+}
+
+// DefaultNamespace returns the namespace used when a request does not name one
+func (c *Client) DefaultNamespace() string {
+	return c.namespace
+}
+
+// ListWukongs lists Wukong resources in namespace, or in all namespaces if namespace is empty
+func (c *Client) ListWukongs(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	return c.listObjects(ctx, WukongGVR, namespace)
+}
+
+// ListWukongsUncached lists Wukong resources in namespace from the API server, for reads
+// that must see the latest writes, which the informer cache may not have received yet
+func (c *Client) ListWukongsUncached(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	list, err := c.dynamicClient.Resource(WukongGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -129,175 +243,172 @@ func (c *Client) ListWukongs(ctx context.Context) ([]map[string]interface{}, err
 }
 
 // GetWukong gets a specific Wukong resource
-func (c *Client) GetWukong(ctx context.Context, name string) (*unstructured.Unstructured, error) {
-	return c.dynamicClient.Resource(WukongGVR).Namespace(c.namespace).Get(ctx, name, metav1.GetOptions{})
+func (c *Client) GetWukong(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return c.getObject(ctx, WukongGVR, namespace, name)
 }
 
 // CreateWukong creates a new Wukong resource
-func (c *Client) CreateWukong(ctx context.Context, wukong *unstructured.Unstructured) (*unstructured.Unstructured, error) {
-	return c.dynamicClient.Resource(WukongGVR).Namespace(c.namespace).Create(ctx, wukong, metav1.CreateOptions{})
+func (c *Client) CreateWukong(ctx context.Context, namespace string, wukong *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return c.dynamicClient.Resource(WukongGVR).Namespace(namespace).Create(ctx, wukong, metav1.CreateOptions{})
 }
 
 // UpdateWukong updates an existing Wukong resource
-func (c *Client) UpdateWukong(ctx context.Context, wukong *unstructured.Unstructured) (*unstructured.Unstructured, error) {
-	return c.dynamicClient.Resource(WukongGVR).Namespace(c.namespace).Update(ctx, wukong, metav1.UpdateOptions{})
+func (c *Client) UpdateWukong(ctx context.Context, namespace string, wukong *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return c.dynamicClient.Resource(WukongGVR).Namespace(namespace).Update(ctx, wukong, metav1.UpdateOptions{})
 }
 
-// DeleteWukong deletes a Wukong resource
-func (c *Client) DeleteWukong(ctx context.Context, name string) error {
-	return c.dynamicClient.Resource(WukongGVR).Namespace(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+// PatchWukong applies a JSON merge patch to a Wukong resource
+func (c *Client) PatchWukong(ctx context.Context, namespace, name string, patch []byte) (*unstructured.Unstructured, error) {
+	return c.dynamicClient.Resource(WukongGVR).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+}
+
+// ModifyWukong reads a Wukong from the API server and applies the JSON merge patch
+// returned by modify, guarded by the resource version read. The sequence is retried
+// when the Wukong changed in between, e.g. by the operator updating its status.
+// If modify returns a nil patch, nothing is patched and the Wukong read is returned.
+func (c *Client) ModifyWukong(ctx context.Context, namespace, name string, modify func(*unstructured.Unstructured) (map[string]interface{}, error)) (*unstructured.Unstructured, error) {
+	var result *unstructured.Unstructured
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// The informer cache may lag behind, which would conflict again
+		wukong, err := c.dynamicClient.Resource(WukongGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		patch, err := modify(wukong)
+		if err != nil {
+			return err
+		}
+		if patch == nil {
+			result = wukong
+			return nil
+		}
+
+		if err := unstructured.SetNestedField(patch, wukong.GetResourceVersion(), "metadata", "resourceVersion"); err != nil {
+			return err
+		}
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		result, err = c.PatchWukong(ctx, namespace, name, data)
+		return err
+	})
+	return result, err
 }
 
-// WatchWukongs watches for Wukong resource changes
-func (c *Client) WatchWukongs(ctx context.Context) (watch.Interface, error) {
-	return c.dynamicClient.Resource(WukongGVR).Namespace(c.namespace).Watch(ctx, metav1.ListOptions{})
+// DeleteWukong deletes a Wukong resource
+func (c *Client) DeleteWukong(ctx context.Context, namespace, name string) error {
+	return c.dynamicClient.Resource(WukongGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 }
 
 // GetVMI gets a VirtualMachineInstance for VNC connection
-func (c *Client) GetVMI(ctx context.Context, name string) (*unstructured.Unstructured, error) {
-	return c.dynamicClient.Resource(VirtualMachineInstanceGVR).Namespace(c.namespace).Get(ctx, name, metav1.GetOptions{})
+func (c *Client) GetVMI(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return c.getObject(ctx, VirtualMachineInstanceGVR, namespace, name)
 }
 
 // GetVMStatus gets the actual VM status from KubeVirt VM resource
 // Returns the printableStatus from the VM resource, or empty string if not found
-func (c *Client) GetVMStatus(ctx context.Context, vmName string) (string, error) {
-	vm, err := c.dynamicClient.Resource(VirtualMachineGVR).Namespace(c.namespace).Get(ctx, vmName, metav1.GetOptions{})
+func (c *Client) GetVMStatus(ctx context.Context, namespace, vmName string) (string, error) {
+	vm, err := c.getObject(ctx, VirtualMachineGVR, namespace, vmName)
 	if err != nil {
 		return "", err
 	}
-	
+
 	status, _, _ := unstructured.NestedMap(vm.Object, "status")
 	if status == nil {
 		return "", nil
 	}
-	
+
 	// Get printableStatus from VM resource
 	if printableStatus, ok, _ := unstructured.NestedString(status, "printableStatus"); ok && printableStatus != "" {
 		return printableStatus, nil
 	}
-	
+
 	return "", nil
 }
 
 // StartVM starts a virtual machine by patching the running state
-func (c *Client) StartVM(ctx context.Context, name string) error {
-	wukong, err := c.GetWukong(ctx, name)
-	if err != nil {
-		return err
-	}
-
-	// Set running to true
-	if err := unstructured.SetNestedField(wukong.Object, true, "spec", "running"); err != nil {
-		return err
-	}
-
-	_, err = c.UpdateWukong(ctx, wukong)
-	return err
+func (c *Client) StartVM(ctx context.Context, namespace, name string) error {
+	return c.setRunning(ctx, namespace, name, true)
 }
 
 // StopVM stops a virtual machine by patching the running state
-func (c *Client) StopVM(ctx context.Context, name string) error {
-	wukong, err := c.GetWukong(ctx, name)
-	if err != nil {
-		return err
-	}
+func (c *Client) StopVM(ctx context.Context, namespace, name string) error {
+	return c.setRunning(ctx, namespace, name, false)
+}
 
-	// Set running to false
-	if err := unstructured.SetNestedField(wukong.Object, false, "spec", "running"); err != nil {
+// setRunning sets spec.running of a Wukong. The merge patch only touches that field,
+// so it cannot conflict with concurrent updates of the rest of the Wukong.
+func (c *Client) setRunning(ctx context.Context, namespace, name string, running bool) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"running": running},
+	})
+	if err != nil {
 		return err
 	}
-
-	_, err = c.UpdateWukong(ctx, wukong)
+	_, err = c.PatchWukong(ctx, namespace, name, patch)
 	return err
 }
 
-// RestartVM restarts a virtual machine
-func (c *Client) RestartVM(ctx context.Context, name string) error {
-	// Stop then start with a small delay
-	if err := c.StopVM(ctx, name); err != nil {
-		return err
-	}
-	time.Sleep(2 * time.Second)
-	return c.StartVM(ctx, name)
+// ListSnapshots lists WukongSnapshot resources in namespace, or in all namespaces if namespace is empty
+func (c *Client) ListSnapshots(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	return c.listObjects(ctx, WukongSnapshotGVR, namespace)
 }
 
-// ListSnapshots lists all WukongSnapshot resources
-func (c *Client) ListSnapshots(ctx context.Context) ([]map[string]interface{}, error) {
-	list, err := c.dynamicClient.Resource(WukongSnapshotGVR).Namespace(c.namespace).List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	var results []map[string]interface{}
-	for _, item := range list.Items {
-		results = append(results, item.Object)
-	}
-	return results, nil
+// GetSnapshot gets a specific WukongSnapshot resource
+func (c *Client) GetSnapshot(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return c.getObject(ctx, WukongSnapshotGVR, namespace, name)
 }
 
 // CreateSnapshot creates a new WukongSnapshot resource
-func (c *Client) CreateSnapshot(ctx context.Context, snapshot *unstructured.Unstructured) (*unstructured.Unstructured, error) {
-	return c.dynamicClient.Resource(WukongSnapshotGVR).Namespace(c.namespace).Create(ctx, snapshot, metav1.CreateOptions{})
+func (c *Client) CreateSnapshot(ctx context.Context, namespace string, snapshot *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return c.dynamicClient.Resource(WukongSnapshotGVR).Namespace(namespace).Create(ctx, snapshot, metav1.CreateOptions{})
 }
 
 // DeleteSnapshot deletes a WukongSnapshot resource
-func (c *Client) DeleteSnapshot(ctx context.Context, name string) error {
-	return c.dynamicClient.Resource(WukongSnapshotGVR).Namespace(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
-}
-
-// WatchSnapshots watches for WukongSnapshot resource changes
-func (c *Client) WatchSnapshots(ctx context.Context) (watch.Interface, error) {
-	return c.dynamicClient.Resource(WukongSnapshotGVR).Namespace(c.namespace).Watch(ctx, metav1.ListOptions{})
+func (c *Client) DeleteSnapshot(ctx context.Context, namespace, name string) error {
+	return c.dynamicClient.Resource(WukongSnapshotGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 }
 
-// GetVMMetrics gets CPU and memory usage metrics for a VM
+// GetVMMetrics gets CPU and memory usage metrics for a VM from the client's MetricsProvider
 // vmName is the name from Wukong status.vmName (e.g., "ubuntu-vm-dual-network-dhcp-vm")
 // wukongObj is the Wukong CRD object to extract volume information
-func (c *Client) GetVMMetrics(ctx context.Context, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
+// The provider reads metrics with the dashboard's own access, so an impersonated user
+// needs get on the Wukong, as for its metrics history.
+func (c *Client) GetVMMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
+	if err := c.Authorize(ctx, Access{Verb: "get", Resource: WukongGVR, Namespace: namespace}); err != nil {
+		return nil, err
+	}
+	return c.metrics.VMMetrics(ctx, namespace, vmName, allocatedCPU, allocatedMemory, wukongObj)
+}
+
+// getMetricsServerMetrics gets CPU and memory usage of the virt-launcher pod from metrics-server
+func (c *Client) getMetricsServerMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
 	if c.metricsClient == nil {
 		// Metrics client not available, return nil
 		return nil, nil
 	}
 
 	// Find the virt-launcher pod for this VM
-	// Pod name format: virt-launcher-{vmName}-{hash}
-	// Try to find pod by label first (more reliable)
-	pods, err := c.clientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "kubevirt.io=virt-launcher",
-	})
+	targetPod, err := c.findVirtLauncherPod(ctx, namespace, vmName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %w", err)
-	}
-
-	var targetPodName string
-	for _, pod := range pods.Items {
-		// Check if pod name starts with "virt-launcher-" and contains vmName
-		// Pod name format: virt-launcher-{vmName}-{hash}
-		if strings.HasPrefix(pod.Name, "virt-launcher-") && strings.Contains(pod.Name, vmName) {
-			// Verify it's the correct pod by checking the vmName part
-			// virt-launcher-{vmName}-{hash} should match exactly
-			expectedPrefix := fmt.Sprintf("virt-launcher-%s-", vmName)
-			if strings.HasPrefix(pod.Name, expectedPrefix) {
-				targetPodName = pod.Name
-				break
-			}
-		}
+		return nil, err
 	}
 
-	if targetPodName == "" {
+	if targetPod == nil {
 		// Pod not found, return nil (metrics not available)
 		return nil, nil
 	}
 
 	// Get pod metrics
-	podMetrics, err := c.metricsClient.MetricsV1beta1().PodMetricses(c.namespace).Get(ctx, targetPodName, metav1.GetOptions{})
+	podMetrics, err := c.metricsClient.MetricsV1beta1().PodMetricses(namespace).Get(ctx, targetPod.Name, metav1.GetOptions{})
 	if err != nil {
 		// Metrics not available for this pod, return nil
 		return nil, nil
 	}
 
 	// Calculate total CPU and memory usage from all containers
-	var totalCPUUsage int64 // in millicores
+	var totalCPUUsage int64    // in millicores
 	var totalMemoryUsage int64 // in bytes
 
 	for _, container := range podMetrics.Containers {
@@ -335,139 +446,78 @@ func (c *Client) GetVMMetrics(ctx context.Context, vmName string, allocatedCPU i
 		}
 	}
 
-	// Disk usage: Calculate from PVC usage
-	diskUsagePercent := 0
-	if wukongObj != nil {
-		diskUsagePercent = c.getDiskUsageFromWukong(ctx, wukongObj)
-	}
-
-	return &MetricsInfo{
+	metrics := &MetricsInfo{
 		CPUUsage:    cpuUsagePercent,
 		MemoryUsage: memoryUsagePercent,
-		DiskUsage:   diskUsagePercent,
-	}, nil
-}
-
-// getDiskUsageFromWukong calculates disk usage percentage from Wukong volumes
-// This method tries multiple approaches:
-// 1. Kubelet stats API (most accurate, requires nodes/proxy permission)
-// 2. PVC capacity (fallback, returns 0 if usage not available)
-func (c *Client) getDiskUsageFromWukong(ctx context.Context, wukongObj *unstructured.Unstructured) int {
-	status, _, _ := unstructured.NestedMap(wukongObj.Object, "status")
-	if status == nil {
-		return 0
 	}
 
-	// Get volumes from status
-	volumes, ok, _ := unstructured.NestedSlice(status, "volumes")
-	if !ok || len(volumes) == 0 {
-		return 0
+	// Disk usage and per-device stats: from kubelet stats
+	if wukongObj != nil {
+		c.addKubeletStats(ctx, wukongObj, metrics)
 	}
 
-	// Get the pod to find which node it's on
-	vmName, ok, _ := unstructured.NestedString(status, "vmName")
-	if !ok || vmName == "" {
-		return 0
-	}
+	return metrics, nil
+}
 
-	// Find the virt-launcher pod
-	pods, err := c.clientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "kubevirt.io=virt-launcher",
-	})
-	if err != nil {
-		return 0
-	}
+// GetVMInfo converts a Wukong into the VM view served by the API. The status is the
+// printableStatus of the KubeVirt VM backing it, if any; with withMetrics set, the
+// metrics of a running VM are attached when available.
+func (c *Client) GetVMInfo(ctx context.Context, wukong *unstructured.Unstructured, withMetrics bool) *VMInfo {
+	vm := ConvertWukongToVMInfo(wukong)
 
-	var targetPod *corev1.Pod
-	expectedPrefix := fmt.Sprintf("virt-launcher-%s-", vmName)
-	for i := range pods.Items {
-		pod := &pods.Items[i]
-		if strings.HasPrefix(pod.Name, expectedPrefix) {
-			targetPod = &pods.Items[i]
-			break
-		}
+	vmName, _, _ := unstructured.NestedString(wukong.Object, "status", "vmName")
+	if vmName == "" {
+		return vm
 	}
 
-	if targetPod == nil {
-		return 0
+	// Get actual VM status from KubeVirt VM resource
+	if actualStatus, err := c.GetVMStatus(ctx, wukong.GetNamespace(), vmName); err == nil && actualStatus != "" {
+		vm.Status = actualStatus
 	}
 
-	// Try to get volume stats from kubelet stats API
-	nodeName := targetPod.Spec.NodeName
-	if nodeName == "" {
-		return 0
+	if withMetrics && vm.Status == "Running" {
+		metrics, err := c.GetVMMetrics(ctx, wukong.GetNamespace(), vmName, vm.CPU, vm.Memory, wukong)
+		if err == nil && metrics != nil {
+			vm.Metrics = metrics
+		}
 	}
+	return vm
+}
 
-	diskUsage, err := c.getDiskUsageFromKubeletStats(ctx, nodeName, targetPod.Name, volumes)
+// GetWukongForVM gets the Wukong backed by the named KubeVirt VM.
+// Returns nil if no Wukong in the namespace refers to it.
+func (c *Client) GetWukongForVM(ctx context.Context, namespace, vmName string) (*unstructured.Unstructured, error) {
+	wukongs, err := c.ListWukongs(ctx, namespace)
 	if err != nil {
-		return 0
+		return nil, err
 	}
-
-	return diskUsage
+	for _, obj := range wukongs {
+		if name, _, _ := unstructured.NestedString(obj, "status", "vmName"); name == vmName {
+			return &unstructured.Unstructured{Object: obj}, nil
+		}
+	}
+	return nil, nil
 }
 
-// getDiskUsageFromKubeletStats gets disk usage from kubelet stats API
-// This requires nodes/proxy permission in the service account
-func (c *Client) getDiskUsageFromKubeletStats(ctx context.Context, nodeName, podName string, volumes []interface{}) (int, error) {
-	// Access kubelet stats API via nodes/proxy
-	// Path: /api/v1/nodes/{node}/proxy/stats/summary
-	path := fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", nodeName)
-	
-	restClient := c.clientset.CoreV1().RESTClient()
-	
-	// Use DoRaw() to get raw JSON, then parse manually
-	raw, err := restClient.Get().
-		AbsPath(path).
-		Do(ctx).
-		Raw()
-
-	if err != nil {
-		return 0, fmt.Errorf("failed to get kubelet stats: %w", err)
-	}
-
-	var summary struct {
-		Pods []struct {
-			PodRef struct {
-				Name      string `json:"name"`
-				Namespace string `json:"namespace"`
-			} `json:"podRef"`
-			Volume []struct {
-				Name          string `json:"name"`
-				UsedBytes     *int64 `json:"usedBytes,omitempty"`
-				CapacityBytes *int64 `json:"capacityBytes,omitempty"`
-			} `json:"volume"`
-		} `json:"pods"`
-	}
-
-	if err := json.Unmarshal(raw, &summary); err != nil {
-		return 0, fmt.Errorf("failed to parse kubelet stats: %w", err)
-	}
-
-	// Find the pod in summary
-	var podStats *struct {
-		PodRef struct {
-			Name      string `json:"name"`
-			Namespace string `json:"namespace"`
-		} `json:"podRef"`
-		Volume []struct {
-			Name          string `json:"name"`
-			UsedBytes     *int64 `json:"usedBytes,omitempty"`
-			CapacityBytes *int64 `json:"capacityBytes,omitempty"`
-		} `json:"volume"`
-	}
-
-	for i := range summary.Pods {
-		pod := &summary.Pods[i]
-		if pod.PodRef.Name == podName && pod.PodRef.Namespace == c.namespace {
-			podStats = pod
-			break
-		}
+// GetWukongMetrics gets the metrics of the KubeVirt VM backing a Wukong.
+// Returns nil if the VM is not running or its metrics are not available.
+func (c *Client) GetWukongMetrics(ctx context.Context, wukong *unstructured.Unstructured) (*MetricsInfo, error) {
+	vmName, _, _ := unstructured.NestedString(wukong.Object, "status", "vmName")
+	if vmName == "" {
+		return nil, nil
 	}
 
-	if podStats == nil {
-		return 0, fmt.Errorf("pod %s/%s not found in kubelet stats", c.namespace, podName)
+	vm := c.GetVMInfo(ctx, wukong, false)
+	if vm.Status != "Running" {
+		return nil, nil
 	}
 
+	return c.GetVMMetrics(ctx, wukong.GetNamespace(), vmName, vm.CPU, vm.Memory, wukong)
+}
+
+// getDiskUsageFromVolumeStats calculates the disk usage percentage of the Wukong volumes
+// from the kubelet volume stats of the virt-launcher pod
+func getDiskUsageFromVolumeStats(volumes []interface{}, stats *podStats) (int, error) {
 	// Match volumes from Wukong status with kubelet volume stats
 	var totalUsedBytes int64
 	var totalCapacityBytes int64
@@ -484,13 +534,13 @@ func (c *Client) getDiskUsageFromKubeletStats(ctx context.Context, nodeName, pod
 		// Find matching volume in kubelet stats
 		// IMPORTANT: kubelet stats uses the Pod volume name (e.g., "system", "data"), NOT the PVC name
 		// So we match by volume name, not PVC name
-		for _, volStat := range podStats.Volume {
+		for _, volStat := range stats.Volume {
 			// Match by volume name (e.g., "system", "data")
 			if volumeName != "" && volStat.Name == volumeName {
 				if volStat.UsedBytes != nil {
 					totalUsedBytes += *volStat.UsedBytes
 				}
-				
+
 				if volStat.CapacityBytes != nil {
 					totalCapacityBytes += *volStat.CapacityBytes
 				} else {
@@ -510,22 +560,8 @@ func (c *Client) getDiskUsageFromKubeletStats(ctx context.Context, nodeName, pod
 	if diskUsagePercent > 100 {
 		diskUsagePercent = 100
 	}
-	
-	return diskUsagePercent, nil
-}
 
-// getDiskUsageFromPodVolumes is an alternative method to get disk usage
-// It queries PVCs directly to get their capacity, but cannot get actual usage
-// This is a fallback when kubelet stats are not available
-// Currently not used, kept for future implementation
-func (c *Client) getDiskUsageFromPodVolumes(ctx context.Context, pod *corev1.Pod, volumes []interface{}) int {
-	// For now, we can only get capacity from PVC, not actual usage
-	// So we return 0 to indicate usage is not available
-	// In production, you might want to:
-	// 1. Query storage provider API (e.g., Longhorn API)
-	// 2. Query Prometheus metrics
-	// 3. Use exec into the pod to run `df` command (not recommended for production)
-	return 0
+	return diskUsagePercent, nil
 }
 
 // parseMemoryToBytes converts memory string (e.g., "4Gi") to bytes
@@ -564,4 +600,3 @@ func parseMemoryToBytes(memory string) int64 {
 
 	return value * multiplier
 }
-
@@ -54,12 +54,49 @@ type MetricsInfo struct {
 	CPUUsage    int `json:"cpuUsage"`    // Percentage (0-100)
 	MemoryUsage int `json:"memoryUsage"` // Percentage (0-100)
 	DiskUsage   int `json:"diskUsage"`   // Percentage (0-100)
+	// Per-disk and per-interface stats, in the order of VMInfo disks and networks
+	Disks    []DiskMetrics    `json:"disks,omitempty"`
+	Networks []NetworkMetrics `json:"networks,omitempty"`
+}
+
+// DiskMetrics represents the usage and I/O of one VM disk, matched to DiskInfo by name.
+// Used and capacity come from kubelet stats, I/O rates from the Prometheus provider only:
+// cAdvisor counts reads and writes per node block device, which cannot be told apart by VM disk.
+type DiskMetrics struct {
+	Name             string  `json:"name"`
+	UsedBytes        int64   `json:"usedBytes"`
+	CapacityBytes    int64   `json:"capacityBytes"`
+	ReadBytesPerSec  float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
+	ReadIOPS         float64 `json:"readIops"`
+	WriteIOPS        float64 `json:"writeIops"`
+}
+
+// NetworkMetrics represents the traffic of one VM network interface, matched to NetworkInfo by name.
+// Counters since the virt-launcher pod started come from kubelet stats of its pod interface,
+// rates from the Prometheus provider.
+type NetworkMetrics struct {
+	Name            string  `json:"name"`
+	Interface       string  `json:"interface,omitempty"`
+	RxBytes         uint64  `json:"rxBytes"`
+	TxBytes         uint64  `json:"txBytes"`
+	RxPackets       uint64  `json:"rxPackets"`
+	TxPackets       uint64  `json:"txPackets"`
+	RxErrors        uint64  `json:"rxErrors"`
+	TxErrors        uint64  `json:"txErrors"`
+	RxBytesPerSec   float64 `json:"rxBytesPerSec"`
+	TxBytesPerSec   float64 `json:"txBytesPerSec"`
+	RxPacketsPerSec float64 `json:"rxPacketsPerSec"`
+	TxPacketsPerSec float64 `json:"txPacketsPerSec"`
+	RxErrorsPerSec  float64 `json:"rxErrorsPerSec"`
+	TxErrorsPerSec  float64 `json:"txErrorsPerSec"`
 }
 
 // SnapshotInfo represents a simplified view of a WukongSnapshot
 type SnapshotInfo struct {
 	ID         string `json:"id"`
 	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
 	WukongID   string `json:"wukongId"`
 	WukongName string `json:"wukongName"`
 	Status     string `json:"status"`
@@ -176,6 +213,7 @@ func ConvertSnapshotToInfo(obj *unstructured.Unstructured) *SnapshotInfo {
 	snapshot := &SnapshotInfo{
 		ID:        string(obj.GetUID()),
 		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
 		CreatedAt: obj.GetCreationTimestamp().UnixMilli(),
 	}
 
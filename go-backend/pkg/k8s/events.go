@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// eventSource is the component reported on Events recorded by the dashboard
+const eventSource = "wukong-dashboard"
+
+// kindGVRs maps the kinds Events can be recorded on to their resources
+var kindGVRs = map[string]schema.GroupVersionResource{
+	"Wukong":                 WukongGVR,
+	"WukongSnapshot":         WukongSnapshotGVR,
+	"VirtualMachine":         VirtualMachineGVR,
+	"VirtualMachineInstance": VirtualMachineInstanceGVR,
+}
+
+// RecordEvent records a Kubernetes Event on the named object of the given kind.
+// The object does not need to exist anymore; its UID is filled in if it does.
+func (c *Client) RecordEvent(ctx context.Context, kind, namespace, name, eventType, reason, message string) error {
+	gvr, ok := kindGVRs[kind]
+	if !ok {
+		return fmt.Errorf("cannot record events on kind %q", kind)
+	}
+
+	involved := corev1.ObjectReference{
+		APIVersion: gvr.GroupVersion().String(),
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+	}
+	obj, err := c.getObject(ctx, gvr, namespace, name)
+	if err == nil {
+		involved.UID = obj.GetUID()
+		involved.ResourceVersion = obj.GetResourceVersion()
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err = c.service.CoreV1().Events(namespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
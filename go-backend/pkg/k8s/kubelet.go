@@ -0,0 +1,385 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// podStats is the kubelet stats summary of one pod
+type podStats struct {
+	PodRef struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"podRef"`
+	Network *struct {
+		// The default interface is inlined, all interfaces are listed in Interfaces
+		Name       string           `json:"name"`
+		Interfaces []interfaceStats `json:"interfaces"`
+	} `json:"network,omitempty"`
+	Volume []volumeStats `json:"volume"`
+}
+
+// interfaceStats is the kubelet stats summary of one pod network interface
+type interfaceStats struct {
+	Name     string  `json:"name"`
+	RxBytes  *uint64 `json:"rxBytes,omitempty"`
+	RxErrors *uint64 `json:"rxErrors,omitempty"`
+	TxBytes  *uint64 `json:"txBytes,omitempty"`
+	TxErrors *uint64 `json:"txErrors,omitempty"`
+}
+
+// volumeStats is the kubelet stats summary of one pod volume
+type volumeStats struct {
+	Name          string `json:"name"`
+	UsedBytes     *int64 `json:"usedBytes,omitempty"`
+	CapacityBytes *int64 `json:"capacityBytes,omitempty"`
+}
+
+// packetCounts are the packet counters of one pod network interface
+type packetCounts struct {
+	rx, tx uint64
+}
+
+// kubeletScrapeTTL is how long the stats scraped from the kubelet of a node are reused
+// by the VMs running on it, so that a refresh scrapes each node once
+const kubeletScrapeTTL = 15 * time.Second
+
+// podKey identifies a pod in the stats of a node
+type podKey struct {
+	namespace, name string
+}
+
+// nodeStats are the stats of the virt-launcher pods of one node, scraped together
+type nodeStats struct {
+	// mu is held while the node is scraped, so that concurrent callers share the scrape
+	mu      sync.Mutex
+	fetched time.Time
+	err     error
+	pods    map[podKey]*podStats
+	// packets are the packet counters of each pod interface, nil if cAdvisor failed
+	packets map[podKey]map[string]packetCounts
+}
+
+// kubeletCache holds the latest stats of each node, shared by the impersonating
+// copies of a Client. Kubelet stats are read with the dashboard's own credentials.
+type kubeletCache struct {
+	mu    sync.Mutex
+	nodes map[string]*nodeStats
+}
+
+// addKubeletStats fills the disk usage and the per-disk and per-interface stats of metrics
+// from the kubelet of the node running the Wukong's virt-launcher pod.
+// Stats that are not available are left unset.
+func (c *Client) addKubeletStats(ctx context.Context, wukongObj *unstructured.Unstructured, metrics *MetricsInfo) {
+	status, _, _ := unstructured.NestedMap(wukongObj.Object, "status")
+	if status == nil {
+		return
+	}
+
+	// Get the pod to find which node it's on
+	vmName, ok, _ := unstructured.NestedString(status, "vmName")
+	if !ok || vmName == "" {
+		return
+	}
+
+	// Find the virt-launcher pod
+	targetPod, err := c.findVirtLauncherPod(ctx, wukongObj.GetNamespace(), vmName)
+	if err != nil || targetPod == nil {
+		return
+	}
+	nodeName := targetPod.Spec.NodeName
+	if nodeName == "" {
+		return
+	}
+
+	node, err := c.getNodeStats(ctx, nodeName)
+	if err != nil {
+		return
+	}
+	key := podKey{namespace: targetPod.Namespace, name: targetPod.Name}
+	stats, ok := node.pods[key]
+	if !ok {
+		return
+	}
+
+	if volumes, ok, _ := unstructured.NestedSlice(status, "volumes"); ok && len(volumes) > 0 {
+		if diskUsage, err := getDiskUsageFromVolumeStats(volumes, stats); err == nil {
+			metrics.DiskUsage = diskUsage
+		}
+	}
+
+	vm := ConvertWukongToVMInfo(wukongObj)
+	metrics.Disks = mergeDiskStats(vm.Disks, metrics.Disks, stats.Volume)
+	metrics.Networks = mergeNetworkStats(vm.Networks, metrics.Networks, stats, node.packets[key])
+}
+
+// getNodeStats returns the stats of the virt-launcher pods of a node, scraping its kubelet
+// unless the last scrape, successful or not, is younger than kubeletScrapeTTL.
+// The result must not be modified.
+func (c *Client) getNodeStats(ctx context.Context, nodeName string) (*nodeStats, error) {
+	c.kubelet.mu.Lock()
+	node := c.kubelet.nodes[nodeName]
+	if node == nil {
+		node = &nodeStats{}
+		c.kubelet.nodes[nodeName] = node
+	}
+	c.kubelet.mu.Unlock()
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if time.Since(node.fetched) < kubeletScrapeTTL {
+		return node, node.err
+	}
+
+	node.pods, node.err = c.getPodStats(ctx, nodeName)
+	if node.err == nil {
+		// The stats summary has no packet counters, they come from the kubelet's cAdvisor metrics
+		node.packets, _ = c.getPodNetworkPackets(ctx, nodeName)
+	}
+	node.fetched = time.Now()
+	return node, node.err
+}
+
+// getPodStats gets the stats summary of the virt-launcher pods of a node from the kubelet stats API
+// This requires nodes/proxy permission in the service account
+func (c *Client) getPodStats(ctx context.Context, nodeName string) (map[podKey]*podStats, error) {
+	// Access kubelet stats API via nodes/proxy
+	// Path: /api/v1/nodes/{node}/proxy/stats/summary
+	path := fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", nodeName)
+
+	raw, err := c.service.CoreV1().RESTClient().Get().
+		AbsPath(path).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubelet stats: %w", err)
+	}
+
+	var summary struct {
+		Pods []podStats `json:"pods"`
+	}
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse kubelet stats: %w", err)
+	}
+
+	pods := make(map[podKey]*podStats)
+	for i := range summary.Pods {
+		pod := &summary.Pods[i]
+		if strings.HasPrefix(pod.PodRef.Name, virtLauncherPrefix) {
+			pods[podKey{namespace: pod.PodRef.Namespace, name: pod.PodRef.Name}] = pod
+		}
+	}
+	return pods, nil
+}
+
+// getPodNetworkPackets gets the packet counters of every interface of the virt-launcher
+// pods of a node from the kubelet's cAdvisor metrics
+func (c *Client) getPodNetworkPackets(ctx context.Context, nodeName string) (map[podKey]map[string]packetCounts, error) {
+	path := fmt.Sprintf("/api/v1/nodes/%s/proxy/metrics/cadvisor", nodeName)
+
+	stream, err := c.service.CoreV1().RESTClient().Get().
+		AbsPath(path).
+		Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubelet cAdvisor metrics: %w", err)
+	}
+	defer stream.Close()
+	return parsePodNetworkPackets(stream)
+}
+
+// parsePodNetworkPackets reads the packet counters of the virt-launcher pod interfaces
+// from cAdvisor metrics in the Prometheus text format
+func parsePodNetworkPackets(r io.Reader) (map[podKey]map[string]packetCounts, error) {
+	packets := make(map[podKey]map[string]packetCounts)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		rx := strings.HasPrefix(line, "container_network_receive_packets_total{")
+		tx := strings.HasPrefix(line, "container_network_transmit_packets_total{")
+		if !rx && !tx || !strings.Contains(line, `pod="`+virtLauncherPrefix) {
+			continue
+		}
+
+		labels, value, ok := parseSample(line)
+		if !ok || labels["interface"] == "" {
+			continue
+		}
+		key := podKey{namespace: labels["namespace"], name: labels["pod"]}
+		if packets[key] == nil {
+			packets[key] = make(map[string]packetCounts)
+		}
+		counts := packets[key][labels["interface"]]
+		if rx {
+			counts.rx = value
+		} else {
+			counts.tx = value
+		}
+		packets[key][labels["interface"]] = counts
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read kubelet cAdvisor metrics: %w", err)
+	}
+	return packets, nil
+}
+
+// parseSample extracts the labels and the value of a Prometheus text format sample
+func parseSample(line string) (map[string]string, uint64, bool) {
+	labelsStart := strings.Index(line, "{")
+	labelsEnd := strings.LastIndex(line, "}")
+	if labelsStart < 0 || labelsEnd < labelsStart {
+		return nil, 0, false
+	}
+
+	labels := make(map[string]string)
+	rest := line[labelsStart+1 : labelsEnd]
+	for rest != "" {
+		name, after, ok := strings.Cut(rest, `="`)
+		if !ok {
+			return nil, 0, false
+		}
+		// Label values escape backslashes, quotes and newlines
+		var value strings.Builder
+		i := 0
+		for ; i < len(after) && after[i] != '"'; i++ {
+			if after[i] == '\\' && i+1 < len(after) {
+				i++
+				if after[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(after[i])
+		}
+		if i == len(after) {
+			return nil, 0, false
+		}
+		labels[strings.TrimSpace(name)] = value.String()
+		rest = strings.TrimPrefix(after[i+1:], ",")
+	}
+
+	fields := strings.Fields(line[labelsEnd+1:])
+	if len(fields) == 0 {
+		return nil, 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, 0, false
+	}
+	return labels, uint64(value), true
+}
+
+// mergeDiskStats matches kubelet volume stats to the VM disks by name and merges them
+// with the disk metrics already reported. Disks are returned in the order of the VM disks,
+// followed by reported disks that are not VM disks.
+func mergeDiskStats(disks []DiskInfo, reported []DiskMetrics, volumes []volumeStats) []DiskMetrics {
+	byName := make(map[string]DiskMetrics, len(reported))
+	for _, d := range reported {
+		byName[d.Name] = d
+	}
+
+	var result []DiskMetrics
+	for _, disk := range disks {
+		m, ok := byName[disk.Name]
+		if !ok {
+			m = DiskMetrics{Name: disk.Name}
+		}
+		delete(byName, disk.Name)
+
+		for _, vol := range volumes {
+			if vol.Name != disk.Name {
+				continue
+			}
+			if vol.UsedBytes != nil {
+				m.UsedBytes = *vol.UsedBytes
+			}
+			if vol.CapacityBytes != nil {
+				m.CapacityBytes = *vol.CapacityBytes
+			}
+			break
+		}
+		if m.CapacityBytes == 0 {
+			// Fallback to the requested disk size
+			m.CapacityBytes = parseMemoryToBytes(disk.Size)
+		}
+		result = append(result, m)
+	}
+
+	for _, d := range reported {
+		if _, ok := byName[d.Name]; ok {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// mergeNetworkStats matches kubelet interface stats to the VM networks and merges them with
+// the network metrics already reported. A network is matched by the pod interface in its
+// status; the first network without one is the pod's default interface. Networks are returned
+// in the order of the VM networks, followed by reported interfaces that are not VM networks.
+func mergeNetworkStats(networks []NetworkInfo, reported []NetworkMetrics, stats *podStats, packets map[string]packetCounts) []NetworkMetrics {
+	byName := make(map[string]NetworkMetrics, len(reported))
+	for _, n := range reported {
+		byName[n.Name] = n
+	}
+
+	var ifaces []interfaceStats
+	defaultIface := ""
+	if stats.Network != nil {
+		ifaces = stats.Network.Interfaces
+		defaultIface = stats.Network.Name
+	}
+
+	var result []NetworkMetrics
+	for i, network := range networks {
+		m, ok := byName[network.Name]
+		if !ok {
+			m = NetworkMetrics{Name: network.Name}
+		}
+		delete(byName, network.Name)
+
+		m.Interface = network.Interface
+		if m.Interface == "" && i == 0 {
+			m.Interface = defaultIface
+		}
+		for _, iface := range ifaces {
+			if m.Interface == "" || iface.Name != m.Interface {
+				continue
+			}
+			m.RxBytes = valueOf(iface.RxBytes)
+			m.TxBytes = valueOf(iface.TxBytes)
+			m.RxErrors = valueOf(iface.RxErrors)
+			m.TxErrors = valueOf(iface.TxErrors)
+			break
+		}
+		if counts, ok := packets[m.Interface]; ok && m.Interface != "" {
+			m.RxPackets = counts.rx
+			m.TxPackets = counts.tx
+		}
+		result = append(result, m)
+	}
+
+	for _, n := range reported {
+		if _, ok := byName[n.Name]; ok {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// valueOf returns the value of an optional counter, or 0
+func valueOf(v *uint64) uint64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
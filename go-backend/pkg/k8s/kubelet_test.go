@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePodNetworkPackets(t *testing.T) {
+	metrics := `# HELP container_network_receive_packets_total Cumulative count of packets received
+# TYPE container_network_receive_packets_total counter
+container_network_receive_packets_total{container="",id="/kubepods/pod1",interface="eth0",name="",namespace="vms",pod="virt-launcher-web-1-abcde"} 1200 1700000000000
+container_network_receive_packets_total{container="",id="/kubepods/pod1",interface="net1",name="",namespace="vms",pod="virt-launcher-web-1-abcde"} 30 1700000000000
+container_network_transmit_packets_total{container="",id="/kubepods/pod1",interface="eth0",name="",namespace="vms",pod="virt-launcher-web-1-abcde"} 800 1700000000000
+container_network_receive_packets_total{container="",id="/kubepods/pod2",interface="eth0",name="",namespace="other",pod="virt-launcher-db-fghij"} 5 1700000000000
+container_network_receive_packets_total{container="",id="/kubepods/pod3",interface="eth0",name="",namespace="kube-system",pod="coredns-12345"} 99 1700000000000
+container_network_receive_bytes_total{container="",id="/kubepods/pod1",interface="eth0",name="",namespace="vms",pod="virt-launcher-web-1-abcde"} 5e+06 1700000000000
+`
+	got, err := parsePodNetworkPackets(strings.NewReader(metrics))
+	if err != nil {
+		t.Fatalf("parsePodNetworkPackets() error = %v", err)
+	}
+	want := map[podKey]map[string]packetCounts{
+		{namespace: "vms", name: "virt-launcher-web-1-abcde"}: {
+			"eth0": {rx: 1200, tx: 800},
+			"net1": {rx: 30},
+		},
+		{namespace: "other", name: "virt-launcher-db-fghij"}: {
+			"eth0": {rx: 5},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePodNetworkPackets() = %v, want %v", got, want)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	labels, value, ok := parseSample(`metric{a="1",b="x\"y\\z",c="line\nbreak"} 4.2e+01`)
+	want := map[string]string{"a": "1", "b": `x"y\z`, "c": "line\nbreak"}
+	if !ok || value != 42 || !reflect.DeepEqual(labels, want) {
+		t.Errorf("parseSample() = %v, %d, %v, want %v, 42", labels, value, ok, want)
+	}
+
+	for _, line := range []string{`metric 1`, `metric{a="1"}`, `metric{a="1} 1`, `metric{a} 1`, `metric{a="1"} x`} {
+		if _, _, ok := parseSample(line); ok {
+			t.Errorf("parseSample(%q) succeeded, want failure", line)
+		}
+	}
+}
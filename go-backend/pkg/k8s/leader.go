@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"context"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Timing of the leader election, the client-go defaults
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// LeaseConfig identifies the Lease electing the leader among the replicas of the backend
+type LeaseConfig struct {
+	Namespace string
+	Name      string
+	// Identity is the holder identity of this replica, e.g. the pod name
+	Identity string
+}
+
+// RunLeaderElection campaigns for the Lease until ctx is done. Whenever this replica
+// becomes the leader, lead is called with a context that is cancelled when it loses the Lease.
+func (c *Client) RunLeaderElection(ctx context.Context, lease LeaseConfig, lead func(ctx context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: lease.Namespace,
+			Name:      lease.Name,
+		},
+		Client: c.service.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: lease.Identity,
+		},
+	}
+
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lease.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("Acquired lease %s/%s, %s is the leader", lease.Namespace, lease.Name, lease.Identity)
+				lead(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("Lost or released lease %s/%s", lease.Namespace, lease.Name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != lease.Identity {
+					log.Printf("Lease %s/%s is held by %s", lease.Namespace, lease.Name, identity)
+				}
+			},
+		},
+	}
+
+	// RunOrDie returns when the lease is lost, campaign again
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, config)
+	}
+}
@@ -0,0 +1,107 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MetricsProvider reports the resource usage of running VMs.
+// It returns nil, without error, if no metrics are available for the VM.
+type MetricsProvider interface {
+	VMMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error)
+}
+
+// SetMetricsProvider replaces the provider backing GetVMMetrics
+func (c *Client) SetMetricsProvider(provider MetricsProvider) {
+	c.metrics = provider
+}
+
+// UsePrometheus makes GetVMMetrics read from the Prometheus at baseURL,
+// falling back to metrics-server for VMs Prometheus has no series for
+func (c *Client) UsePrometheus(baseURL string) {
+	c.SetMetricsProvider(FallbackMetricsProvider(
+		NewPrometheusMetricsProvider(c, baseURL, nil),
+		c.MetricsServerProvider(),
+	))
+}
+
+// MetricsServerProvider returns a provider reading the virt-launcher pod usage
+// from metrics-server and disk usage from kubelet stats
+func (c *Client) MetricsServerProvider() MetricsProvider {
+	return metricsServerProvider{client: c}
+}
+
+// metricsServerProvider is the MetricsProvider backed by metrics-server
+type metricsServerProvider struct {
+	client *Client
+}
+
+// VMMetrics implements MetricsProvider
+func (p metricsServerProvider) VMMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
+	return p.client.getMetricsServerMetrics(ctx, namespace, vmName, allocatedCPU, allocatedMemory, wukongObj)
+}
+
+// fallbackLogInterval is the minimum interval between two failures of a provider logged by
+// a fallback provider; a provider that is down fails for every VM on every refresh
+const fallbackLogInterval = time.Minute
+
+// FallbackMetricsProvider returns a provider asking each provider in turn
+// until one has metrics for the VM. Errors are logged, at most once per
+// fallbackLogInterval for each provider, and the next provider is asked.
+func FallbackMetricsProvider(providers ...MetricsProvider) MetricsProvider {
+	return &fallbackProvider{
+		providers:  providers,
+		lastLogged: make([]time.Time, len(providers)),
+		suppressed: make([]int, len(providers)),
+	}
+}
+
+// fallbackProvider is the MetricsProvider returned by FallbackMetricsProvider
+type fallbackProvider struct {
+	providers []MetricsProvider
+
+	mu         sync.Mutex
+	lastLogged []time.Time
+	// suppressed counts the failures of each provider not logged since its last logged one
+	suppressed []int
+}
+
+// VMMetrics implements MetricsProvider
+func (p *fallbackProvider) VMMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
+	var lastErr error
+	for i, provider := range p.providers {
+		metrics, err := provider.VMMetrics(ctx, namespace, vmName, allocatedCPU, allocatedMemory, wukongObj)
+		if err != nil {
+			p.logFailure(i, namespace, vmName, err)
+			lastErr = err
+			continue
+		}
+		if metrics != nil {
+			return metrics, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// logFailure logs a failure of the i-th provider, unless one was logged within fallbackLogInterval
+func (p *fallbackProvider) logFailure(i int, namespace, vmName string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Sub(p.lastLogged[i]) < fallbackLogInterval {
+		p.suppressed[i]++
+		return
+	}
+
+	suppressed := ""
+	if p.suppressed[i] > 0 {
+		suppressed = fmt.Sprintf(" (%d more failures since the last report)", p.suppressed[i])
+	}
+	log.Printf("Failed to get metrics for VM %s/%s from %T: %v%s", namespace, vmName, p.providers[i], err, suppressed)
+	p.lastLogged[i], p.suppressed[i] = now, 0
+}
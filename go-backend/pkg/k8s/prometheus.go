@@ -0,0 +1,277 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// prometheusRateWindow is the range over which counter rates are computed.
+	// It must span several scrapes of the KubeVirt handler.
+	prometheusRateWindow = "2m"
+
+	// prometheusTimeout bounds a single Prometheus query
+	prometheusTimeout = 10 * time.Second
+
+	// prometheusBatchTTL is how long the series of a namespace are reused by the VMs in it,
+	// so that listing or sampling many VMs runs one batch of queries per namespace
+	prometheusBatchTTL = 15 * time.Second
+
+	// prometheusErrorBodyLimit bounds the body read from a failed Prometheus response
+	prometheusErrorBodyLimit = 64 << 10
+)
+
+// PrometheusMetricsProvider reads VM usage from the kubevirt_vmi_* series
+// exported by virt-handler and scraped by Prometheus. Unlike metrics-server,
+// it reports the guest's own vCPU time and memory use, excluding QEMU overhead.
+type PrometheusMetricsProvider struct {
+	client     *Client
+	baseURL    string
+	httpClient *http.Client
+
+	mu         sync.Mutex
+	namespaces map[string]*promNamespace
+}
+
+// promNamespace holds the last batch of series queried for the VMIs of a namespace
+type promNamespace struct {
+	// mu is held while the batch is queried, so that concurrent callers share it
+	mu      sync.Mutex
+	fetched time.Time
+	vms     map[string]*promVM
+}
+
+// promVM is the usage of a VMI, read from the series of its namespace
+type promVM struct {
+	// scraped reports whether the VMI has vCPU series, without which it has no metrics
+	scraped   bool
+	cpu       float64
+	memory    float64
+	hasMemory bool
+	disks     map[string]*DiskMetrics
+	nics      map[string]*NetworkMetrics
+}
+
+// NewPrometheusMetricsProvider creates a provider querying the Prometheus HTTP API at baseURL.
+// client is used for disk capacity and usage, which KubeVirt does not export. If httpClient is nil,
+// a client with a default timeout is used.
+func NewPrometheusMetricsProvider(client *Client, baseURL string, httpClient *http.Client) *PrometheusMetricsProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: prometheusTimeout}
+	}
+	return &PrometheusMetricsProvider{
+		client:     client,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+		namespaces: make(map[string]*promNamespace),
+	}
+}
+
+// promSample is one element of an instant vector
+type promSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+// value returns the sample value
+func (s promSample) value() float64 {
+	str, _ := s.Value[1].(string)
+	v, _ := strconv.ParseFloat(str, 64)
+	return v
+}
+
+// promResponse is the Prometheus HTTP API response of an instant query
+type promResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string       `json:"resultType"`
+		Result     []promSample `json:"result"`
+	} `json:"data"`
+}
+
+// VMMetrics implements MetricsProvider
+func (p *PrometheusMetricsProvider) VMMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
+	vms, err := p.namespaceMetrics(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	vm := vms[vmName]
+	if vm == nil || !vm.scraped {
+		// The VMI is not scraped (yet)
+		return nil, nil
+	}
+
+	metrics := &MetricsInfo{
+		Disks:    make([]DiskMetrics, 0, len(vm.disks)),
+		Networks: make([]NetworkMetrics, 0, len(vm.nics)),
+	}
+	if allocatedCPU > 0 {
+		metrics.CPUUsage = percent(vm.cpu, float64(allocatedCPU))
+	}
+	if allocatedMemoryBytes := parseMemoryToBytes(allocatedMemory); allocatedMemoryBytes > 0 && vm.hasMemory {
+		metrics.MemoryUsage = percent(vm.memory, float64(allocatedMemoryBytes))
+	}
+	for _, d := range vm.disks {
+		metrics.Disks = append(metrics.Disks, *d)
+	}
+	sort.Slice(metrics.Disks, func(i, j int) bool { return metrics.Disks[i].Name < metrics.Disks[j].Name })
+	for _, n := range vm.nics {
+		metrics.Networks = append(metrics.Networks, *n)
+	}
+	sort.Slice(metrics.Networks, func(i, j int) bool { return metrics.Networks[i].Name < metrics.Networks[j].Name })
+
+	if wukongObj != nil {
+		p.client.addKubeletStats(ctx, wukongObj, metrics)
+	}
+	return metrics, nil
+}
+
+// namespaceMetrics returns the usage of the VMIs of a namespace by name, from the last
+// batch of queries if it is younger than prometheusBatchTTL. The result must not be modified.
+func (p *PrometheusMetricsProvider) namespaceMetrics(ctx context.Context, namespace string) (map[string]*promVM, error) {
+	p.mu.Lock()
+	ns := p.namespaces[namespace]
+	if ns == nil {
+		ns = &promNamespace{}
+		p.namespaces[namespace] = ns
+	}
+	p.mu.Unlock()
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.vms != nil && time.Since(ns.fetched) < prometheusBatchTTL {
+		return ns.vms, nil
+	}
+
+	vms, err := p.queryNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	ns.vms, ns.fetched = vms, time.Now()
+	return vms, nil
+}
+
+// queryNamespace queries the usage of every VMI of a namespace, grouping each series by VMI name
+func (p *PrometheusMetricsProvider) queryNamespace(ctx context.Context, namespace string) (map[string]*promVM, error) {
+	selector := fmt.Sprintf(`namespace=%q`, namespace)
+	vms := make(map[string]*promVM)
+	vmOf := func(sample promSample) *promVM {
+		name := sample.Metric["name"]
+		if vms[name] == nil {
+			vms[name] = &promVM{disks: make(map[string]*DiskMetrics), nics: make(map[string]*NetworkMetrics)}
+		}
+		return vms[name]
+	}
+	diskOf := func(sample promSample) *DiskMetrics {
+		vm, name := vmOf(sample), sample.Metric["drive"]
+		if vm.disks[name] == nil {
+			vm.disks[name] = &DiskMetrics{Name: name}
+		}
+		return vm.disks[name]
+	}
+	nicOf := func(sample promSample) *NetworkMetrics {
+		vm, name := vmOf(sample), sample.Metric["interface"]
+		if vm.nics[name] == nil {
+			vm.nics[name] = &NetworkMetrics{Name: name}
+		}
+		return vm.nics[name]
+	}
+
+	queries := []struct {
+		query string
+		set   func(sample promSample)
+	}{
+		// vCPU time, in cores used
+		{promRate("kubevirt_vmi_vcpu_seconds_total", selector, "name"), func(s promSample) {
+			vm := vmOf(s)
+			vm.scraped, vm.cpu = true, s.value()
+		}},
+		// Guest memory in use
+		{fmt.Sprintf(`sum by (name) (kubevirt_vmi_memory_used_bytes{%s})`, selector), func(s promSample) {
+			vm := vmOf(s)
+			vm.hasMemory, vm.memory = true, s.value()
+		}},
+		{promRate("kubevirt_vmi_storage_read_traffic_bytes_total", selector, "name, drive"), func(s promSample) { diskOf(s).ReadBytesPerSec = s.value() }},
+		{promRate("kubevirt_vmi_storage_write_traffic_bytes_total", selector, "name, drive"), func(s promSample) { diskOf(s).WriteBytesPerSec = s.value() }},
+		{promRate("kubevirt_vmi_storage_iops_read_total", selector, "name, drive"), func(s promSample) { diskOf(s).ReadIOPS = s.value() }},
+		{promRate("kubevirt_vmi_storage_iops_write_total", selector, "name, drive"), func(s promSample) { diskOf(s).WriteIOPS = s.value() }},
+		{promRate("kubevirt_vmi_network_receive_bytes_total", selector, "name, interface"), func(s promSample) { nicOf(s).RxBytesPerSec = s.value() }},
+		{promRate("kubevirt_vmi_network_transmit_bytes_total", selector, "name, interface"), func(s promSample) { nicOf(s).TxBytesPerSec = s.value() }},
+		{promRate("kubevirt_vmi_network_receive_packets_total", selector, "name, interface"), func(s promSample) { nicOf(s).RxPacketsPerSec = s.value() }},
+		{promRate("kubevirt_vmi_network_transmit_packets_total", selector, "name, interface"), func(s promSample) { nicOf(s).TxPacketsPerSec = s.value() }},
+		{promRate("kubevirt_vmi_network_receive_errors_total", selector, "name, interface"), func(s promSample) { nicOf(s).RxErrorsPerSec = s.value() }},
+		{promRate("kubevirt_vmi_network_transmit_errors_total", selector, "name, interface"), func(s promSample) { nicOf(s).TxErrorsPerSec = s.value() }},
+	}
+
+	for _, q := range queries {
+		samples, err := p.query(ctx, q.query)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			q.set(sample)
+		}
+	}
+	return vms, nil
+}
+
+// promRate returns the query of the per-second rate of a counter, summed by the given labels
+func promRate(metric, selector, by string) string {
+	return fmt.Sprintf(`sum by (%s) (rate(%s{%s}[%s]))`, by, metric, selector, prometheusRateWindow)
+}
+
+// query runs an instant query and returns the resulting vector
+func (p *PrometheusMetricsProvider) query(ctx context.Context, query string) ([]promSample, error) {
+	u := p.baseURL + "/api/v1/query?" + url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result promResponse
+	if resp.StatusCode != http.StatusOK {
+		// Query errors come with a JSON body, other failures (proxies, auth) may not
+		if err := json.NewDecoder(io.LimitReader(resp.Body, prometheusErrorBodyLimit)).Decode(&result); err == nil && result.Error != "" {
+			return nil, fmt.Errorf("prometheus query failed (%s): %s: %s", resp.Status, result.ErrorType, result.Error)
+		}
+		return nil, fmt.Errorf("prometheus query failed: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode prometheus response (%s): %w", resp.Status, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s: %s", result.ErrorType, result.Error)
+	}
+	if result.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected prometheus result type %q", result.Data.ResultType)
+	}
+	return result.Data.Result, nil
+}
+
+// percent returns used as a percentage of total, capped at 100
+func percent(used, total float64) int {
+	p := int(used / total * 100)
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
@@ -0,0 +1,185 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// testPrometheus serves instant queries from canned series, keyed by metric name
+type testPrometheus struct {
+	server  *httptest.Server
+	queries atomic.Int32
+}
+
+// newTestPrometheus starts a Prometheus answering each query with the samples of the metric
+// it names, or with status and an error body if status is not 200
+func newTestPrometheus(t *testing.T, status int, series map[string][]promSample) *testPrometheus {
+	t.Helper()
+	prom := &testPrometheus{}
+	prom.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prom.queries.Add(1)
+		query := r.URL.Query().Get("query")
+		if r.URL.Path != "/api/v1/query" || !strings.Contains(query, `namespace="vms"`) {
+			t.Errorf("unexpected query %s %q", r.URL.Path, query)
+		}
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"status": "error", "errorType": "bad_data", "error": "parse error"})
+			return
+		}
+
+		result := []promSample{}
+		for metric, samples := range series {
+			if strings.Contains(query, metric+"{") {
+				result = samples
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"resultType": "vector", "result": result},
+		})
+	}))
+	t.Cleanup(prom.server.Close)
+	return prom
+}
+
+// sample returns a sample with the given labels and value
+func sample(value string, labels ...string) promSample {
+	metric := make(map[string]string)
+	for i := 0; i+1 < len(labels); i += 2 {
+		metric[labels[i]] = labels[i+1]
+	}
+	return promSample{Metric: metric, Value: [2]interface{}{1700000000.0, value}}
+}
+
+func TestPrometheusMetricsProvider(t *testing.T) {
+	prom := newTestPrometheus(t, http.StatusOK, map[string][]promSample{
+		"kubevirt_vmi_vcpu_seconds_total": {
+			sample("1", "name", "web-1"),
+			sample("0.5", "name", "web-2"),
+		},
+		"kubevirt_vmi_memory_used_bytes": {
+			sample("1073741824", "name", "web-1"),
+		},
+		"kubevirt_vmi_storage_read_traffic_bytes_total": {
+			sample("2048", "name", "web-1", "drive", "root"),
+			sample("1024", "name", "web-1", "drive", "data"),
+		},
+		"kubevirt_vmi_storage_iops_write_total": {
+			sample("7", "name", "web-1", "drive", "root"),
+		},
+		"kubevirt_vmi_network_receive_bytes_total": {
+			sample("300", "name", "web-1", "interface", "default"),
+		},
+		"kubevirt_vmi_network_transmit_errors_total": {
+			sample("0.25", "name", "web-1", "interface", "default"),
+		},
+	})
+	p := NewPrometheusMetricsProvider(nil, prom.server.URL+"/", nil)
+	ctx := context.Background()
+
+	got, err := p.VMMetrics(ctx, "vms", "web-1", 4, "4Gi", nil)
+	if err != nil {
+		t.Fatalf("VMMetrics() error = %v", err)
+	}
+	want := &MetricsInfo{
+		CPUUsage:    25,
+		MemoryUsage: 25,
+		Disks: []DiskMetrics{
+			{Name: "data", ReadBytesPerSec: 1024},
+			{Name: "root", ReadBytesPerSec: 2048, WriteIOPS: 7},
+		},
+		Networks: []NetworkMetrics{
+			{Name: "default", RxBytesPerSec: 300, TxErrorsPerSec: 0.25},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VMMetrics() = %+v, want %+v", got, want)
+	}
+
+	// The other VMs of the namespace are served from the same batch of queries
+	batch := prom.queries.Load()
+	got, err = p.VMMetrics(ctx, "vms", "web-2", 1, "1Gi", nil)
+	if err != nil {
+		t.Fatalf("VMMetrics() error = %v", err)
+	}
+	if got.CPUUsage != 50 || got.MemoryUsage != 0 {
+		t.Errorf("VMMetrics() = %+v, want 50%% CPU and no memory usage", got)
+	}
+	if queries := prom.queries.Load(); queries != batch {
+		t.Errorf("VMMetrics() ran %d more queries, want the namespace batch to be reused", queries-batch)
+	}
+
+	got, err = p.VMMetrics(ctx, "vms", "not-scraped", 1, "1Gi", nil)
+	if err != nil || got != nil {
+		t.Errorf("VMMetrics() = %+v, %v, want no metrics for a VMI without series", got, err)
+	}
+}
+
+func TestPrometheusMetricsProviderQueryError(t *testing.T) {
+	prom := newTestPrometheus(t, http.StatusBadRequest, nil)
+	p := NewPrometheusMetricsProvider(nil, prom.server.URL, nil)
+
+	_, err := p.VMMetrics(context.Background(), "vms", "web-1", 1, "1Gi", nil)
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "parse error") {
+		t.Errorf("VMMetrics() error = %v, want the status and the Prometheus error", err)
+	}
+
+	// A failed batch is not cached
+	before := prom.queries.Load()
+	if _, err := p.VMMetrics(context.Background(), "vms", "web-1", 1, "1Gi", nil); err == nil {
+		t.Error("VMMetrics() succeeded, want the query error again")
+	}
+	if prom.queries.Load() == before {
+		t.Error("VMMetrics() did not query again after a failed batch")
+	}
+}
+
+// stubProvider returns fixed metrics or an error
+type stubProvider struct {
+	metrics *MetricsInfo
+	err     error
+	calls   int
+}
+
+// VMMetrics implements MetricsProvider
+func (p *stubProvider) VMMetrics(ctx context.Context, namespace, vmName string, allocatedCPU int64, allocatedMemory string, wukongObj *unstructured.Unstructured) (*MetricsInfo, error) {
+	p.calls++
+	return p.metrics, p.err
+}
+
+func TestFallbackMetricsProvider(t *testing.T) {
+	failing := &stubProvider{err: errors.New("unreachable")}
+	empty := &stubProvider{}
+	backup := &stubProvider{metrics: &MetricsInfo{CPUUsage: 10}}
+
+	p := FallbackMetricsProvider(failing, empty, backup)
+	for i := 0; i < 3; i++ {
+		got, err := p.VMMetrics(context.Background(), "vms", "web-1", 1, "1Gi", nil)
+		if err != nil || got != backup.metrics {
+			t.Fatalf("VMMetrics() = %+v, %v, want the metrics of the last provider", got, err)
+		}
+	}
+	if failing.calls != 3 || empty.calls != 3 {
+		t.Errorf("providers called %d and %d times, want 3", failing.calls, empty.calls)
+	}
+	fallback := p.(*fallbackProvider)
+	if fallback.suppressed[0] != 2 {
+		t.Errorf("suppressed %d failure logs, want 2 within the log interval", fallback.suppressed[0])
+	}
+
+	p = FallbackMetricsProvider(failing)
+	if _, err := p.VMMetrics(context.Background(), "vms", "web-1", 1, "1Gi", nil); err != failing.err {
+		t.Errorf("VMMetrics() error = %v, want the last provider error", err)
+	}
+}
@@ -0,0 +1,260 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultClusterName is the name of the cluster when no cluster registry is configured
+const DefaultClusterName = "default"
+
+// healthCheckTimeout bounds the connectivity check of a single cluster
+const healthCheckTimeout = 5 * time.Second
+
+// ClusterConfig describes how to connect to one cluster
+type ClusterConfig struct {
+	Name string `json:"name"`
+	// Kubeconfig is the kubeconfig file path, defaults to KUBECONFIG or ~/.kube/config
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context, defaults to the current context
+	Context string `json:"context,omitempty"`
+	// InCluster uses the service account of the pod instead of a kubeconfig
+	InCluster bool `json:"inCluster,omitempty"`
+	// Namespace is the default namespace of the cluster
+	Namespace string `json:"namespace,omitempty"`
+	// PrometheusURL is the Prometheus scraping the cluster's KubeVirt metrics.
+	// If set, VM metrics are read from it, falling back to metrics-server.
+	PrometheusURL string `json:"prometheusURL,omitempty"`
+}
+
+// RegistryConfig is the cluster registry configuration file format
+type RegistryConfig struct {
+	// Default is the cluster served by routes without a cluster prefix, defaults to the first cluster
+	Default  string          `json:"default,omitempty"`
+	Clusters []ClusterConfig `json:"clusters"`
+}
+
+// ClusterHealth reports the connectivity of a registered cluster
+type ClusterHealth struct {
+	Name      string `json:"name"`
+	Default   bool   `json:"default"`
+	Namespace string `json:"namespace"`
+	Healthy   bool   `json:"healthy"`
+	Version   string `json:"version,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Registry holds one Client per managed cluster
+type Registry struct {
+	clients     map[string]*Client
+	names       []string
+	defaultName string
+}
+
+// NewRegistry creates a registry with a client for every configured cluster.
+// defaultNamespace applies to clusters that do not set their own namespace.
+func NewRegistry(config *RegistryConfig, defaultNamespace string) (*Registry, error) {
+	if len(config.Clusters) == 0 {
+		return nil, fmt.Errorf("no clusters configured")
+	}
+
+	r := &Registry{
+		clients:     make(map[string]*Client),
+		defaultName: config.Default,
+	}
+
+	for _, cc := range config.Clusters {
+		if cc.Name == "" {
+			return nil, fmt.Errorf("cluster name is required")
+		}
+		if _, exists := r.clients[cc.Name]; exists {
+			return nil, fmt.Errorf("duplicate cluster %q", cc.Name)
+		}
+
+		restConfig, err := buildRestConfig(cc)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: failed to get kubeconfig: %w", cc.Name, err)
+		}
+
+		namespace := cc.Namespace
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+
+		client, err := NewClientForConfig(cc.Name, restConfig, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cc.Name, err)
+		}
+		if cc.PrometheusURL != "" {
+			client.UsePrometheus(cc.PrometheusURL)
+		}
+		r.clients[cc.Name] = client
+		r.names = append(r.names, cc.Name)
+	}
+
+	if r.defaultName == "" {
+		r.defaultName = r.names[0]
+	}
+	if _, ok := r.clients[r.defaultName]; !ok {
+		return nil, fmt.Errorf("default cluster %q is not configured", r.defaultName)
+	}
+
+	return r, nil
+}
+
+// NewSingleClusterRegistry creates a registry holding only the given client
+func NewSingleClusterRegistry(client *Client) *Registry {
+	return &Registry{
+		clients:     map[string]*Client{client.Name(): client},
+		names:       []string{client.Name()},
+		defaultName: client.Name(),
+	}
+}
+
+// LoadRegistryConfig reads a cluster registry configuration file in YAML or JSON
+func LoadRegistryConfig(path string) (*RegistryConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config RegistryConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// RegistryConfigFromContexts builds a registry configuration with one cluster per kubeconfig context.
+// contexts selects the contexts to use; if empty, every context of the kubeconfig is used.
+// Clusters are named after their context and the current context is the default.
+func RegistryConfigFromContexts(kubeconfig string, contexts []string) (*RegistryConfig, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+
+	raw, err := rules.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	if len(contexts) == 0 {
+		for name := range raw.Contexts {
+			contexts = append(contexts, name)
+		}
+		sort.Strings(contexts)
+	}
+
+	config := &RegistryConfig{}
+	for _, name := range contexts {
+		kubeCtx, ok := raw.Contexts[name]
+		if !ok {
+			return nil, fmt.Errorf("context %q not found in kubeconfig", name)
+		}
+		config.Clusters = append(config.Clusters, ClusterConfig{
+			Name:       name,
+			Kubeconfig: kubeconfig,
+			Context:    name,
+			Namespace:  kubeCtx.Namespace,
+		})
+		if name == raw.CurrentContext {
+			config.Default = name
+		}
+	}
+	return config, nil
+}
+
+// buildRestConfig returns the REST config of a configured cluster
+func buildRestConfig(cc ClusterConfig) (*rest.Config, error) {
+	if cc.InCluster {
+		return rest.InClusterConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if cc.Kubeconfig != "" {
+		rules.ExplicitPath = cc.Kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cc.Context}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// Get returns the client of the named cluster, or the default cluster if name is empty
+func (r *Registry) Get(name string) (*Client, bool) {
+	if name == "" {
+		name = r.defaultName
+	}
+	client, ok := r.clients[name]
+	return client, ok
+}
+
+// Default returns the client of the default cluster
+func (r *Registry) Default() *Client {
+	return r.clients[r.defaultName]
+}
+
+// Clients returns the clients of all clusters in configuration order
+func (r *Registry) Clients() []*Client {
+	clients := make([]*Client, 0, len(r.names))
+	for _, name := range r.names {
+		clients = append(clients, r.clients[name])
+	}
+	return clients
+}
+
+// Start starts the informer caches of all clusters and waits for their initial sync
+func (r *Registry) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, client := range r.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.Start(ctx)
+		}(client)
+	}
+	wg.Wait()
+}
+
+// Health checks the connectivity of every cluster
+func (r *Registry) Health(ctx context.Context) []ClusterHealth {
+	results := make([]ClusterHealth, len(r.names))
+
+	var wg sync.WaitGroup
+	for i, name := range r.names {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			version, err := client.ServerVersion(checkCtx)
+			health := ClusterHealth{
+				Name:      client.Name(),
+				Default:   client.Name() == r.defaultName,
+				Namespace: client.DefaultNamespace(),
+				Healthy:   err == nil,
+				Version:   version,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				health.Error = err.Error()
+			}
+			results[i] = health
+		}(i, r.clients[name])
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,76 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// subresourcesGroupVersion is the API of the KubeVirt subresources
+var subresourcesGroupVersion = schema.GroupVersion{Group: "subresources.kubevirt.io", Version: "v1"}
+
+// VMISubresourceGVR is the resource of the KubeVirt VMI subresources, such as vnc,
+// whose RBAC rules name the subresources API group
+var VMISubresourceGVR = subresourcesGroupVersion.WithResource("virtualmachineinstances")
+
+// newSubresourceClient creates a REST client of the KubeVirt subresource API
+func newSubresourceClient(config *rest.Config) (rest.Interface, error) {
+	config = rest.CopyConfig(config)
+	config.GroupVersion = &subresourcesGroupVersion
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	return rest.RESTClientFor(config)
+}
+
+// VMNameOf returns the name of the KubeVirt VM backing a Wukong, which is also the
+// name of its VMI, or an empty string if the operator has not created it yet
+func VMNameOf(wukong *unstructured.Unstructured) string {
+	vmName, _, _ := unstructured.NestedString(wukong.Object, "status", "vmName")
+	return vmName
+}
+
+// PauseVMI pauses a running VMI, keeping its memory
+func (c *Client) PauseVMI(ctx context.Context, namespace, name string) error {
+	return c.putSubresource(ctx, "virtualmachineinstances", namespace, name, "pause", map[string]interface{}{})
+}
+
+// UnpauseVMI resumes a paused VMI
+func (c *Client) UnpauseVMI(ctx context.Context, namespace, name string) error {
+	return c.putSubresource(ctx, "virtualmachineinstances", namespace, name, "unpause", map[string]interface{}{})
+}
+
+// SoftRebootVMI reboots the guest OS of a VMI through the QEMU guest agent, which must
+// be connected
+func (c *Client) SoftRebootVMI(ctx context.Context, namespace, name string) error {
+	return c.putSubresource(ctx, "virtualmachineinstances", namespace, name, "softreboot", map[string]interface{}{})
+}
+
+// ForceStopVM stops a KubeVirt VM immediately, without waiting for its guest to shut down
+func (c *Client) ForceStopVM(ctx context.Context, namespace, name string) error {
+	return c.putSubresource(ctx, "virtualmachines", namespace, name, "stop", map[string]interface{}{"gracePeriod": 0})
+}
+
+// putSubresource calls a KubeVirt subresource of a VM or VMI with the given options
+func (c *Client) putSubresource(ctx context.Context, resource, namespace, name, subresource string, options map[string]interface{}) error {
+	if c.subresources == nil {
+		return fmt.Errorf("KubeVirt subresource client is not configured")
+	}
+	body, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	return c.subresources.Put().
+		Namespace(namespace).
+		Resource(resource).
+		Name(name).
+		SubResource(subresource).
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do(ctx).
+		Error()
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+)
+
+// namespace prefixes every metric of the backend
+const namespace = "wukong"
+
+var (
+	// HTTPRequestDuration observes API request latency per route
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// WebSocketClients is the number of connected WebSocket clients
+	WebSocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "clients",
+		Help:      "Number of connected WebSocket clients.",
+	})
+
+	// WebSocketDroppedMessages counts messages not delivered to clients that fell behind
+	WebSocketDroppedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "dropped_messages_total",
+		Help:      "Messages dropped because a WebSocket client's send buffer was full.",
+	})
+
+	// AuditDroppedEvents counts audit events dropped because the audit queue stayed full
+	AuditDroppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "audit",
+		Name:      "dropped_events_total",
+		Help:      "Audit events dropped because the audit queue stayed full.",
+	})
+
+	// VNCSessions is the number of upstream VNC sessions, each shared by the viewers of a VM
+	VNCSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "vnc",
+		Name:      "sessions",
+		Help:      "Number of active upstream VNC sessions.",
+	})
+
+	// VNCViewers is the number of browsers connected to VNC sessions
+	VNCViewers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "vnc",
+		Name:      "viewers",
+		Help:      "Number of browsers connected to VNC sessions.",
+	})
+
+	// VNCBytes counts bytes proxied between VNC clients and KubeVirt
+	VNCBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "vnc",
+		Name:      "bytes_total",
+		Help:      "Bytes proxied by VNC sessions by direction.",
+	}, []string{"direction"})
+
+	// ConsoleSessions is the number of active serial console proxy sessions
+	ConsoleSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "console",
+		Name:      "sessions",
+		Help:      "Number of active serial console proxy sessions.",
+	})
+
+	// KubernetesRequestDuration observes Kubernetes API request latency
+	KubernetesRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "kubernetes",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of Kubernetes API requests by API server host and verb.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"host", "verb"})
+
+	// KubernetesRequests counts Kubernetes API requests by result
+	KubernetesRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kubernetes",
+		Name:      "requests_total",
+		Help:      "Kubernetes API requests by API server host, method and status code.",
+	}, []string{"host", "method", "code"})
+
+	// WatchErrors counts informer watch failures, each followed by a reconnect
+	WatchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kubernetes",
+		Name:      "watch_errors_total",
+		Help:      "Informer watch failures, each followed by a reconnect, by cluster and resource.",
+	}, []string{"cluster", "resource"})
+)
+
+func init() {
+	clientmetrics.Register(clientmetrics.RegisterOpts{
+		RequestLatency: requestLatency{},
+		RequestResult:  requestResult{},
+	})
+}
+
+// requestLatency records client-go request latency
+type requestLatency struct{}
+
+func (requestLatency) Observe(ctx context.Context, verb string, u url.URL, latency time.Duration) {
+	KubernetesRequestDuration.WithLabelValues(u.Host, verb).Observe(latency.Seconds())
+}
+
+// requestResult records client-go request results
+type requestResult struct{}
+
+func (requestResult) Increment(ctx context.Context, code, method, host string) {
+	KubernetesRequests.WithLabelValues(host, method, code).Inc()
+}
+
+// Middleware observes the latency of each request under its route template
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
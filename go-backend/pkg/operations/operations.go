@@ -0,0 +1,381 @@
+// Package operations runs long-running actions on resources in the background and
+// tracks their progress
+package operations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+// States of an operation
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// DefaultTimeout bounds an operation, including waiting for the resource to settle
+const DefaultTimeout = 10 * time.Minute
+
+// retention is how long operations are kept after their last update
+const retention = time.Hour
+
+// busTopic is the bus topic of operation updates
+const busTopic = "operations"
+
+// subscribeRetryInterval is the delay before retrying a failed bus subscription
+const subscribeRetryInterval = 5 * time.Second
+
+// publishTimeout bounds publishing an update to the bus
+const publishTimeout = 5 * time.Second
+
+// ErrBusy is returned when starting an operation on a resource that has one in progress
+var ErrBusy = errors.New("another operation is in progress on this resource")
+
+// Operation is an action on a resource and its progress
+type Operation struct {
+	ID string `json:"id"`
+	// Type is the action, e.g. "vm.restart" or "snapshot.create"
+	Type      string `json:"type"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Resource is the type of the target, "vm" or "snapshot"
+	Resource string `json:"resource"`
+	Name     string `json:"name"`
+	Actor    string `json:"actor,omitempty"`
+	State    string `json:"state"`
+	// Progress is a percentage
+	Progress   int        `json:"progress"`
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Done reports whether the operation has finished
+func (o Operation) Done() bool {
+	return o.State == StateSucceeded || o.State == StateFailed
+}
+
+// Filter selects operations. Empty fields match everything.
+type Filter struct {
+	Cluster   string
+	Namespace string
+	Resource  string
+	Name      string
+	State     string
+}
+
+// matches reports whether the operation is selected by the filter
+func (f Filter) matches(o Operation) bool {
+	switch {
+	case f.Cluster != "" && o.Cluster != f.Cluster:
+	case f.Namespace != "" && o.Namespace != f.Namespace:
+	case f.Resource != "" && o.Resource != f.Resource:
+	case f.Name != "" && o.Name != f.Name:
+	case f.State != "" && o.State != f.State:
+	default:
+		return true
+	}
+	return false
+}
+
+// Func drives an operation to completion, reporting its progress to the tracker.
+// ctx is cancelled when the operation times out.
+type Func func(ctx context.Context, t *Tracker) error
+
+// Manager runs operations and keeps the operations of every replica, shared over a bus.
+// An operation runs on the replica that started it. Resources are reserved on the bus,
+// so that one operation at a time runs on a resource across replicas.
+type Manager struct {
+	bus     bus.Bus
+	timeout time.Duration
+	// notify is called with every update of an operation running on this replica
+	notify func(Operation)
+
+	mu  sync.RWMutex
+	ops map[string]Operation
+}
+
+// NewManager creates a manager bounding operations by timeout. notify is called with
+// every update of the operations started on this replica, and may be nil.
+func NewManager(messageBus bus.Bus, timeout time.Duration, notify func(Operation)) *Manager {
+	return &Manager{
+		bus:     messageBus,
+		timeout: timeout,
+		notify:  notify,
+		ops:     make(map[string]Operation),
+	}
+}
+
+// Timeout returns the bound of an operation
+func (m *Manager) Timeout() time.Duration {
+	return m.timeout
+}
+
+// Reserve records a pending operation on the resource described by op, so that no other
+// operation starts on it while the action the operation tracks is made. Returns ErrBusy
+// if the resource has an operation in progress on any replica. The reservation must be
+// started once the action is made, or failed or skipped if it is not.
+func (m *Manager) Reserve(ctx context.Context, op Operation) (*Reservation, error) {
+	now := time.Now().UTC()
+	op.ID = newID()
+	op.State = StatePending
+	op.CreatedAt = now
+	op.UpdatedAt = now
+
+	// The operations of other replicas may not have arrived yet, the bus claim decides
+	if _, ok := m.Active(op.Cluster, op.Namespace, op.Resource, op.Name); ok {
+		return nil, ErrBusy
+	}
+	claimed, err := m.bus.Claim(ctx, reservationKey(op), m.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve %s %s/%s: %w", op.Resource, op.Namespace, op.Name, err)
+	}
+	if !claimed {
+		return nil, ErrBusy
+	}
+
+	m.mu.Lock()
+	m.ops[op.ID] = op
+	m.mu.Unlock()
+
+	m.publish(op)
+	return &Reservation{manager: m, op: op}, nil
+}
+
+// reservationKey is the bus key reserving the resource of an operation. The claim
+// expires with the operation timeout if the replica running it stops.
+func reservationKey(op Operation) string {
+	return "operation:" + op.Cluster + "/" + op.Namespace + "/" + op.Resource + "/" + op.Name
+}
+
+// release frees the resource of a finished operation for the next one
+func (m *Manager) release(op Operation) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := m.bus.Release(ctx, reservationKey(op)); err != nil {
+		log.Printf("Operation %s: %s %s/%s stays reserved until the reservation expires: %v", op.ID, op.Resource, op.Namespace, op.Name, err)
+	}
+}
+
+// Reservation is a pending operation whose action is being made
+type Reservation struct {
+	manager *Manager
+	op      Operation
+}
+
+// Operation returns the reserved operation
+func (r *Reservation) Operation() Operation {
+	return r.op
+}
+
+// Start runs the reserved operation in the background and returns it as pending
+func (r *Reservation) Start(run Func) Operation {
+	go r.manager.run(r.op, run)
+	return r.op
+}
+
+// Fail finishes the reserved operation as failed with err, the failure of its action
+func (r *Reservation) Fail(err error) {
+	t := &Tracker{manager: r.manager, op: r.op}
+	t.update(func(o *Operation) {
+		finished := time.Now().UTC()
+		o.FinishedAt = &finished
+		o.State = StateFailed
+		o.Error = err.Error()
+	})
+	r.manager.release(t.op)
+}
+
+// Skip finishes the reserved operation as succeeded without running it, when its
+// action turns out not to be needed, explained by message
+func (r *Reservation) Skip(message string) {
+	t := &Tracker{manager: r.manager, op: r.op}
+	t.update(func(o *Operation) {
+		finished := time.Now().UTC()
+		o.FinishedAt = &finished
+		o.State = StateSucceeded
+		o.Progress = 100
+		o.Message = message
+	})
+	r.manager.release(t.op)
+}
+
+// Active returns the operation in progress on a resource, if any
+func (m *Manager) Active(cluster, namespace, resource, name string) (Operation, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.activeLocked(cluster, namespace, resource, name)
+}
+
+// activeLocked returns the operation in progress on a resource. An operation that
+// outlived the timeout is ignored, since the replica running it must have stopped.
+func (m *Manager) activeLocked(cluster, namespace, resource, name string) (Operation, bool) {
+	for _, op := range m.ops {
+		if op.Cluster == cluster && op.Namespace == namespace && op.Resource == resource && op.Name == name &&
+			!op.Done() && time.Since(op.CreatedAt) < m.timeout {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+// run drives an operation until it finishes or times out
+func (m *Manager) run(op Operation, run Func) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	t := &Tracker{manager: m, op: op}
+	t.update(func(o *Operation) { o.State = StateRunning })
+
+	err := run(ctx, t)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s: %w", m.timeout, err)
+	}
+
+	t.update(func(o *Operation) {
+		finished := time.Now().UTC()
+		o.FinishedAt = &finished
+		if err != nil {
+			o.State = StateFailed
+			o.Error = err.Error()
+			return
+		}
+		o.State = StateSucceeded
+		o.Progress = 100
+	})
+	m.release(t.op)
+	if err != nil {
+		log.Printf("Operation %s (%s %s/%s) failed: %v", op.ID, op.Type, op.Namespace, op.Name, err)
+	}
+}
+
+// Get returns an operation
+func (m *Manager) Get(id string) (Operation, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	op, ok := m.ops[id]
+	return op, ok
+}
+
+// List returns the operations matching filter, newest first
+func (m *Manager) List(filter Filter) []Operation {
+	m.mu.RLock()
+	ops := []Operation{}
+	for _, op := range m.ops {
+		if filter.matches(op) {
+			ops = append(ops, op)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].CreatedAt.After(ops[j].CreatedAt)
+	})
+	return ops
+}
+
+// Receive applies the operation updates published on the bus until ctx is done
+func (m *Manager) Receive(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := m.bus.Subscribe(ctx, busTopic, func(msg bus.Message) {
+			var op Operation
+			if err := json.Unmarshal(msg.Data, &op); err != nil {
+				log.Printf("Failed to decode operation update %d: %v", msg.Seq, err)
+				return
+			}
+			m.store(op)
+		})
+		if err != nil {
+			log.Printf("Operations bus subscription failed, retrying in %s: %v", subscribeRetryInterval, err)
+			select {
+			case <-time.After(subscribeRetryInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// store keeps an update of an operation unless a later one is known, and forgets
+// operations not updated within the retention period
+func (m *Manager) store(op Operation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.ops[op.ID]; !ok || !current.UpdatedAt.After(op.UpdatedAt) {
+		m.ops[op.ID] = op
+	}
+	for id, other := range m.ops {
+		if time.Since(other.UpdatedAt) > retention {
+			delete(m.ops, id)
+		}
+	}
+}
+
+// publish records an update of an operation of this replica and shares it
+func (m *Manager) publish(op Operation) {
+	m.store(op)
+	if m.notify != nil {
+		m.notify(op)
+	}
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		log.Printf("Failed to marshal operation %s: %v", op.ID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := m.bus.Publish(ctx, busTopic, data); err != nil {
+		log.Printf("Failed to publish operation %s: %v", op.ID, err)
+	}
+}
+
+// Tracker reports the progress of a running operation
+type Tracker struct {
+	manager *Manager
+	op      Operation
+}
+
+// Operation returns the operation being tracked
+func (t *Tracker) Operation() Operation {
+	return t.op
+}
+
+// Progress reports that the operation is progress percent done, at the step message
+func (t *Tracker) Progress(progress int, message string) {
+	if progress == t.op.Progress && message == t.op.Message {
+		return
+	}
+	t.update(func(o *Operation) {
+		o.Progress = progress
+		o.Message = message
+	})
+}
+
+// update changes the operation and publishes it
+func (t *Tracker) update(change func(*Operation)) {
+	change(&t.op)
+	t.op.UpdatedAt = time.Now().UTC()
+	t.manager.publish(t.op)
+}
+
+// newID returns a random operation ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+func TestReserveAcrossManagers(t *testing.T) {
+	ctx := context.Background()
+	messageBus := bus.NewMemory()
+	finished := make(chan Operation, 1)
+	first := NewManager(messageBus, time.Minute, func(op Operation) {
+		if op.FinishedAt != nil {
+			finished <- op
+		}
+	})
+	second := NewManager(messageBus, time.Minute, nil)
+	web1 := Operation{Type: "vm.restart", Cluster: "prod", Namespace: "vms", Resource: "vm", Name: "web-1"}
+	web2 := Operation{Type: "vm.restart", Cluster: "prod", Namespace: "vms", Resource: "vm", Name: "web-2"}
+
+	tests := []struct {
+		name    string
+		manager *Manager
+		op      Operation
+		// finish ends the reservation, or leaves it in progress if nil
+		finish  func(*Reservation)
+		wantErr error
+	}{
+		{"first reservation", first, web1, nil, nil},
+		{"same VM on another replica", second, web1, nil, ErrBusy},
+		{"same VM on the same replica", first, web1, nil, ErrBusy},
+		{"another VM", second, web2, func(r *Reservation) { r.Fail(errors.New("stop failed")) }, nil},
+		{"after a failure", first, web2, func(r *Reservation) { r.Skip("No changes") }, nil},
+		{"after a skip", second, web2, func(r *Reservation) {
+			r.Start(func(ctx context.Context, t *Tracker) error { return nil })
+			<-finished
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := tt.manager.Reserve(ctx, tt.op)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reserve() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && tt.finish != nil {
+				tt.finish(r)
+			}
+		})
+	}
+
+	// A finished operation releases its VM on every replica, just after its last update
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := first.Reserve(ctx, web2)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Reserve() after the operation finished: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
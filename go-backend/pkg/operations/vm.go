@@ -0,0 +1,89 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Statuses of a settled VM
+const (
+	VMRunning = "Running"
+	VMStopped = "Stopped"
+	VMPaused  = "Paused"
+)
+
+// DefaultShutdownTimeout is how long a graceful shutdown waits for the guest to power
+// off before the VM is forced off
+const DefaultShutdownTimeout = 2 * time.Minute
+
+// AwaitVM returns an operation waiting for a VM to reach status, e.g. after it was
+// created or its running state was changed
+func AwaitVM(client *k8s.Client, namespace, name, status string) Func {
+	return func(ctx context.Context, t *Tracker) error {
+		return waitForVM(ctx, t, client, namespace, name, 10, status)
+	}
+}
+
+// AwaitVMDeleted returns an operation waiting for a deleted VM to disappear
+func AwaitVMDeleted(client *k8s.Client, namespace, name string) Func {
+	return func(ctx context.Context, t *Tracker) error {
+		return waitForVMDeleted(ctx, t, client, namespace, name, 10)
+	}
+}
+
+// RestartVM returns an operation completing the restart of a VM whose stop was
+// requested: it waits for the VM to stop, starts it and waits for it to run
+func RestartVM(client *k8s.Client, namespace, name string) Func {
+	return func(ctx context.Context, t *Tracker) error {
+		if err := waitForVM(ctx, t, client, namespace, name, 10, VMStopped); err != nil {
+			return err
+		}
+		t.Progress(50, "Starting VM "+name)
+		if err := client.StartVM(ctx, namespace, name); err != nil {
+			return err
+		}
+		return waitForVM(ctx, t, client, namespace, name, 60, VMRunning)
+	}
+}
+
+// AwaitSnapshot returns an operation waiting for a created snapshot to succeed
+func AwaitSnapshot(client *k8s.Client, namespace, name string) Func {
+	return func(ctx context.Context, t *Tracker) error {
+		return waitForSnapshot(ctx, t, client, namespace, name, 10)
+	}
+}
+
+// ShutdownVM returns an operation completing the graceful shutdown of a VM whose stop
+// was requested, which sent the guest an ACPI power-off. If the guest has not powered
+// off within timeout, the KubeVirt VM vmName is forced off.
+func ShutdownVM(client *k8s.Client, namespace, name, vmName string, timeout time.Duration) Func {
+	return func(ctx context.Context, t *Tracker) error {
+		shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := waitForVM(shutdownCtx, t, client, namespace, name, 10, VMStopped)
+		timedOut := errors.Is(shutdownCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+		if !timedOut {
+			return err
+		}
+
+		t.Progress(50, fmt.Sprintf("Guest did not shut down within %s, forcing VM %s off", timeout, name))
+		// A conflict means the VM stopped in the meantime
+		if err := client.ForceStopVM(ctx, namespace, vmName); err != nil && !apierrors.IsConflict(err) {
+			return fmt.Errorf("failed to force VM %s off: %w", name, err)
+		}
+		return waitForVM(ctx, t, client, namespace, name, 60, VMStopped)
+	}
+}
+
+// AwaitReboot returns an operation waiting for the guest agent of the VMI vmName to
+// reconnect after a soft reboot, previous being its AgentTransition before the reboot
+func AwaitReboot(client *k8s.Client, namespace, vmName, previous string) Func {
+	return func(ctx context.Context, t *Tracker) error {
+		return waitForAgent(ctx, t, client, namespace, vmName, previous, 10)
+	}
+}
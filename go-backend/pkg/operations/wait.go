@@ -0,0 +1,160 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// pollInterval is how often the status of a resource is checked. Reads are served
+// from the informer cache.
+const pollInterval = time.Second
+
+// failedVMStatuses are the VM statuses that do not resolve without intervention
+var failedVMStatuses = map[string]bool{
+	"Error":                   true,
+	"Failed":                  true,
+	"CrashLoopBackOff":        true,
+	"ErrorUnschedulable":      true,
+	"ErrImagePull":            true,
+	"ImagePullBackOff":        true,
+	"ErrorPvcNotFound":        true,
+	"ErrorDataVolumeNotFound": true,
+	"DataVolumeError":         true,
+}
+
+// waitForVM waits until a VM has one of the wanted statuses, reporting its status
+// changes at progress. It fails if the VM reaches a failed status.
+func waitForVM(ctx context.Context, t *Tracker, client *k8s.Client, namespace, name string, progress int, want ...string) error {
+	status := ""
+	err := wait.PollUntilContextCancel(ctx, pollInterval, true, func(ctx context.Context) (bool, error) {
+		wukong, err := client.GetWukong(ctx, namespace, name)
+		if apierrors.IsNotFound(err) {
+			// A VM just created may not be in the informer cache yet
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		status = client.GetVMInfo(ctx, wukong, false).Status
+		t.Progress(progress, "VM "+name+" is "+status)
+
+		for _, w := range want {
+			if status == w {
+				return true, nil
+			}
+		}
+		if failedVMStatuses[status] {
+			return false, fmt.Errorf("VM %s is %s", name, status)
+		}
+		return false, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("VM %s is %s, waiting for %v", name, status, want)
+	}
+	return err
+}
+
+// waitForVMDeleted waits until a VM no longer exists
+func waitForVMDeleted(ctx context.Context, t *Tracker, client *k8s.Client, namespace, name string, progress int) error {
+	t.Progress(progress, "Waiting for VM "+name+" to be deleted")
+	err := wait.PollUntilContextCancel(ctx, pollInterval, true, func(ctx context.Context) (bool, error) {
+		_, err := client.GetWukong(ctx, namespace, name)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("VM %s still exists", name)
+	}
+	return err
+}
+
+// waitForSnapshot waits until a snapshot has succeeded, reporting its phase changes
+// at progress
+func waitForSnapshot(ctx context.Context, t *Tracker, client *k8s.Client, namespace, name string, progress int) error {
+	status := ""
+	err := wait.PollUntilContextCancel(ctx, pollInterval, true, func(ctx context.Context) (bool, error) {
+		snapshot, err := client.GetSnapshot(ctx, namespace, name)
+		if apierrors.IsNotFound(err) {
+			// A snapshot just created may not be in the informer cache yet
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		status = k8s.ConvertSnapshotToInfo(snapshot).Status
+		t.Progress(progress, "Snapshot "+name+" is "+status)
+
+		switch status {
+		case "Succeeded":
+			return true, nil
+		case "Failed":
+			return false, fmt.Errorf("snapshot %s failed", name)
+		}
+		return false, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("snapshot %s is %s", name, status)
+	}
+	return err
+}
+
+// AgentTransition returns the lastTransitionTime of the AgentConnected condition of the
+// VMI vmName, empty if it has none. Read before a reboot of the guest, it tells the
+// reconnection of the agent apart from its connection before the reboot.
+func AgentTransition(ctx context.Context, client *k8s.Client, namespace, vmName string) (string, error) {
+	vmi, err := client.GetVMI(ctx, namespace, vmName)
+	if err != nil {
+		return "", err
+	}
+	condition := agentCondition(vmi)
+	if condition == nil {
+		return "", nil
+	}
+	transition, _ := condition["lastTransitionTime"].(string)
+	return transition, nil
+}
+
+// agentCondition returns the AgentConnected condition of a VMI, nil if it has none
+func agentCondition(vmi *unstructured.Unstructured) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(vmi.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == "AgentConnected" {
+			return condition
+		}
+	}
+	return nil
+}
+
+// waitForAgent waits until the guest agent of a VMI has reconnected after a reboot of
+// the guest: it is connected, and was seen disconnected or has transitioned since the
+// previous lastTransitionTime returned by AgentTransition. Both times are the API
+// server's, so the replica's clock plays no part.
+func waitForAgent(ctx context.Context, t *Tracker, client *k8s.Client, namespace, vmName, previous string, progress int) error {
+	t.Progress(progress, "Waiting for the guest agent of "+vmName+" to reconnect")
+	disconnected := false
+	err := wait.PollUntilContextCancel(ctx, pollInterval, true, func(ctx context.Context) (bool, error) {
+		vmi, err := client.GetVMI(ctx, namespace, vmName)
+		if err != nil {
+			return false, err
+		}
+		condition := agentCondition(vmi)
+		if condition == nil || condition["status"] != "True" {
+			disconnected = true
+			return false, nil
+		}
+		transition, _ := condition["lastTransitionTime"].(string)
+		return disconnected || transition != previous, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("the guest agent of %s has not reconnected", vmName)
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// projectLockTTL bounds how long a project stays locked by a replica that stopped
+	// while holding the lock, and so the time a quota check and change may take
+	projectLockTTL = 30 * time.Second
+
+	// projectLockRetry is the interval between attempts to lock a project locked by
+	// another replica
+	projectLockRetry = 50 * time.Millisecond
+)
+
+// Manager enforces project quotas on Wukong creation and updates.
+// A nil Manager enforces nothing.
+type Manager struct {
+	registry *k8s.Registry
+	// bus locks projects across replicas
+	bus      bus.Bus
+	projects []*Project
+	byID     map[string]*Project
+	// locks serialize the quota checks and changes of each project within the replica
+	locks map[string]*sync.Mutex
+}
+
+// NewManager creates a quota manager for the configured projects, serializing the
+// changes of each project across replicas through the bus
+func NewManager(registry *k8s.Registry, messageBus bus.Bus, config *Config) (*Manager, error) {
+	m := &Manager{
+		registry: registry,
+		bus:      messageBus,
+		byID:     make(map[string]*Project),
+		locks:    make(map[string]*sync.Mutex),
+	}
+
+	for i := range config.Projects {
+		p := &config.Projects[i]
+		if p.ID == "" {
+			return nil, fmt.Errorf("project id is required")
+		}
+		if _, exists := m.byID[p.ID]; exists {
+			return nil, fmt.Errorf("duplicate project %q", p.ID)
+		}
+		if len(p.Namespaces) == 0 && len(p.Selector) == 0 {
+			return nil, fmt.Errorf("project %s: namespaces or selector is required", p.ID)
+		}
+		if p.Cluster == "" {
+			p.Cluster = registry.Default().Name()
+		}
+		if _, ok := registry.Get(p.Cluster); !ok {
+			return nil, fmt.Errorf("project %s: cluster %q is not configured", p.ID, p.Cluster)
+		}
+		m.projects = append(m.projects, p)
+		m.byID[p.ID] = p
+		m.locks[p.ID] = &sync.Mutex{}
+	}
+	return m, nil
+}
+
+// Projects returns all projects
+func (m *Manager) Projects() []*Project {
+	if m == nil {
+		return nil
+	}
+	return m.projects
+}
+
+// Project returns the project with the given ID
+func (m *Manager) Project(id string) (*Project, bool) {
+	if m == nil {
+		return nil, false
+	}
+	p, ok := m.byID[id]
+	return p, ok
+}
+
+// ProjectFor returns the project owning a Wukong, or nil if it belongs to none
+func (m *Manager) ProjectFor(cluster string, obj *unstructured.Unstructured) *Project {
+	if m == nil {
+		return nil
+	}
+	for _, p := range m.projects {
+		if p.Cluster == cluster && p.Owns(obj.GetNamespace(), obj.GetLabels()) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Assign makes a new Wukong belong to a project when the projects of its namespace
+// select Wukongs by labels, so that leaving out or changing labels cannot escape
+// their quotas. A Wukong owned by no project gets the selector labels of the only
+// project with a selector that may own it, and a *LabelError is returned if there
+// are several such projects or its labels contradict the selector.
+func (m *Manager) Assign(cluster string, obj *unstructured.Unstructured) error {
+	if m == nil || m.ProjectFor(cluster, obj) != nil {
+		return nil
+	}
+
+	var candidates []*Project
+	for _, p := range m.projects {
+		if p.Cluster == cluster && len(p.Selector) > 0 && p.Owns(obj.GetNamespace(), p.Selector) {
+			candidates = append(candidates, p)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+	default:
+		return &LabelError{Namespace: obj.GetNamespace(), Projects: candidates}
+	}
+
+	p := candidates[0]
+	objLabels := obj.GetLabels()
+	if objLabels == nil {
+		objLabels = make(map[string]string)
+	}
+	for key, value := range p.Selector {
+		if current, ok := objLabels[key]; ok && current != value {
+			return &LabelError{Namespace: obj.GetNamespace(), Projects: candidates}
+		}
+		objLabels[key] = value
+	}
+	obj.SetLabels(objLabels)
+	return nil
+}
+
+// Serialize runs fn holding the lock of the project owning obj, if any, so that the
+// quota check and the change made by fn are not interleaved with those of another
+// request on any replica. The checks made within fn read the project usage from the
+// API server, which already counts the changes of the requests before.
+func (m *Manager) Serialize(ctx context.Context, cluster string, obj *unstructured.Unstructured, fn func() error) error {
+	p := m.ProjectFor(cluster, obj)
+	if p == nil {
+		return fn()
+	}
+
+	// The replica's requests queue on the mutex, so that only one at a time polls the bus
+	lock := m.locks[p.ID]
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := "quota:" + p.Cluster + "/" + p.ID
+	if err := m.lockProject(ctx, key); err != nil {
+		return fmt.Errorf("failed to lock project %s: %w", p.ID, err)
+	}
+	defer func() {
+		if err := m.bus.Release(context.Background(), key); err != nil {
+			log.Printf("Project %s stays locked until the lock expires: %v", p.ID, err)
+		}
+	}()
+	return fn()
+}
+
+// lockProject claims the bus key of a project lock, waiting while another replica holds it
+func (m *Manager) lockProject(ctx context.Context, key string) error {
+	for {
+		claimed, err := m.bus.Claim(ctx, key, projectLockTTL)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			return nil
+		}
+		select {
+		case <-time.After(projectLockRetry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Usage returns the resources currently requested by the Wukongs of a project, read
+// from the informer cache
+func (m *Manager) Usage(ctx context.Context, p *Project) (Resources, error) {
+	return m.usage(ctx, p, false, "", "")
+}
+
+// usage sums the resources of the project Wukongs, skipping the Wukong excludeNamespace/excludeName.
+// Wukongs are listed with the dashboard's own credentials, so that usage covers the Wukongs a user
+// may not see, and from the API server if uncached, since the cache lags behind recent creations.
+func (m *Manager) usage(ctx context.Context, p *Project, uncached bool, excludeNamespace, excludeName string) (Resources, error) {
+	client, ok := m.registry.Get(p.Cluster)
+	if !ok {
+		return Resources{}, fmt.Errorf("cluster %q is not configured", p.Cluster)
+	}
+
+	namespaces := p.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var total Resources
+	for _, ns := range namespaces {
+		list := client.ListWukongs
+		if uncached {
+			list = client.ListWukongsUncached
+		}
+		wukongs, err := list(ctx, ns)
+		if err != nil {
+			return Resources{}, fmt.Errorf("failed to list VMs of project %s: %w", p.ID, err)
+		}
+		for _, w := range wukongs {
+			obj := &unstructured.Unstructured{Object: w}
+			if obj.GetNamespace() == excludeNamespace && obj.GetName() == excludeName {
+				continue
+			}
+			if p.Owns(obj.GetNamespace(), obj.GetLabels()) {
+				total = total.Add(ResourcesOf(obj))
+			}
+		}
+	}
+	return total, nil
+}
+
+// CheckCreate verifies that creating the Wukong keeps its project within quota.
+// Returns an *ExceededError if it would not. Must be called within Serialize.
+func (m *Manager) CheckCreate(ctx context.Context, client *k8s.Client, obj *unstructured.Unstructured) error {
+	p := m.ProjectFor(client.Name(), obj)
+	if p == nil {
+		return nil
+	}
+
+	used, err := m.usage(ctx, p, true, obj.GetNamespace(), obj.GetName())
+	if err != nil {
+		return err
+	}
+	return p.check(used, ResourcesOf(obj))
+}
+
+// CheckUpdate verifies that replacing the spec of oldObj by that of newObj keeps its project within quota.
+// Returns an *ExceededError if it would not. Must be called within Serialize.
+func (m *Manager) CheckUpdate(ctx context.Context, client *k8s.Client, oldObj, newObj *unstructured.Unstructured) error {
+	p := m.ProjectFor(client.Name(), newObj)
+	if p == nil {
+		return nil
+	}
+
+	used, err := m.usage(ctx, p, true, newObj.GetNamespace(), newObj.GetName())
+	if err != nil {
+		return err
+	}
+	return p.checkUpdate(used, oldObj, newObj)
+}
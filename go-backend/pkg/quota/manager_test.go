@@ -0,0 +1,120 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+// testManager returns a manager of projects in the "prod" cluster, without a registry
+func testManager(messageBus bus.Bus, projects ...*Project) *Manager {
+	m := &Manager{bus: messageBus, byID: make(map[string]*Project), locks: make(map[string]*sync.Mutex)}
+	for _, p := range projects {
+		p.Cluster = "prod"
+		m.projects = append(m.projects, p)
+		m.byID[p.ID] = p
+		m.locks[p.ID] = &sync.Mutex{}
+	}
+	return m
+}
+
+func TestManagerAssign(t *testing.T) {
+	m := testManager(bus.NewMemory(),
+		&Project{ID: "ml", Namespaces: []string{"shared"}, Selector: map[string]string{"team": "ml"}},
+		&Project{ID: "web", Namespaces: []string{"shared"}, Selector: map[string]string{"team": "web"}},
+		&Project{ID: "batch", Namespaces: []string{"batch"}, Selector: map[string]string{"team": "batch", "tier": "low"}},
+		&Project{ID: "infra", Namespaces: []string{"infra"}},
+	)
+
+	tests := []struct {
+		name       string
+		namespace  string
+		labels     map[string]string
+		wantLabels map[string]string
+		wantErr    bool
+	}{
+		{"already owned", "shared", map[string]string{"team": "ml"}, map[string]string{"team": "ml"}, false},
+		{"namespace project", "infra", nil, nil, false},
+		{"no project", "other", map[string]string{"app": "x"}, map[string]string{"app": "x"}, false},
+		{"single selector project", "batch", map[string]string{"app": "x"}, map[string]string{"app": "x", "team": "batch", "tier": "low"}, false},
+		{"contradicting labels", "batch", map[string]string{"tier": "high"}, nil, true},
+		{"several selector projects", "shared", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := testWukong(tt.namespace, tt.labels, 1, "1Gi")
+			err := m.Assign("prod", obj)
+			if tt.wantErr {
+				var labelErr *LabelError
+				if !errors.As(err, &labelErr) {
+					t.Fatalf("Assign() error = %v, want a LabelError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Assign() error = %v", err)
+			}
+			if got := obj.GetLabels(); !reflect.DeepEqual(got, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", got, tt.wantLabels)
+			}
+		})
+	}
+
+	// Another cluster has no projects
+	if err := m.Assign("dev", testWukong("shared", nil, 1, "1Gi")); err != nil {
+		t.Errorf("Assign() error = %v in a cluster without projects", err)
+	}
+}
+
+func TestManagerSerialize(t *testing.T) {
+	// Two replicas share the bus
+	shared := bus.NewMemory()
+	replicas := []*Manager{
+		testManager(shared, &Project{ID: "ml", Namespaces: []string{"ml"}}),
+		testManager(shared, &Project{ID: "ml", Namespaces: []string{"ml"}}),
+	}
+	obj := testWukong("ml", nil, 1, "1Gi")
+
+	var mu sync.Mutex
+	running, overlapped := 0, false
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(m *Manager) {
+			defer wg.Done()
+			err := m.Serialize(context.Background(), "prod", obj, func() error {
+				mu.Lock()
+				running++
+				overlapped = overlapped || running > 1
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Serialize() error = %v", err)
+			}
+		}(replicas[i%2])
+	}
+	wg.Wait()
+	if overlapped {
+		t.Error("Serialize() ran the changes of a project concurrently across replicas")
+	}
+
+	// A request gives up waiting for a project locked by another replica when its context ends
+	if claimed, _ := shared.Claim(context.Background(), "quota:prod/ml", time.Minute); !claimed {
+		t.Fatal("project lock was not released")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := replicas[0].Serialize(ctx, "prod", obj, func() error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Serialize() error = %v, want the context deadline", err)
+	}
+}
@@ -0,0 +1,273 @@
+package quota
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// bytesPerGB converts byte counts to the GiB units of quota limits
+const bytesPerGB = 1024 * 1024 * 1024
+
+// Limits are the resource limits of a project. A zero limit means unlimited.
+type Limits struct {
+	MaxVMs       int64 `json:"maxVMs"`
+	MaxCPU       int64 `json:"maxCPU"`
+	MaxMemoryGB  int64 `json:"maxMemoryGB"`
+	MaxStorageGB int64 `json:"maxStorageGB"`
+	MaxGPUs      int64 `json:"maxGPUs"`
+}
+
+// Project is a tenant owning the Wukongs of some namespaces and/or matching a label selector
+type Project struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Cluster is the cluster the project lives in, defaults to the default cluster
+	Cluster string `json:"cluster,omitempty"`
+	// Namespaces restricts the project to Wukongs in these namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Selector restricts the project to Wukongs with these labels
+	Selector map[string]string `json:"selector,omitempty"`
+	Quota    Limits            `json:"quota"`
+}
+
+// Config is the projects configuration file format
+type Config struct {
+	Projects []Project `json:"projects"`
+}
+
+// LoadConfig reads a projects configuration file in YAML or JSON
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Owns reports whether the Wukong with the given namespace and labels belongs to the project
+func (p *Project) Owns(namespace string, objLabels map[string]string) bool {
+	if len(p.Namespaces) > 0 {
+		found := false
+		for _, ns := range p.Namespaces {
+			if ns == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(p.Selector) > 0 && !labels.SelectorFromSet(p.Selector).Matches(labels.Set(objLabels)) {
+		return false
+	}
+	return true
+}
+
+// Resources is the amount of resources consumed by one or more Wukongs
+type Resources struct {
+	VMs          int64
+	CPU          int64
+	MemoryBytes  int64
+	StorageBytes int64
+	GPUs         int64
+}
+
+// Add returns r + o
+func (r Resources) Add(o Resources) Resources {
+	return Resources{
+		VMs:          r.VMs + o.VMs,
+		CPU:          r.CPU + o.CPU,
+		MemoryBytes:  r.MemoryBytes + o.MemoryBytes,
+		StorageBytes: r.StorageBytes + o.StorageBytes,
+		GPUs:         r.GPUs + o.GPUs,
+	}
+}
+
+// Sub returns r - o
+func (r Resources) Sub(o Resources) Resources {
+	return Resources{
+		VMs:          r.VMs - o.VMs,
+		CPU:          r.CPU - o.CPU,
+		MemoryBytes:  r.MemoryBytes - o.MemoryBytes,
+		StorageBytes: r.StorageBytes - o.StorageBytes,
+		GPUs:         r.GPUs - o.GPUs,
+	}
+}
+
+// ResourcesOf returns the resources requested by a Wukong spec
+func ResourcesOf(obj *unstructured.Unstructured) Resources {
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	return ResourcesOfSpec(spec)
+}
+
+// ResourcesOfSpec returns the resources requested by a Wukong spec map
+func ResourcesOfSpec(spec map[string]interface{}) Resources {
+	r := Resources{VMs: 1}
+	if spec == nil {
+		return r
+	}
+
+	if cpu, ok, _ := unstructured.NestedFieldNoCopy(spec, "cpu"); ok {
+		r.CPU = ToInt64(cpu)
+	}
+	if memory, ok, _ := unstructured.NestedString(spec, "memory"); ok {
+		r.MemoryBytes = quantityBytes(memory)
+	}
+	for _, disk := range MapSlice(spec["disks"]) {
+		if size, ok := disk["size"].(string); ok {
+			r.StorageBytes += quantityBytes(size)
+		}
+	}
+	r.GPUs = int64(len(MapSlice(spec["gpus"])))
+	return r
+}
+
+// MapSlice returns the maps of a decoded ([]interface{}) or built ([]map[string]interface{}) list
+func MapSlice(v interface{}) []map[string]interface{} {
+	switch items := v.(type) {
+	case []map[string]interface{}:
+		return items
+	case []interface{}:
+		var result []map[string]interface{}
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				result = append(result, m)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Usage is the resource usage of a project in the units of its Limits
+type Usage struct {
+	UsedVMs       int64   `json:"usedVMs"`
+	UsedCPU       int64   `json:"usedCPU"`
+	UsedMemoryGB  float64 `json:"usedMemoryGB"`
+	UsedStorageGB float64 `json:"usedStorageGB"`
+	UsedGPUs      int64   `json:"usedGPUs"`
+}
+
+// UsageOf expresses resources in the units of Limits
+func UsageOf(r Resources) Usage {
+	return Usage{
+		UsedVMs:       r.VMs,
+		UsedCPU:       r.CPU,
+		UsedMemoryGB:  float64(r.MemoryBytes) / bytesPerGB,
+		UsedStorageGB: float64(r.StorageBytes) / bytesPerGB,
+		UsedGPUs:      r.GPUs,
+	}
+}
+
+// ExceededError reports a request that would exceed a project limit
+type ExceededError struct {
+	Project   string  `json:"project"`
+	Resource  string  `json:"resource"`
+	Unit      string  `json:"unit,omitempty"`
+	Requested float64 `json:"requested"`
+	Used      float64 `json:"used"`
+	Limit     float64 `json:"limit"`
+}
+
+// Error implements error
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded for project %s: requesting %g%s, available %g%s of %g%s",
+		e.Resource, e.Project, e.Requested, e.Unit, e.Limit-e.Used, e.Unit, e.Limit, e.Unit)
+}
+
+// LabelError reports a new Wukong whose labels do not select exactly one of the
+// projects that may own it in its namespace
+type LabelError struct {
+	Namespace string
+	Projects  []*Project
+}
+
+// Error implements error
+func (e *LabelError) Error() string {
+	var selectors []string
+	for _, p := range e.Projects {
+		selectors = append(selectors, fmt.Sprintf("%s (%s)", p.ID, labels.SelectorFromSet(p.Selector).String()))
+	}
+	return fmt.Sprintf("labels must select one of the projects of namespace %s: %s",
+		e.Namespace, strings.Join(selectors, ", "))
+}
+
+// check verifies that adding delta to used stays within the project limits
+func (p *Project) check(used, delta Resources) error {
+	after := used.Add(delta)
+	checks := []struct {
+		resource string
+		unit     string
+		limit    int64
+		used     float64
+		after    float64
+		delta    float64
+	}{
+		{"VM", "", p.Quota.MaxVMs, float64(used.VMs), float64(after.VMs), float64(delta.VMs)},
+		{"CPU", "", p.Quota.MaxCPU, float64(used.CPU), float64(after.CPU), float64(delta.CPU)},
+		{"Memory", "GB", p.Quota.MaxMemoryGB, float64(used.MemoryBytes) / bytesPerGB, float64(after.MemoryBytes) / bytesPerGB, float64(delta.MemoryBytes) / bytesPerGB},
+		{"Storage", "GB", p.Quota.MaxStorageGB, float64(used.StorageBytes) / bytesPerGB, float64(after.StorageBytes) / bytesPerGB, float64(delta.StorageBytes) / bytesPerGB},
+		{"GPU", "", p.Quota.MaxGPUs, float64(used.GPUs), float64(after.GPUs), float64(delta.GPUs)},
+	}
+
+	for _, c := range checks {
+		// Zero limits are unlimited, and shrinking is always allowed
+		if c.limit == 0 || c.delta <= 0 {
+			continue
+		}
+		if c.after > float64(c.limit) {
+			return &ExceededError{
+				Project:   p.ID,
+				Resource:  c.resource,
+				Unit:      c.unit,
+				Requested: c.delta,
+				Used:      c.used,
+				Limit:     float64(c.limit),
+			}
+		}
+	}
+	return nil
+}
+
+// checkUpdate verifies that replacing the spec of oldObj by that of newObj stays within
+// the project limits, given the usage of the other Wukongs of the project.
+// Only growth is charged, so a Wukong already over a limit may still shrink.
+func (p *Project) checkUpdate(used Resources, oldObj, newObj *unstructured.Unstructured) error {
+	delta := ResourcesOf(newObj).Sub(ResourcesOf(oldObj))
+	return p.check(used.Add(ResourcesOf(oldObj)), delta)
+}
+
+// quantityBytes parses a Kubernetes quantity such as "4Gi" to bytes, 0 if invalid
+func quantityBytes(value string) int64 {
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0
+	}
+	return q.Value()
+}
+
+// ToInt64 converts a JSON number to int64
+func ToInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// testWukong returns a Wukong as decoded from the cluster
+func testWukong(namespace string, labels map[string]string, cpu int64, memory string, diskSizes ...string) *unstructured.Unstructured {
+	var disks []interface{}
+	for _, size := range diskSizes {
+		disks = append(disks, map[string]interface{}{"name": "disk", "size": size})
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"cpu": cpu, "memory": memory, "disks": disks},
+	}}
+	obj.SetNamespace(namespace)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func TestProjectOwns(t *testing.T) {
+	tests := []struct {
+		name      string
+		project   Project
+		namespace string
+		labels    map[string]string
+		want      bool
+	}{
+		{"namespace", Project{Namespaces: []string{"ml", "ml-dev"}}, "ml-dev", nil, true},
+		{"other namespace", Project{Namespaces: []string{"ml"}}, "web", nil, false},
+		{"selector", Project{Selector: map[string]string{"team": "ml"}}, "any", map[string]string{"team": "ml", "app": "x"}, true},
+		{"selector mismatch", Project{Selector: map[string]string{"team": "ml"}}, "any", map[string]string{"team": "web"}, false},
+		{"no labels", Project{Selector: map[string]string{"team": "ml"}}, "any", nil, false},
+		{"namespace and selector", Project{Namespaces: []string{"ml"}, Selector: map[string]string{"team": "ml"}}, "web", map[string]string{"team": "ml"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.project.Owns(tt.namespace, tt.labels); got != tt.want {
+				t.Errorf("Owns(%q, %v) = %v, want %v", tt.namespace, tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourcesOf(t *testing.T) {
+	obj := testWukong("ml", nil, 4, "8Gi", "20Gi", "512Mi", "invalid")
+	obj.Object["spec"].(map[string]interface{})["gpus"] = []interface{}{
+		map[string]interface{}{"name": "gpu0"}, map[string]interface{}{"name": "gpu1"},
+	}
+
+	want := Resources{VMs: 1, CPU: 4, MemoryBytes: 8 << 30, StorageBytes: 20<<30 + 512<<20, GPUs: 2}
+	if got := ResourcesOf(obj); got != want {
+		t.Errorf("ResourcesOf() = %+v, want %+v", got, want)
+	}
+
+	// A request decodes numbers as float64 and may omit the spec
+	spec := map[string]interface{}{"cpu": float64(2), "memory": "1G"}
+	if got := ResourcesOfSpec(spec); got.CPU != 2 || got.MemoryBytes != 1e9 {
+		t.Errorf("ResourcesOfSpec() = %+v, want 2 CPU and 1e9 bytes", got)
+	}
+	if got := ResourcesOfSpec(nil); got != (Resources{VMs: 1}) {
+		t.Errorf("ResourcesOfSpec(nil) = %+v, want one VM", got)
+	}
+}
+
+func TestProjectCheck(t *testing.T) {
+	p := &Project{ID: "ml", Quota: Limits{MaxVMs: 3, MaxCPU: 8, MaxMemoryGB: 16}}
+	used := Resources{VMs: 2, CPU: 6, MemoryBytes: 8 << 30}
+
+	tests := []struct {
+		name     string
+		delta    Resources
+		resource string
+	}{
+		{"within limits", Resources{VMs: 1, CPU: 2, MemoryBytes: 8 << 30}, ""},
+		{"too many VMs", Resources{VMs: 2}, "VM"},
+		{"too much CPU", Resources{VMs: 1, CPU: 3}, "CPU"},
+		{"too much memory", Resources{MemoryBytes: 9 << 30}, "Memory"},
+		{"unlimited storage", Resources{StorageBytes: 1 << 40}, ""},
+		{"shrinking", Resources{CPU: -4}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.check(used, tt.delta)
+			var exceeded *ExceededError
+			switch {
+			case tt.resource == "" && err != nil:
+				t.Errorf("check() error = %v, want none", err)
+			case tt.resource != "" && (!errors.As(err, &exceeded) || exceeded.Resource != tt.resource):
+				t.Errorf("check() error = %v, want %s quota exceeded", err, tt.resource)
+			}
+		})
+	}
+}
+
+func TestProjectCheckUpdate(t *testing.T) {
+	p := &Project{ID: "ml", Quota: Limits{MaxCPU: 8, MaxStorageGB: 100}}
+	// The other Wukongs of the project use 4 CPU and 50GB
+	used := Resources{VMs: 1, CPU: 4, StorageBytes: 50 << 30}
+	current := testWukong("ml", nil, 2, "4Gi", "40Gi")
+
+	tests := []struct {
+		name    string
+		updated *unstructured.Unstructured
+		wantErr bool
+	}{
+		{"grow within limits", testWukong("ml", nil, 4, "8Gi", "50Gi"), false},
+		{"grow past CPU", testWukong("ml", nil, 5, "4Gi", "40Gi"), true},
+		{"add disk past storage", testWukong("ml", nil, 2, "4Gi", "40Gi", "20Gi"), true},
+		{"shrink", testWukong("ml", nil, 1, "4Gi", "40Gi"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.checkUpdate(used, current, tt.updated)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkUpdate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A Wukong over a limit lowered since may still shrink, but not grow
+	over := Resources{CPU: 8}
+	if err := p.checkUpdate(over, current, testWukong("ml", nil, 1, "4Gi", "40Gi")); err != nil {
+		t.Errorf("checkUpdate() error = %v, want shrinking allowed over the limit", err)
+	}
+	if err := p.checkUpdate(over, current, testWukong("ml", nil, 3, "4Gi", "40Gi")); err == nil {
+		t.Error("checkUpdate() allowed growing over the limit")
+	}
+}
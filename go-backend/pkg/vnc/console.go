@@ -0,0 +1,287 @@
+package vnc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/handlers"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/metrics"
+)
+
+// DefaultConsoleSessions is the default number of concurrent serial console sessions per VM
+const DefaultConsoleSessions = 1
+
+// consoleSlotTTL bounds the claim of a console session slot. It is renewed while the
+// session is open, so that the slots of a replica that stops are freed.
+const consoleSlotTTL = time.Minute
+
+// Console input message types sent by the browser
+const (
+	ConsoleInput  = "input"
+	ConsoleResize = "resize"
+)
+
+// ConsoleMessage is a text frame sent by the browser terminal
+type ConsoleMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// ConsoleProxy handles serial console WebSocket proxying to KubeVirt VMIs
+// The cluster client of each request is resolved by handlers.ClusterMiddleware.
+type ConsoleProxy struct {
+	audit *audit.Logger
+	// slots claims the session slots of a VM, shared between replicas
+	slots       bus.Bus
+	maxSessions int
+	upgrader    websocket.Upgrader
+}
+
+// NewConsoleProxy creates a serial console proxy allowing maxSessions concurrent
+// sessions per VM across the replicas sharing messageBus, from allowedOrigins,
+// recording session start and end to the audit log
+func NewConsoleProxy(auditLogger *audit.Logger, messageBus bus.Bus, maxSessions int, allowedOrigins []string) *ConsoleProxy {
+	return &ConsoleProxy{
+		audit:       auditLogger,
+		slots:       messageBus,
+		maxSessions: maxSessions,
+		upgrader:    newUpgrader(allowedOrigins),
+	}
+}
+
+// HandleConsole handles serial console WebSocket proxy requests
+// Route: GET /api/vms/:name/console
+//
+// The browser sends input as binary frames of raw bytes, or as text frames holding a
+// ConsoleMessage. Resize messages are accepted but ignored, since a serial line has no
+// window size. Output is sent as text frames that never split a UTF-8 character.
+func (p *ConsoleProxy) HandleConsole(c *gin.Context) {
+	vmName := c.Param("name")
+	namespace := handlers.RequestNamespace(c)
+	client := handlers.ClientFrom(c)
+	ctx := c.Request.Context()
+
+	// Verify VMI exists and is running
+	if !requireRunningVMI(c) {
+		return
+	}
+
+	slot, ok, err := p.acquire(ctx, client.Name()+"/"+namespace+"/"+vmName)
+	if err != nil {
+		log.Printf("Failed to reserve console session: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to reserve console session: " + err.Error(),
+		})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("VM %s already has %d open console sessions", vmName, p.maxSessions),
+		})
+		return
+	}
+	defer p.hold(slot)()
+
+	consoleURL, err := buildSubresourceURL(client.GetRestConfig(), namespace, vmName, "console")
+	if err != nil {
+		log.Printf("Failed to build console URL: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to build console URL: " + err.Error(),
+		})
+		return
+	}
+
+	// Connect before upgrading, so that an upstream rejection can still be reported
+	start := time.Now()
+	serverConn, status, err := connectToKubeVirt(ctx, client.GetRestConfig(), consoleURL)
+	if err != nil {
+		log.Printf("Failed to connect to KubeVirt console: %v", err)
+		if status != http.StatusForbidden {
+			status = http.StatusBadGateway
+		}
+		auditSession(p.audit, c, "console.session.start", start, status, err)
+		c.JSON(status, gin.H{
+			"error": "Failed to connect to console: " + err.Error(),
+		})
+		return
+	}
+	defer serverConn.Close()
+
+	clientConn, err := p.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade client connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	log.Printf("Console proxy established for VM: %s/%s/%s", client.Name(), namespace, vmName)
+	auditSession(p.audit, c, "console.session.start", start, http.StatusSwitchingProtocols, nil)
+	metrics.ConsoleSessions.Inc()
+	defer metrics.ConsoleSessions.Dec()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer serverConn.Close()
+		forwardConsoleInput(clientConn, serverConn)
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer clientConn.Close()
+		forwardConsoleOutput(serverConn, clientConn)
+	}()
+
+	wg.Wait()
+	log.Printf("Console proxy closed for VM: %s/%s/%s", client.Name(), namespace, vmName)
+	auditSession(p.audit, c, "console.session.end", start, http.StatusSwitchingProtocols, nil)
+}
+
+// acquire claims a free session slot of the VM identified by key on the bus, returning
+// false if all of them are taken. The slot is empty when sessions are not limited.
+func (p *ConsoleProxy) acquire(ctx context.Context, key string) (string, bool, error) {
+	if p.maxSessions <= 0 {
+		return "", true, nil
+	}
+	for i := 0; i < p.maxSessions; i++ {
+		slot := "console:" + key + ":" + strconv.Itoa(i)
+		claimed, err := p.slots.Claim(ctx, slot, consoleSlotTTL)
+		if err != nil {
+			return "", false, err
+		}
+		if claimed {
+			return slot, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// hold renews a slot claimed by acquire until the returned function is called, which
+// releases it
+func (p *ConsoleProxy) hold(slot string) func() {
+	if slot == "" {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(consoleSlotTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if renewed, err := p.slots.Renew(ctx, slot, consoleSlotTTL); err != nil || !renewed {
+					log.Printf("Failed to renew console session slot %s: renewed=%v, %v", slot, renewed, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer releaseCancel()
+		if err := p.slots.Release(releaseCtx, slot); err != nil {
+			log.Printf("Console session slot %s stays claimed until it expires: %v", slot, err)
+		}
+	}
+}
+
+// forwardConsoleInput forwards terminal input from the browser to KubeVirt
+func forwardConsoleInput(src, dst *websocket.Conn) {
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Console proxy client->server read error: %v", err)
+			}
+			return
+		}
+
+		if messageType == websocket.TextMessage {
+			var msg ConsoleMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				log.Printf("Console proxy: ignoring invalid message: %v", err)
+				continue
+			}
+			if msg.Type != ConsoleInput {
+				// Resize and unknown messages must not reach the serial line
+				continue
+			}
+			data = []byte(msg.Data)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		if err := dst.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			log.Printf("Console proxy client->server write error: %v", err)
+			return
+		}
+	}
+}
+
+// forwardConsoleOutput forwards serial output from KubeVirt to the browser as text frames.
+// A UTF-8 character split across reads is held back until it is complete.
+func forwardConsoleOutput(src, dst *websocket.Conn) {
+	var pending []byte
+	for {
+		_, data, err := src.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Console proxy server->client read error: %v", err)
+			}
+			return
+		}
+
+		pending = append(pending, data...)
+		n := completeUTF8(pending)
+		if n == 0 {
+			continue
+		}
+
+		text := strings.ToValidUTF8(string(pending[:n]), "�")
+		if err := dst.WriteMessage(websocket.TextMessage, []byte(text)); err != nil {
+			log.Printf("Console proxy server->client write error: %v", err)
+			return
+		}
+		pending = append(pending[:0], pending[n:]...)
+	}
+}
+
+// completeUTF8 returns the length of the longest prefix of data that does not end in
+// the middle of a UTF-8 character
+func completeUTF8(data []byte) int {
+	// A character is at most utf8.UTFMax bytes, so only the last few can be incomplete
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if utf8.FullRune(data[i:]) {
+			return len(data)
+		}
+		return i
+	}
+	return len(data)
+}
@@ -0,0 +1,158 @@
+package vnc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+func TestConsoleProxyAcquire(t *testing.T) {
+	ctx := context.Background()
+	shared := bus.NewMemory()
+	first := NewConsoleProxy(nil, shared, 2, nil)
+	second := NewConsoleProxy(nil, shared, 2, nil)
+
+	var releases []func()
+	for i, p := range []*ConsoleProxy{first, second} {
+		slot, ok, err := p.acquire(ctx, "prod/vms/web-1")
+		if err != nil || !ok {
+			t.Fatalf("acquire() session %d = %v, %v, want a slot", i+1, ok, err)
+		}
+		releases = append(releases, p.hold(slot))
+	}
+
+	// The limit holds across the replicas sharing the bus, per VM
+	if _, ok, _ := first.acquire(ctx, "prod/vms/web-1"); ok {
+		t.Error("acquire() succeeded beyond the limit")
+	}
+	if _, ok, _ := second.acquire(ctx, "prod/vms/web-2"); !ok {
+		t.Error("acquire() failed for another VM")
+	}
+
+	releases[0]()
+	if _, ok, _ := second.acquire(ctx, "prod/vms/web-1"); !ok {
+		t.Error("acquire() failed after a session was released")
+	}
+	releases[1]()
+}
+
+func TestCompleteUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "login:", 6},
+		{"complete 3-byte rune", "你", 3},
+		{"split 3-byte rune after 1 byte", "a\xe4", 1},
+		{"split 3-byte rune after 2 bytes", "a\xe4\xbd", 1},
+		{"split 4-byte rune", "ok \xf0\x9f\x98", 3},
+		{"complete 4-byte rune", "ok \U0001f600", 7},
+		{"invalid byte", "a\xff", 2},
+		{"stray continuation bytes", "\x80\x80\x80\x80\x80", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completeUTF8([]byte(tt.data)); got != tt.want {
+				t.Errorf("completeUTF8(%q) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+// wsPair returns the two ends of a WebSocket connection
+func wsPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+func TestForwardConsoleInput(t *testing.T) {
+	// frame is a WebSocket message sent by the browser
+	type frame struct {
+		messageType int
+		data        string
+	}
+	tests := []struct {
+		name   string
+		frames []frame
+		want   []string
+	}{
+		{"raw bytes", []frame{{websocket.BinaryMessage, "ls\r"}}, []string{"ls\r"}},
+		{"rune split across binary frames", []frame{
+			{websocket.BinaryMessage, "\xe4\xbd"},
+			{websocket.BinaryMessage, "\xa0\r"},
+		}, []string{"\xe4\xbd", "\xa0\r"}},
+		{"input message", []frame{{websocket.TextMessage, `{"type":"input","data":"你好"}`}}, []string{"你好"}},
+		{"resize message", []frame{
+			{websocket.TextMessage, `{"type":"resize","cols":120,"rows":40}`},
+			{websocket.TextMessage, `{"type":"input","data":"q"}`},
+		}, []string{"q"}},
+		{"unknown and invalid messages", []frame{
+			{websocket.TextMessage, `{"type":"ping"}`},
+			{websocket.TextMessage, `not json`},
+			{websocket.TextMessage, `{"type":"input","data":""}`},
+			{websocket.BinaryMessage, ""},
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, browser := wsPair(t)
+			upstream, dst := wsPair(t)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer dst.Close()
+				forwardConsoleInput(src, dst)
+			}()
+
+			for _, f := range tt.frames {
+				if err := browser.WriteMessage(f.messageType, []byte(f.data)); err != nil {
+					t.Fatalf("WriteMessage() error = %v", err)
+				}
+			}
+			browser.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+			var got []string
+			for {
+				messageType, data, err := upstream.ReadMessage()
+				if err != nil {
+					break
+				}
+				if messageType != websocket.BinaryMessage {
+					t.Errorf("forwarded message type = %d, want binary", messageType)
+				}
+				got = append(got, string(data))
+			}
+			<-done
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forwarded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package vnc
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/audit"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/handlers"
+)
+
+// Bounds of the number of recordings returned by GET /api/vnc-sessions
+const (
+	defaultRecordingLimit = 100
+	maxRecordingLimit     = 1000
+)
+
+// maxPlaybackSpeed bounds the speed factor of a playback
+const maxPlaybackSpeed = 64
+
+// RecordingHandler lists VNC session recordings and plays them back
+type RecordingHandler struct {
+	store *RecordingStore
+	audit *audit.Logger
+	// readerGroups are the groups allowed to list and play back recordings
+	readerGroups map[string]bool
+	upgrader     websocket.Upgrader
+}
+
+// NewRecordingHandler creates a recording handler recording playbacks to the audit log.
+// store is nil if recording is disabled. Only members of readerGroups can list and play
+// back recordings, so nobody can if it is empty. Playbacks are accepted from allowedOrigins only.
+func NewRecordingHandler(store *RecordingStore, auditLogger *audit.Logger, readerGroups, allowedOrigins []string) *RecordingHandler {
+	groups := make(map[string]bool)
+	for _, group := range readerGroups {
+		groups[group] = true
+	}
+	return &RecordingHandler{
+		store:        store,
+		audit:        auditLogger,
+		readerGroups: groups,
+		upgrader:     newUpgrader(allowedOrigins, "binary"),
+	}
+}
+
+// authorize reports whether the user may read recordings, responding 403 if not
+func (h *RecordingHandler) authorize(c *gin.Context) bool {
+	if !handlers.InGroups(c, h.readerGroups) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Forbidden: VNC session recordings require membership in an audit reader group",
+		})
+		return false
+	}
+	return true
+}
+
+// ListRecordings handles GET /api/vnc-sessions
+func (h *RecordingHandler) ListRecordings(c *gin.Context) {
+	if !h.authorize(c) {
+		return
+	}
+	if h.store == nil {
+		c.JSON(http.StatusOK, []Recording{})
+		return
+	}
+
+	filter := RecordingFilter{
+		Cluster:   c.Query("cluster"),
+		Namespace: c.Query("namespace"),
+		VMName:    c.Query("name"),
+		Actor:     c.Query("actor"),
+		Limit:     defaultRecordingLimit,
+	}
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit: " + limit})
+			return
+		}
+		if filter.Limit > maxRecordingLimit {
+			filter.Limit = maxRecordingLimit
+		}
+	}
+
+	recordings, err := h.store.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list VNC session recordings: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, recordings)
+}
+
+// HandlePlayback replays the server side of a recording as a VNC WebSocket stream,
+// which noVNC renders like a live session. Messages from the viewer are discarded.
+// Route: GET /api/vnc-sessions/:id/playback?speed=1
+func (h *RecordingHandler) HandlePlayback(c *gin.Context) {
+	if !h.authorize(c) {
+		return
+	}
+	if h.store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VNC session recording is not enabled"})
+		return
+	}
+
+	speed := 1.0
+	if value := c.Query("speed"); value != "" {
+		var err error
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil || speed <= 0 || speed > maxPlaybackSpeed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid speed: " + value})
+			return
+		}
+	}
+
+	id := c.Param("id")
+	meta, err := h.store.Get(id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrRecordingNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": "Failed to get VNC session recording: " + err.Error()})
+		return
+	}
+
+	frames, err := h.store.Open(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to open VNC session recording: " + err.Error(),
+		})
+		return
+	}
+	defer frames.Close()
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade playback connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	h.auditPlayback(c, meta)
+	log.Printf("VNC playback of %s started at %gx", id, speed)
+
+	// Drain the viewer's messages, which also detects it disconnecting
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("VNC playback of %s read error: %v", id, err)
+			return
+		}
+		if frame.Direction != FromServer {
+			continue
+		}
+
+		if wait := time.Until(start.Add(time.Duration(float64(frame.Offset) / speed))); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+		}
+
+		if err := conn.WriteMessage(websocket.BinaryMessage, frame.Data); err != nil {
+			log.Printf("VNC playback of %s write error: %v", id, err)
+			return
+		}
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end of recording"))
+	log.Printf("VNC playback of %s finished", id)
+}
+
+// auditPlayback records the playback of a recording
+func (h *RecordingHandler) auditPlayback(c *gin.Context, meta Recording) {
+	event := audit.Event{
+		Time:      time.Now().UTC(),
+		SourceIP:  c.ClientIP(),
+		Action:    "vnc.recording.playback",
+		Cluster:   meta.Cluster,
+		Namespace: meta.Namespace,
+		Kind:      "VNCRecording",
+		Name:      meta.ID,
+		Result:    audit.ResultSuccess,
+		Status:    http.StatusSwitchingProtocols,
+	}
+	if user, ok := auth.UserFrom(c); ok {
+		event.Actor = user.Name
+		event.Groups = user.Groups
+	}
+	h.audit.Log(event)
+}
@@ -0,0 +1,367 @@
+package vnc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of recorded frames. A ControlChange frame holds the name of the user
+// taking control of a shared session; the following client frames are theirs.
+const (
+	FromServer    byte = 's'
+	FromClient    byte = 'c'
+	ControlChange byte = 'h'
+)
+
+// Extensions of the files of a recording: the frames and the metadata sidecar
+const (
+	framesExt   = ".rec"
+	metadataExt = ".json"
+)
+
+// frameHeaderSize is the size of a recorded frame header: the offset in milliseconds
+// from the start of the session (uint32), the direction (byte) and the data length (uint32)
+const frameHeaderSize = 9
+
+// recordingFlushInterval bounds how long recorded frames stay buffered, so that a
+// playback of an active session or a recording cut short by a crash lags behind the
+// session by no more than this
+const recordingFlushInterval = time.Second
+
+// ErrRecordingNotFound is returned for an unknown recording ID
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// recordingIDPattern matches the IDs generated by newID, so that an ID from a
+// request can never name a file outside the recording directory
+var recordingIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Recording is the metadata of a recorded VNC session
+type Recording struct {
+	ID        string `json:"id"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	VMName    string `json:"vmName"`
+	Actor     string `json:"actor"`
+	// Controllers are the users who controlled the session, in order of first control
+	Controllers []string   `json:"controllers,omitempty"`
+	SourceIP    string     `json:"sourceIp,omitempty"`
+	StartTime   time.Time  `json:"startTime"`
+	EndTime     *time.Time `json:"endTime,omitempty"`
+	DurationMs  int64      `json:"durationMs"`
+	// Bytes counts the recorded frame data in both directions
+	Bytes int64 `json:"bytes"`
+	// Active is true while the session is still open
+	Active bool `json:"active"`
+}
+
+// RecordingFilter selects recordings. Empty fields match everything.
+type RecordingFilter struct {
+	Cluster   string
+	Namespace string
+	VMName    string
+	Actor     string
+	// Limit caps the number of recordings returned, newest first
+	Limit int
+}
+
+// matches reports whether the recording is selected by the filter
+func (f RecordingFilter) matches(r Recording) bool {
+	switch {
+	case f.Cluster != "" && r.Cluster != f.Cluster:
+	case f.Namespace != "" && r.Namespace != f.Namespace:
+	case f.VMName != "" && r.VMName != f.VMName:
+	case f.Actor != "" && r.Actor != f.Actor:
+	default:
+		return true
+	}
+	return false
+}
+
+// Frame is one recorded WebSocket message
+type Frame struct {
+	Offset    time.Duration
+	Direction byte
+	Data      []byte
+}
+
+// RecordingStore keeps VNC session recordings in a directory, one frames file and one
+// metadata file per session
+type RecordingStore struct {
+	dir string
+}
+
+// NewRecordingStore creates a recording store in dir, creating the directory if needed
+func NewRecordingStore(dir string) (*RecordingStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	return &RecordingStore{dir: dir}, nil
+}
+
+// Start creates the recording of a session starting now. The ID, start time and
+// active flag of meta are filled in.
+func (s *RecordingStore) Start(meta Recording) (*Recorder, error) {
+	meta.ID = newID()
+	meta.StartTime = time.Now().UTC()
+	meta.Active = true
+
+	file, err := os.OpenFile(s.path(meta.ID, framesExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{store: s, meta: meta, file: file, writer: bufio.NewWriter(file)}
+	if err := s.writeMetadata(meta); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return r, nil
+}
+
+// List returns the recordings matching filter, newest first
+func (s *RecordingStore) List(filter RecordingFilter) ([]Recording, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+metadataExt))
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []Recording{}
+	for _, path := range paths {
+		meta, err := s.readMetadata(strings.TrimSuffix(filepath.Base(path), metadataExt))
+		if err != nil {
+			continue
+		}
+		if filter.matches(meta) {
+			recordings = append(recordings, meta)
+		}
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartTime.After(recordings[j].StartTime)
+	})
+	if filter.Limit > 0 && len(recordings) > filter.Limit {
+		recordings = recordings[:filter.Limit]
+	}
+	return recordings, nil
+}
+
+// Get returns the metadata of a recording
+func (s *RecordingStore) Get(id string) (Recording, error) {
+	if !recordingIDPattern.MatchString(id) {
+		return Recording{}, ErrRecordingNotFound
+	}
+	return s.readMetadata(id)
+}
+
+// Open opens the frames of a recording for reading
+func (s *RecordingStore) Open(id string) (*FrameReader, error) {
+	if !recordingIDPattern.MatchString(id) {
+		return nil, ErrRecordingNotFound
+	}
+	file, err := os.Open(s.path(id, framesExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &FrameReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// path returns the path of a file of a recording
+func (s *RecordingStore) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// readMetadata reads the metadata file of a recording
+func (s *RecordingStore) readMetadata(id string) (Recording, error) {
+	data, err := os.ReadFile(s.path(id, metadataExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return Recording{}, ErrRecordingNotFound
+	}
+	if err != nil {
+		return Recording{}, err
+	}
+	var meta Recording
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Recording{}, fmt.Errorf("invalid recording metadata %s: %w", id, err)
+	}
+	return meta, nil
+}
+
+// writeMetadata replaces the metadata file of a recording, so that readers never see
+// a partially written file
+func (s *RecordingStore) writeMetadata(meta Recording) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path(meta.ID, metadataExt+".tmp")
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(meta.ID, metadataExt))
+}
+
+// Recorder writes the frames of one session. It is safe for concurrent use by the
+// two directions of a proxy.
+type Recorder struct {
+	store *RecordingStore
+
+	mu     sync.Mutex
+	meta   Recording
+	file   *os.File
+	writer *bufio.Writer
+	err    error
+	// flushTimer flushes the buffered frames, and is nil when nothing is buffered
+	flushTimer *time.Timer
+	closed     bool
+}
+
+// ID returns the ID of the recording
+func (r *Recorder) ID() string {
+	return r.meta.ID
+}
+
+// Write records a frame sent in direction, timestamped now. The frame is written to the
+// file within recordingFlushInterval. Once a write has failed, every later write returns
+// the same error.
+func (r *Recorder) Write(direction byte, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(time.Since(r.meta.StartTime).Milliseconds()))
+	header[4] = direction
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(data)))
+	if _, err := r.writer.Write(header[:]); err != nil {
+		r.err = err
+		return err
+	}
+	if _, err := r.writer.Write(data); err != nil {
+		r.err = err
+		return err
+	}
+	r.meta.Bytes += int64(len(data))
+	if r.flushTimer == nil && r.writer.Buffered() > 0 {
+		r.flushTimer = time.AfterFunc(recordingFlushInterval, r.flush)
+	}
+	return nil
+}
+
+// flush writes the buffered frames to the file. A failure is returned by the next write.
+func (r *Recorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.flushTimer = nil
+	if r.closed || r.err != nil {
+		return
+	}
+	if err := r.writer.Flush(); err != nil {
+		r.err = err
+	}
+}
+
+// Control records that user took control of the session
+func (r *Recorder) Control(user string) error {
+	if err := r.Write(ControlChange, []byte(user)); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, controller := range r.meta.Controllers {
+		if controller == user {
+			return nil
+		}
+	}
+	r.meta.Controllers = append(r.meta.Controllers, user)
+	return nil
+}
+
+// Close flushes the frames and records the end of the session in the metadata
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	end := time.Now().UTC()
+	r.meta.EndTime = &end
+	r.meta.DurationMs = end.Sub(r.meta.StartTime).Milliseconds()
+	r.meta.Active = false
+	r.closed = true
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+		r.flushTimer = nil
+	}
+
+	err := r.writer.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	if metaErr := r.store.writeMetadata(r.meta); err == nil {
+		err = metaErr
+	}
+	return err
+}
+
+// FrameReader reads the frames of a recording in order
+type FrameReader struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// Next returns the next frame, or io.EOF after the last one. A frame truncated by a
+// session that is still being recorded, or by a crash, also ends the recording.
+func (fr *FrameReader) Next() (Frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(fr.reader, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Frame{}, io.EOF
+		}
+		return Frame{}, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[5:9]))
+	if _, err := io.ReadFull(fr.reader, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return Frame{}, io.EOF
+		}
+		return Frame{}, err
+	}
+
+	return Frame{
+		Offset:    time.Duration(binary.BigEndian.Uint32(header[0:4])) * time.Millisecond,
+		Direction: header[4],
+		Data:      data,
+	}, nil
+}
+
+// Close closes the recording
+func (fr *FrameReader) Close() error {
+	return fr.file.Close()
+}
+
+// newID returns a random ID of a recording or token
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
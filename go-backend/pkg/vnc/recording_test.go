@@ -0,0 +1,73 @@
+package vnc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// readFrames returns the frames of a recording written so far
+func readFrames(t *testing.T, store *RecordingStore, id string) []Frame {
+	t.Helper()
+	reader, err := store.Open(id)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer reader.Close()
+
+	var frames []Frame
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestRecorderFlush(t *testing.T) {
+	store, err := NewRecordingStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := store.Start(Recording{Cluster: "prod", Namespace: "vms", VMName: "web-1", Actor: "alice"})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := recorder.Write(FromServer, []byte("RFB 003.008\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// An active session is readable once its frames are flushed
+	deadline := time.Now().Add(recordingFlushInterval + 2*time.Second)
+	for len(readFrames(t, store, recorder.ID())) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("frames of an active session were not flushed within %v", recordingFlushInterval)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := recorder.Control("bob"); err != nil {
+		t.Fatalf("Control() error = %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	frames := readFrames(t, store, recorder.ID())
+	if len(frames) != 2 || frames[0].Direction != FromServer || !bytes.Equal(frames[0].Data, []byte("RFB 003.008\n")) ||
+		frames[1].Direction != ControlChange || string(frames[1].Data) != "bob" {
+		t.Fatalf("frames = %+v, want the server greeting and bob taking control", frames)
+	}
+	meta, err := store.Get(recorder.ID())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if meta.Active || meta.EndTime == nil || meta.Bytes != 15 || len(meta.Controllers) != 1 {
+		t.Errorf("metadata = %+v, want a closed recording of 15 bytes controlled by bob", meta)
+	}
+}
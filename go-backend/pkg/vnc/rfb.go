@@ -0,0 +1,453 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+// The proxy terminates the RFB protocol (RFC 6143) so that one upstream session can be
+// shared: it performs the handshake with KubeVirt itself, answers each browser's
+// handshake locally, and splits both directions into whole messages.
+
+// rfbVersion is the protocol version offered to KubeVirt and to browsers
+const rfbVersion = "RFB 003.008\n"
+
+// securityNone is the RFB security type without authentication; KubeVirt relies on the
+// API server for authentication
+const securityNone = 1
+
+// maxRFBMessageSize bounds a single RFB message, e.g. a raw full-screen update
+const maxRFBMessageSize = 64 << 20
+
+// rfbErrorReadSize is the size of the zeroed buffers returned by reads after an error,
+// at least the largest field decoded from a read (a rectangle header)
+const rfbErrorReadSize = 16
+
+// Client-to-server message types
+const (
+	msgSetPixelFormat           = 0
+	msgSetEncodings             = 2
+	msgFramebufferUpdateRequest = 3
+	msgKeyEvent                 = 4
+	msgPointerEvent             = 5
+	msgClientCutText            = 6
+	msgEnableContinuousUpdates  = 150
+	msgClientFence              = 248
+	msgXvp                      = 250
+	msgSetDesktopSize           = 251
+	msgQEMU                     = 255
+)
+
+// Server-to-client message types
+const (
+	msgFramebufferUpdate    = 0
+	msgSetColourMapEntries  = 1
+	msgBell                 = 2
+	msgServerCutText        = 3
+	msgEndContinuousUpdates = 150
+	msgServerFence          = 248
+)
+
+// Encodings and pseudo-encodings the proxy can split into messages
+const (
+	encRaw                 = 0
+	encCopyRect            = 1
+	encRRE                 = 2
+	encHextile             = 5
+	encDesktopSize         = -223
+	encLastRect            = -224
+	encCursor              = -239
+	encQEMUPointerMotion   = -257
+	encQEMUExtendedKey     = -258
+	encExtendedDesktopSize = -308
+)
+
+// Hextile tile subencoding flags
+const (
+	hextileRaw             = 1
+	hextileBackground      = 2
+	hextileForeground      = 4
+	hextileAnySubrects     = 8
+	hextileSubrectsColored = 16
+)
+
+// allowedEncoding reports whether the proxy passes an encoding requested by the
+// controlling browser on to KubeVirt. Tight and ZRLE are dropped at the cost of
+// bandwidth: their zlib streams carry state across updates, which a browser joining
+// mid-session could not decode. So are extensions whose messages the proxy does not parse.
+func allowedEncoding(enc int32) bool {
+	switch {
+	case enc == encRaw, enc == encCopyRect, enc == encRRE, enc == encHextile:
+	case enc == encDesktopSize, enc == encLastRect, enc == encCursor, enc == encExtendedDesktopSize:
+	case enc == encQEMUPointerMotion, enc == encQEMUExtendedKey:
+	case enc >= -32 && enc <= -23: // JPEG quality level
+	case enc >= -256 && enc <= -247: // compression level
+	default:
+		return false
+	}
+	return true
+}
+
+// filterEncodings removes the encodings not allowed by allowedEncoding from a
+// SetEncodings message
+func filterEncodings(msg []byte) []byte {
+	out := make([]byte, 4, len(msg))
+	copy(out, msg[:4])
+	count := 0
+	for i := 4; i+4 <= len(msg); i += 4 {
+		if allowedEncoding(int32(binary.BigEndian.Uint32(msg[i:]))) {
+			out = append(out, msg[i:i+4]...)
+			count++
+		}
+	}
+	binary.BigEndian.PutUint16(out[2:4], uint16(count))
+	return out
+}
+
+// wsStream reads the binary messages of a WebSocket connection as one byte stream
+type wsStream struct {
+	conn *websocket.Conn
+	r    io.Reader
+}
+
+// Read implements io.Reader
+func (s *wsStream) Read(p []byte) (int, error) {
+	for {
+		if s.r == nil {
+			_, r, err := s.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			s.r = r
+		}
+		n, err := s.r.Read(p)
+		if err == io.EOF {
+			s.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// rfbReader reads one RFB message, keeping its bytes. After an error, reads return
+// at most rfbErrorReadSize zeroes, enough for the fields decoded from them, and the
+// error is kept.
+type rfbReader struct {
+	r   io.Reader
+	buf []byte
+	err error
+}
+
+// read appends the next n bytes to the message and returns them
+func (m *rfbReader) read(n int) []byte {
+	if m.err == nil && len(m.buf)+n > maxRFBMessageSize {
+		m.err = fmt.Errorf("RFB message exceeds %d bytes", maxRFBMessageSize)
+	}
+	if m.err != nil {
+		// n may be a length read from the peer, e.g. up to 2 GB of cut text
+		return make([]byte, min(n, rfbErrorReadSize))
+	}
+	start := len(m.buf)
+	m.buf = append(m.buf, make([]byte, n)...)
+	_, m.err = io.ReadFull(m.r, m.buf[start:])
+	return m.buf[start:]
+}
+
+// u8 reads a byte
+func (m *rfbReader) u8() int {
+	return int(m.read(1)[0])
+}
+
+// u16 reads a big-endian 16-bit integer
+func (m *rfbReader) u16() int {
+	return int(binary.BigEndian.Uint16(m.read(2)))
+}
+
+// u32 reads a big-endian 32-bit integer
+func (m *rfbReader) u32() uint32 {
+	return binary.BigEndian.Uint32(m.read(4))
+}
+
+// cutTextLength returns the length of cut text. A negative length marks the extended
+// clipboard format, whose data is as long as its absolute value.
+func cutTextLength(length uint32) int {
+	if n := int32(length); n < 0 {
+		return int(-int64(n))
+	}
+	return int(length)
+}
+
+// readClientMessage reads a message sent by a browser
+func readClientMessage(r io.Reader) ([]byte, error) {
+	m := &rfbReader{r: r}
+	switch msgType := m.u8(); msgType {
+	case msgSetPixelFormat:
+		m.read(19)
+	case msgSetEncodings:
+		m.read(1)
+		m.read(4 * m.u16())
+	case msgFramebufferUpdateRequest:
+		m.read(9)
+	case msgKeyEvent:
+		m.read(7)
+	case msgPointerEvent:
+		m.read(5)
+	case msgClientCutText:
+		m.read(3)
+		m.read(cutTextLength(m.u32()))
+	case msgEnableContinuousUpdates:
+		m.read(9)
+	case msgClientFence:
+		m.read(7)
+		m.read(m.u8())
+	case msgXvp:
+		m.read(3)
+	case msgSetDesktopSize:
+		m.read(5)
+		screens := m.u8()
+		m.read(1 + 16*screens)
+	case msgQEMU:
+		switch m.u8() {
+		case 0: // extended key event
+			m.read(10)
+		default:
+			if m.err == nil {
+				return nil, errors.New("unsupported QEMU client message")
+			}
+		}
+	default:
+		if m.err == nil {
+			return nil, fmt.Errorf("unsupported RFB client message type %d", msgType)
+		}
+	}
+	return m.buf, m.err
+}
+
+// framebuffer is the state of the remote framebuffer needed to split server messages
+type framebuffer struct {
+	width, height int
+	// bytesPerPixel is that of the pixel format set by the controlling browser
+	bytesPerPixel int
+}
+
+// readServerMessage reads a message sent by KubeVirt, tracking resizes in fb
+func readServerMessage(r io.Reader, fb *framebuffer) ([]byte, error) {
+	m := &rfbReader{r: r}
+	switch msgType := m.u8(); msgType {
+	case msgFramebufferUpdate:
+		m.read(1)
+		rects := m.u16()
+		for i := 0; i < rects && m.err == nil; i++ {
+			if last := readRectangle(m, fb); last {
+				break
+			}
+		}
+	case msgSetColourMapEntries:
+		m.read(3)
+		m.read(6 * m.u16())
+	case msgBell, msgEndContinuousUpdates:
+	case msgServerCutText:
+		m.read(3)
+		m.read(cutTextLength(m.u32()))
+	case msgServerFence:
+		m.read(7)
+		m.read(m.u8())
+	default:
+		if m.err == nil {
+			return nil, fmt.Errorf("unsupported RFB server message type %d", msgType)
+		}
+	}
+	return m.buf, m.err
+}
+
+// readRectangle reads a rectangle of a FramebufferUpdate, returning true for a
+// LastRect marker ending the update
+func readRectangle(m *rfbReader, fb *framebuffer) bool {
+	header := m.read(12)
+	w := int(binary.BigEndian.Uint16(header[4:6]))
+	h := int(binary.BigEndian.Uint16(header[6:8]))
+	bpp := fb.bytesPerPixel
+
+	switch enc := int32(binary.BigEndian.Uint32(header[8:12])); enc {
+	case encRaw:
+		m.read(w * h * bpp)
+	case encCopyRect:
+		m.read(4)
+	case encRRE:
+		subrects := int(m.u32())
+		m.read(bpp + subrects*(bpp+8))
+	case encHextile:
+		readHextile(m, w, h, bpp)
+	case encDesktopSize:
+		fb.width, fb.height = w, h
+	case encExtendedDesktopSize:
+		screens := m.u8()
+		m.read(3 + 16*screens)
+		fb.width, fb.height = w, h
+	case encCursor:
+		m.read(w*h*bpp + (w+7)/8*h)
+	case encLastRect:
+		return true
+	case encQEMUPointerMotion, encQEMUExtendedKey:
+	default:
+		if m.err == nil {
+			m.err = fmt.Errorf("unsupported RFB encoding %d", enc)
+		}
+	}
+	return false
+}
+
+// readHextile reads the tiles of a Hextile rectangle
+func readHextile(m *rfbReader, w, h, bpp int) {
+	for y := 0; y < h && m.err == nil; y += 16 {
+		for x := 0; x < w && m.err == nil; x += 16 {
+			tw, th := min(16, w-x), min(16, h-y)
+			sub := m.u8()
+			if sub&hextileRaw != 0 {
+				m.read(tw * th * bpp)
+				continue
+			}
+			if sub&hextileBackground != 0 {
+				m.read(bpp)
+			}
+			if sub&hextileForeground != 0 {
+				m.read(bpp)
+			}
+			if sub&hextileAnySubrects != 0 {
+				subrects := m.u8()
+				size := 2
+				if sub&hextileSubrectsColored != 0 {
+					size += bpp
+				}
+				m.read(subrects * size)
+			}
+		}
+	}
+}
+
+// serverInit is the ServerInit message of a session: the framebuffer size, the pixel
+// format and the desktop name
+type serverInit struct {
+	pixelFormat [16]byte
+	name        []byte
+}
+
+// encode returns the ServerInit message for a framebuffer
+func (si *serverInit) encode(fb framebuffer) []byte {
+	msg := make([]byte, 24, 24+len(si.name))
+	binary.BigEndian.PutUint16(msg[0:2], uint16(fb.width))
+	binary.BigEndian.PutUint16(msg[2:4], uint16(fb.height))
+	copy(msg[4:20], si.pixelFormat[:])
+	binary.BigEndian.PutUint32(msg[20:24], uint32(len(si.name)))
+	return append(msg, si.name...)
+}
+
+// clientHandshake performs the RFB handshake with KubeVirt as a shared client, reading
+// from r, the stream of conn, and returns its ServerInit and the initial framebuffer
+func clientHandshake(conn *websocket.Conn, r io.Reader) (*serverInit, framebuffer, error) {
+	m := &rfbReader{r: r}
+
+	version := string(m.read(12))
+	if m.err != nil {
+		return nil, framebuffer{}, m.err
+	}
+	if version < "RFB 003.008" {
+		return nil, framebuffer{}, fmt.Errorf("unsupported RFB version %q", version)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte(rfbVersion)); err != nil {
+		return nil, framebuffer{}, err
+	}
+
+	types := m.read(m.u8())
+	if m.err == nil && len(types) == 0 {
+		return nil, framebuffer{}, fmt.Errorf("VNC server refused connection: %s", m.read(int(m.u32())))
+	}
+	none := false
+	for _, t := range types {
+		none = none || t == securityNone
+	}
+	if m.err != nil {
+		return nil, framebuffer{}, m.err
+	}
+	if !none {
+		return nil, framebuffer{}, fmt.Errorf("VNC server requires authentication, offered security types %v", types)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{securityNone}); err != nil {
+		return nil, framebuffer{}, err
+	}
+	if result := m.u32(); m.err == nil && result != 0 {
+		return nil, framebuffer{}, fmt.Errorf("VNC security handshake failed: %s", m.read(int(m.u32())))
+	}
+
+	// ClientInit with the shared flag set
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1}); err != nil {
+		return nil, framebuffer{}, err
+	}
+	fb := framebuffer{width: m.u16(), height: m.u16()}
+	si := &serverInit{}
+	copy(si.pixelFormat[:], m.read(16))
+	si.name = append([]byte(nil), m.read(int(m.u32()))...)
+	if m.err != nil {
+		return nil, framebuffer{}, m.err
+	}
+	fb.bytesPerPixel = int(si.pixelFormat[0]) / 8
+	return si, fb, nil
+}
+
+// serverHandshake returns the server side of the RFB handshake with a browser, as
+// recorded at the start of a session for playback
+func serverHandshake(init []byte) [][]byte {
+	return [][]byte{
+		[]byte(rfbVersion),
+		{1, securityNone},
+		{0, 0, 0, 0},
+		init,
+	}
+}
+
+// acceptHandshake performs the RFB handshake with a browser joining a session, reading
+// from r, the stream of conn, up to the ClientInit message. There is no authentication,
+// since the browser was authenticated by the API. The ServerInit message completing the
+// handshake is sent by the session the browser joins.
+func acceptHandshake(conn *websocket.Conn, r io.Reader) error {
+	m := &rfbReader{r: r}
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte(rfbVersion)); err != nil {
+		return err
+	}
+	version := string(m.read(12))
+	if m.err != nil {
+		return m.err
+	}
+
+	switch {
+	case version >= "RFB 003.007":
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1, securityNone}); err != nil {
+			return err
+		}
+		if choice := m.u8(); m.err == nil && choice != securityNone {
+			return fmt.Errorf("unsupported security type %d", choice)
+		}
+		if version >= "RFB 003.008" {
+			if err := conn.WriteMessage(websocket.BinaryMessage, []byte{0, 0, 0, 0}); err != nil {
+				return err
+			}
+		}
+	case version >= "RFB 003.003":
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte{0, 0, 0, securityNone}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported RFB version %q", version)
+	}
+
+	// ClientInit; every session is shared
+	m.u8()
+	return m.err
+}
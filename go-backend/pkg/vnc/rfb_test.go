@@ -0,0 +1,197 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// be returns the big-endian encoding of values, each sized by its type
+func be(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// rect returns a rectangle header
+func rect(w, h uint16, enc int32) []byte {
+	return be(uint16(0), uint16(0), w, h, enc)
+}
+
+func TestReadClientMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"SetPixelFormat", append([]byte{msgSetPixelFormat}, make([]byte, 19)...)},
+		{"SetEncodings", be(uint8(msgSetEncodings), uint8(0), uint16(2), int32(encRaw), int32(encHextile))},
+		{"FramebufferUpdateRequest", append([]byte{msgFramebufferUpdateRequest}, make([]byte, 9)...)},
+		{"KeyEvent", append([]byte{msgKeyEvent}, make([]byte, 7)...)},
+		{"PointerEvent", append([]byte{msgPointerEvent}, make([]byte, 5)...)},
+		{"ClientCutText", append(be(uint8(msgClientCutText), [3]byte{}, uint32(5)), "hello"...)},
+		{"extended ClientCutText", append(be(uint8(msgClientCutText), [3]byte{}, int32(-4)), 0, 0, 0, 1)},
+		{"ClientFence", append(be(uint8(msgClientFence), [7]byte{}, uint8(3)), 1, 2, 3)},
+		{"SetDesktopSize", append(be(uint8(msgSetDesktopSize), [5]byte{}, uint8(2), uint8(0)), make([]byte, 32)...)},
+		{"QEMU extended key", append([]byte{msgQEMU, 0}, make([]byte, 10)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The next message must be left unread
+			r := bytes.NewReader(append(append([]byte(nil), tt.msg...), msgKeyEvent))
+			got, err := readClientMessage(r)
+			if err != nil {
+				t.Fatalf("readClientMessage() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.msg) {
+				t.Errorf("readClientMessage() = %v, want %v", got, tt.msg)
+			}
+			if r.Len() != 1 {
+				t.Errorf("readClientMessage() left %d bytes, want the next message", r.Len())
+			}
+		})
+	}
+}
+
+func TestReadClientMessageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want error
+	}{
+		{"unknown type", []byte{99}, nil},
+		{"unknown QEMU message", []byte{msgQEMU, 1}, nil},
+		{"truncated", []byte{msgKeyEvent, 1, 0}, io.ErrUnexpectedEOF},
+		{"empty", nil, io.EOF},
+		// A huge cut text length fails without being read or allocated
+		{"oversized cut text", be(uint8(msgClientCutText), [3]byte{}, uint32(1<<31-1)), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readClientMessage(bytes.NewReader(tt.msg))
+			if err == nil {
+				t.Fatal("readClientMessage() succeeded, want error")
+			}
+			if tt.want != nil && err != tt.want {
+				t.Errorf("readClientMessage() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadServerMessage(t *testing.T) {
+	fb := &framebuffer{width: 640, height: 480, bytesPerPixel: 4}
+	hextile := append(rect(32, 16, encHextile),
+		// Raw tile, then a tile with background, foreground and two subrects
+		hextileRaw)
+	hextile = append(hextile, make([]byte, 16*16*4)...)
+	hextile = append(hextile, hextileBackground|hextileForeground|hextileAnySubrects)
+	hextile = append(hextile, make([]byte, 4+4+1)...)
+	hextile[len(hextile)-1] = 2
+	hextile = append(hextile, make([]byte, 2*2)...)
+
+	update := func(rects ...[]byte) []byte {
+		msg := be(uint8(msgFramebufferUpdate), uint8(0), uint16(len(rects)))
+		for _, r := range rects {
+			msg = append(msg, r...)
+		}
+		return msg
+	}
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"raw", update(append(rect(2, 2, encRaw), make([]byte, 2*2*4)...))},
+		{"CopyRect and RRE", update(
+			append(rect(8, 8, encCopyRect), 0, 0, 0, 0),
+			append(append(rect(8, 8, encRRE), be(uint32(1))...), make([]byte, 4+4+8)...),
+		)},
+		{"hextile", update(hextile)},
+		{"cursor", update(append(rect(9, 2, encCursor), make([]byte, 9*2*4+2*2)...))},
+		{"LastRect ends the update", be(uint8(msgFramebufferUpdate), uint8(0), uint16(0xffff), rect(0, 0, encLastRect))},
+		{"SetColourMapEntries", append(be(uint8(msgSetColourMapEntries), [3]byte{}, uint16(2)), make([]byte, 12)...)},
+		{"Bell", []byte{msgBell}},
+		{"ServerCutText", append(be(uint8(msgServerCutText), [3]byte{}, uint32(2)), "hi"...)},
+		{"ServerFence", append(be(uint8(msgServerFence), [7]byte{}, uint8(1)), 9)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(append(append([]byte(nil), tt.msg...), msgBell))
+			got, err := readServerMessage(r, fb)
+			if err != nil {
+				t.Fatalf("readServerMessage() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.msg) {
+				t.Errorf("readServerMessage() read %d bytes, want %d", len(got), len(tt.msg))
+			}
+			if r.Len() != 1 {
+				t.Errorf("readServerMessage() left %d bytes, want the next message", r.Len())
+			}
+		})
+	}
+}
+
+func TestReadServerMessageResize(t *testing.T) {
+	fb := &framebuffer{width: 640, height: 480, bytesPerPixel: 4}
+	msg := be(uint8(msgFramebufferUpdate), uint8(0), uint16(2),
+		rect(800, 600, encDesktopSize),
+		rect(1024, 768, encExtendedDesktopSize), uint8(1), [3]byte{}, [16]byte{})
+	if _, err := readServerMessage(bytes.NewReader(msg), fb); err != nil {
+		t.Fatalf("readServerMessage() error = %v", err)
+	}
+	if fb.width != 1024 || fb.height != 768 {
+		t.Errorf("framebuffer is %dx%d, want 1024x768", fb.width, fb.height)
+	}
+}
+
+func TestReadServerMessageErrors(t *testing.T) {
+	fb := &framebuffer{width: 640, height: 480, bytesPerPixel: 4}
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"unknown type", []byte{42}},
+		{"unsupported encoding", be(uint8(msgFramebufferUpdate), uint8(0), uint16(1), rect(8, 8, 7))},
+		{"oversized raw rectangle", be(uint8(msgFramebufferUpdate), uint8(0), uint16(1), rect(0xffff, 0xffff, encRaw))},
+		{"truncated rectangle", append(be(uint8(msgFramebufferUpdate), uint8(0), uint16(1), rect(2, 2, encRaw)), 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readServerMessage(bytes.NewReader(tt.msg), fb); err == nil {
+				t.Error("readServerMessage() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestRFBReaderAfterError(t *testing.T) {
+	m := &rfbReader{r: bytes.NewReader(nil)}
+	m.u8()
+	if m.err == nil {
+		t.Fatal("u8() on an empty stream did not fail")
+	}
+	size := len(m.buf)
+	if n := len(m.read(1 << 30)); n > rfbErrorReadSize {
+		t.Errorf("read() after an error returned %d bytes, want at most %d", n, rfbErrorReadSize)
+	}
+	if m.u32() != 0 || len(m.buf) != size {
+		t.Error("reads after an error returned or kept data")
+	}
+}
+
+func TestFilterEncodings(t *testing.T) {
+	const encTight, encZRLE = 7, 16
+	msg := be(uint8(msgSetEncodings), uint8(0), uint16(5),
+		int32(encTight), int32(encHextile), int32(encZRLE), int32(encCursor), int32(-30))
+	want := be(uint8(msgSetEncodings), uint8(0), uint16(3), int32(encHextile), int32(encCursor), int32(-30))
+	if got := filterEncodings(msg); !bytes.Equal(got, want) {
+		t.Errorf("filterEncodings() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,347 @@
+package vnc
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/metrics"
+)
+
+// viewerBufferSize bounds the messages waiting to be sent to a browser. A browser
+// falling further behind is disconnected, since the RFB stream cannot skip messages.
+const viewerBufferSize = 256
+
+// Roles of the browsers of a shared VNC session
+const (
+	RoleController = "controller"
+	RoleObserver   = "observer"
+)
+
+// ErrViewerNotFound is returned for a viewer that is not connected to the session
+var ErrViewerNotFound = errors.New("viewer not found")
+
+// ErrNotController is returned when a user other than the controlling one hands over control
+var ErrNotController = errors.New("only the controlling user can hand over control")
+
+// ViewerInfo describes a browser connected to a shared VNC session
+type ViewerInfo struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	SourceIP    string    `json:"sourceIp,omitempty"`
+	Role        string    `json:"role"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// SessionInfo describes the shared VNC session of a VM
+type SessionInfo struct {
+	Cluster     string       `json:"cluster"`
+	Namespace   string       `json:"namespace"`
+	VMName      string       `json:"vmName"`
+	Active      bool         `json:"active"`
+	StartTime   *time.Time   `json:"startTime,omitempty"`
+	RecordingID string       `json:"recordingId,omitempty"`
+	Viewers     []ViewerInfo `json:"viewers"`
+}
+
+// viewer is a browser connected to a session
+type viewer struct {
+	ViewerInfo
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// session is one upstream KubeVirt VNC connection shared by the browsers viewing a VM.
+// Input is only forwarded from the controlling browser; observers may only request
+// framebuffer updates.
+type session struct {
+	key   string
+	start time.Time
+	// ready is closed once the upstream connection is established or has failed with err
+	ready  chan struct{}
+	status int
+	err    error
+	// refs counts the requests using the session; it is guarded by the proxy mutex
+	refs int
+
+	upstream *websocket.Conn
+	stream   *wsStream
+	recorder *Recorder
+	// writeMu serializes writes to upstream
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	init       *serverInit
+	fb         framebuffer
+	viewers    map[string]*viewer
+	controller *viewer
+	closed     bool
+}
+
+// newSession creates a session of a VM that is not connected yet
+func newSession(key string) *session {
+	return &session{
+		key:     key,
+		ready:   make(chan struct{}),
+		viewers: make(map[string]*viewer),
+	}
+}
+
+// connect completes the RFB handshake on an established upstream connection
+func (s *session) connect(upstream *websocket.Conn) error {
+	s.upstream = upstream
+	s.stream = &wsStream{conn: upstream}
+	s.start = time.Now().UTC()
+
+	init, fb, err := clientHandshake(upstream, s.stream)
+	if err != nil {
+		return err
+	}
+	s.init, s.fb = init, fb
+	return nil
+}
+
+// record starts recording the session, beginning with the handshake a browser sees so
+// that a playback starts like a session
+func (s *session) record(recordings *RecordingStore, meta Recording) error {
+	recorder, err := recordings.Start(meta)
+	if err != nil {
+		return err
+	}
+	for _, msg := range serverHandshake(s.init.encode(s.fb)) {
+		if err := recorder.Write(FromServer, msg); err != nil {
+			recorder.Close()
+			return err
+		}
+	}
+	s.recorder = recorder
+	return nil
+}
+
+// run reads the messages of KubeVirt and sends them to every browser until the upstream
+// connection fails or is closed, then disconnects the browsers
+func (s *session) run() {
+	// Counted while the upstream is read, which ends once the session is closed
+	metrics.VNCSessions.Inc()
+	defer metrics.VNCSessions.Dec()
+	defer s.close()
+
+	for {
+		s.mu.Lock()
+		fb := s.fb
+		s.mu.Unlock()
+
+		msg, err := readServerMessage(s.stream, &fb)
+		if err != nil {
+			// Reads fail when the last browser leaves and the session is closed
+			if !s.isClosed() && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				log.Printf("VNC session %s upstream read error: %v", s.key, err)
+			}
+			return
+		}
+
+		if s.recorder != nil {
+			if err := s.recorder.Write(FromServer, msg); err != nil {
+				log.Printf("VNC session %s recording error: %v", s.key, err)
+				return
+			}
+		}
+
+		s.mu.Lock()
+		s.fb.width, s.fb.height = fb.width, fb.height
+		for _, v := range s.viewers {
+			select {
+			case v.send <- msg:
+			default:
+				log.Printf("VNC session %s: disconnecting viewer %s (%s), too slow", s.key, v.ID, v.User)
+				s.removeLocked(v)
+				v.conn.Close()
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// close ends the session: the upstream connection, the recording and every browser
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.upstream.Close()
+	s.controller = nil
+	for _, v := range s.viewers {
+		s.removeLocked(v)
+		v.conn.Close()
+	}
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			log.Printf("Failed to close VNC session recording %s: %v", s.recorder.ID(), err)
+		}
+	}
+}
+
+// join adds a browser that completed its handshake, returning false if the session has
+// ended. The browser controls the session if no other does.
+func (s *session) join(v *viewer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	v.send = make(chan []byte, viewerBufferSize)
+	// ServerInit completes the handshake, so the browser starts at a message boundary
+	v.send <- s.init.encode(s.fb)
+	s.viewers[v.ID] = v
+	v.Role = RoleObserver
+	if s.controller == nil {
+		s.setControllerLocked(v)
+	}
+	return true
+}
+
+// leave removes a browser. If it was controlling the session, the browser connected
+// longest takes over.
+func (s *session) leave(v *viewer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.viewers[v.ID] == v {
+		s.removeLocked(v)
+	}
+}
+
+// removeLocked removes a browser from the session and closes its queue
+func (s *session) removeLocked(v *viewer) {
+	delete(s.viewers, v.ID)
+	close(v.send)
+	if s.controller != v {
+		return
+	}
+
+	s.controller = nil
+	var next *viewer
+	for _, other := range s.viewers {
+		if next == nil || other.ConnectedAt.Before(next.ConnectedAt) {
+			next = other
+		}
+	}
+	if next != nil {
+		s.setControllerLocked(next)
+	}
+}
+
+// handover makes a browser of the session the controlling one, on behalf of user.
+// Only the controlling user may hand over control, unless nobody controls the session.
+func (s *session) handover(user, viewerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.viewers[viewerID]
+	if !ok {
+		return ErrViewerNotFound
+	}
+	if s.controller != nil && s.controller.User != user {
+		return ErrNotController
+	}
+	s.setControllerLocked(v)
+	return nil
+}
+
+// setControllerLocked gives control of the session to a browser
+func (s *session) setControllerLocked(v *viewer) {
+	if s.controller != nil {
+		s.controller.Role = RoleObserver
+	}
+	s.controller = v
+	v.Role = RoleController
+	log.Printf("VNC session %s is controlled by viewer %s (%s)", s.key, v.ID, v.User)
+
+	if s.recorder != nil {
+		if err := s.recorder.Control(v.User); err != nil {
+			log.Printf("VNC session %s recording error: %v", s.key, err)
+		}
+	}
+}
+
+// input handles a message from a browser. Observers may only request framebuffer
+// updates; their input events and encoding changes are dropped.
+func (s *session) input(v *viewer, msg []byte) error {
+	s.mu.Lock()
+	controlling := s.controller == v
+	if controlling && msg[0] == msgSetPixelFormat {
+		copy(s.init.pixelFormat[:], msg[4:20])
+		s.fb.bytesPerPixel = int(msg[4]) / 8
+	}
+	s.mu.Unlock()
+
+	switch {
+	case msg[0] == msgFramebufferUpdateRequest:
+	case !controlling:
+		return nil
+	case msg[0] == msgSetEncodings:
+		msg = filterEncodings(msg)
+	}
+
+	if controlling && s.recorder != nil {
+		if err := s.recorder.Write(FromClient, msg); err != nil {
+			return err
+		}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.upstream.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		return err
+	}
+	metrics.VNCBytes.WithLabelValues("client->server").Add(float64(len(msg)))
+	return nil
+}
+
+// isClosed reports whether the session has ended
+func (s *session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// info describes the session
+func (s *session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := s.start
+	info := SessionInfo{Active: !s.closed, StartTime: &start, Viewers: []ViewerInfo{}}
+	if s.recorder != nil {
+		info.RecordingID = s.recorder.ID()
+	}
+	for _, v := range s.viewers {
+		info.Viewers = append(info.Viewers, v.ViewerInfo)
+	}
+	sort.Slice(info.Viewers, func(i, j int) bool {
+		return info.Viewers[i].ConnectedAt.Before(info.Viewers[j].ConnectedAt)
+	})
+	return info
+}
+
+// writeLoop sends the session's messages to a browser until it leaves the session
+func (v *viewer) writeLoop() {
+	for msg := range v.send {
+		if err := v.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			log.Printf("VNC proxy server->client write error: %v", err)
+			v.conn.Close()
+			// Drain the queue until the session removes the viewer
+			for range v.send {
+			}
+			return
+		}
+		metrics.VNCBytes.WithLabelValues("server->client").Add(float64(len(msg)))
+	}
+}
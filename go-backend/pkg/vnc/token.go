@@ -0,0 +1,136 @@
+package vnc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+// DefaultTokenTTL is the default lifetime of a VNC connection token
+const DefaultTokenTTL = time.Minute
+
+// Errors of VNC connection token validation
+var (
+	ErrNoToken      = errors.New("no VNC connection token")
+	ErrInvalidToken = errors.New("invalid VNC connection token")
+	ErrExpiredToken = errors.New("expired VNC connection token")
+	ErrUsedToken    = errors.New("VNC connection token already used")
+)
+
+// tokenClaims is the signed payload of a VNC connection token
+type tokenClaims struct {
+	// ID makes every token unique, so that it can be consumed
+	ID      string `json:"jti"`
+	User    string `json:"sub"`
+	Cluster string `json:"cluster"`
+	// Namespace and Name are those of the VM
+	Namespace string `json:"ns"`
+	Name      string `json:"vm"`
+	// Expiry is a Unix time in seconds
+	Expiry int64 `json:"exp"`
+}
+
+// TokenIssuer mints and consumes single-use VNC connection tokens, HMAC-signed and
+// bound to a user, a VM and an expiry
+type TokenIssuer struct {
+	key []byte
+	ttl time.Duration
+	// used records the IDs of consumed tokens until they expire, on every replica
+	used bus.Bus
+}
+
+// NewTokenIssuer creates a token issuer signing with key tokens valid for ttl.
+// Consumed tokens are claimed on the bus, so that a token is used once across replicas.
+func NewTokenIssuer(key []byte, ttl time.Duration, messageBus bus.Bus) *TokenIssuer {
+	return &TokenIssuer{key: key, ttl: ttl, used: messageBus}
+}
+
+// NewRandomKey returns a random signing key, for a single replica
+func NewRandomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// Issue mints a token allowing user to connect once to a VM, returning it with its ID
+// and expiry. The ID identifies the connection as a viewer of the VM's shared session.
+func (t *TokenIssuer) Issue(user, cluster, namespace, name string) (string, string, time.Time) {
+	expiry := time.Now().Add(t.ttl).Truncate(time.Second)
+	claims := tokenClaims{
+		ID:        newID(),
+		User:      user,
+		Cluster:   cluster,
+		Namespace: namespace,
+		Name:      name,
+		Expiry:    expiry.Unix(),
+	}
+
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(t.sign(encoded)), claims.ID, expiry
+}
+
+// Consume validates a token for user and a VM and marks it used, returning its ID.
+// Errors other than the token errors above mean the token could not be marked used.
+func (t *TokenIssuer) Consume(ctx context.Context, token, user, cluster, namespace, name string) (string, error) {
+	if token == "" {
+		return "", ErrNoToken
+	}
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, t.sign(encoded)) {
+		return "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if claims.User != user || claims.Cluster != cluster || claims.Namespace != namespace || claims.Name != name {
+		return "", ErrInvalidToken
+	}
+	expiry := time.Unix(claims.Expiry, 0)
+	remaining := time.Until(expiry)
+	if remaining <= 0 {
+		return "", ErrExpiredToken
+	}
+
+	// The claim outlives the token, which cannot be replayed once expired
+	claimed, err := t.used.Claim(ctx, "vnc-token:"+claims.ID, remaining+time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to consume VNC connection token: %w", err)
+	}
+	if !claimed {
+		return "", ErrUsedToken
+	}
+	return claims.ID, nil
+}
+
+// isTokenError reports whether err rejects a token, as opposed to failing to check it
+func isTokenError(err error) bool {
+	return errors.Is(err, ErrNoToken) || errors.Is(err, ErrInvalidToken) ||
+		errors.Is(err, ErrExpiredToken) || errors.Is(err, ErrUsedToken)
+}
+
+// sign returns the HMAC-SHA256 signature of an encoded payload
+func (t *TokenIssuer) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
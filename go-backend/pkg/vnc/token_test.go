@@ -0,0 +1,96 @@
+package vnc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+// failingBus is a bus whose claims fail
+type failingBus struct {
+	bus.Bus
+}
+
+// Claim implements bus.Bus
+func (failingBus) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestTokenIssuerConsume(t *testing.T) {
+	ctx := context.Background()
+	issuer := NewTokenIssuer([]byte("key"), time.Minute, bus.NewMemory())
+	token, id, expiry := issuer.Issue("alice", "prod", "vms", "web-1")
+	if time.Until(expiry) <= 0 || time.Until(expiry) > time.Minute {
+		t.Errorf("Issue() expiry = %s, want within a minute", expiry)
+	}
+
+	// A token is only valid for its user and VM
+	for _, tt := range []struct{ user, cluster, namespace, name string }{
+		{"bob", "prod", "vms", "web-1"},
+		{"alice", "dev", "vms", "web-1"},
+		{"alice", "prod", "other", "web-1"},
+		{"alice", "prod", "vms", "web-2"},
+	} {
+		if _, err := issuer.Consume(ctx, token, tt.user, tt.cluster, tt.namespace, tt.name); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Consume() for %+v error = %v, want ErrInvalidToken", tt, err)
+		}
+	}
+
+	got, err := issuer.Consume(ctx, token, "alice", "prod", "vms", "web-1")
+	if err != nil || got != id {
+		t.Fatalf("Consume() = %q, %v, want %q", got, err, id)
+	}
+	if _, err := issuer.Consume(ctx, token, "alice", "prod", "vms", "web-1"); !errors.Is(err, ErrUsedToken) {
+		t.Errorf("Consume() of a used token error = %v, want ErrUsedToken", err)
+	}
+
+	// Another replica sharing the key and the bus rejects the used token too
+	replica := NewTokenIssuer([]byte("key"), time.Minute, issuer.used)
+	if _, err := replica.Consume(ctx, token, "alice", "prod", "vms", "web-1"); !errors.Is(err, ErrUsedToken) {
+		t.Errorf("Consume() on another replica error = %v, want ErrUsedToken", err)
+	}
+}
+
+func TestTokenIssuerConsumeInvalid(t *testing.T) {
+	ctx := context.Background()
+	issuer := NewTokenIssuer([]byte("key"), time.Minute, bus.NewMemory())
+	token, _, _ := issuer.Issue("alice", "prod", "vms", "web-1")
+	payload, signature, _ := strings.Cut(token, ".")
+	other, _, _ := NewTokenIssuer([]byte("other"), time.Minute, bus.NewMemory()).Issue("alice", "prod", "vms", "web-1")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"empty", "", ErrNoToken},
+		{"no signature", payload, ErrInvalidToken},
+		{"bad signature encoding", payload + ".!", ErrInvalidToken},
+		{"tampered payload", "e30." + signature, ErrInvalidToken},
+		{"other key", other, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		if _, err := issuer.Consume(ctx, tt.token, "alice", "prod", "vms", "web-1"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Consume() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	expired, _, _ := NewTokenIssuer([]byte("key"), -time.Minute, bus.NewMemory()).Issue("alice", "prod", "vms", "web-1")
+	if _, err := issuer.Consume(ctx, expired, "alice", "prod", "vms", "web-1"); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Consume() of an expired token error = %v, want ErrExpiredToken", err)
+	}
+}
+
+func TestTokenIssuerConsumeClaimFailure(t *testing.T) {
+	issuer := NewTokenIssuer([]byte("key"), time.Minute, failingBus{})
+	token, _, _ := issuer.Issue("alice", "prod", "vms", "web-1")
+
+	_, err := issuer.Consume(context.Background(), token, "alice", "prod", "vms", "web-1")
+	if err == nil || isTokenError(err) {
+		t.Errorf("Consume() error = %v, want a failure to record the token", err)
+	}
+}
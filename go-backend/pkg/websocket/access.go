@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+)
+
+// userAllowed reports whether user may list the resources of a type in a namespace of
+// a cluster. A nil user stands for the dashboard, which reads everything.
+func (h *Hub) userAllowed(ctx context.Context, user *auth.User, cluster, resourceType, namespace string) bool {
+	if user == nil || resourceType == "" {
+		return true
+	}
+	gvr, ok := k8s.ResourceTypeGVRs[resourceType]
+	if !ok {
+		return false
+	}
+	client, ok := h.registry.Get(cluster)
+	if !ok {
+		return false
+	}
+
+	allowed, err := client.UserAllowed(ctx, user.Name, user.Groups, k8s.Access{Verb: "list", Resource: gvr, Namespace: namespace})
+	if err != nil {
+		log.Printf("Cluster %s: %v", cluster, err)
+		return false
+	}
+	return allowed
+}
+
+// Allowed reports whether the user of the client may read the resource a message is
+// about. Messages that are not about a resource, such as resync and sync, are allowed;
+// the items of sync messages are authorized when the message is built.
+func (c *Client) Allowed(event *Event) bool {
+	return c.hub.userAllowed(context.Background(), c.user, event.msg.Cluster, event.msg.Resource, event.namespace)
+}
+
+// CheckAccess returns an error if user may not list a resource type selected by filter
+// in one of the namespaces it names, in any of the clusters it selects. Filters spanning
+// all namespaces pass, and their messages are authorized one by one.
+func (h *Hub) CheckAccess(ctx context.Context, user *auth.User, filter Filter) error {
+	if user == nil {
+		return nil
+	}
+	resourceTypes := filter.Resources
+	if len(resourceTypes) == 0 {
+		resourceTypes = []string{"vm", "snapshot"}
+	}
+	clusters := []string{filter.Cluster}
+	if filter.Cluster == "" {
+		clusters = nil
+		for _, client := range h.registry.Clients() {
+			clusters = append(clusters, client.Name())
+		}
+	}
+
+	for _, namespace := range filter.Namespaces {
+		for _, resourceType := range resourceTypes {
+			if _, ok := k8s.ResourceTypeGVRs[resourceType]; !ok {
+				// Unknown resource types select no message
+				continue
+			}
+			allowed := false
+			for _, cluster := range clusters {
+				if h.userAllowed(ctx, user, cluster, resourceType, namespace) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return fmt.Errorf("user %q cannot list %s resources in namespace %s", user.Name, resourceType, namespace)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Event is a broadcast message with the sequence number assigned by the Hub
+type Event struct {
+	Seq  uint64
+	Data []byte // JSON encoding of the message
+
+	msg       Message
+	namespace string
+	name      string
+	labels    map[string]string
+}
+
+// Type returns the type of the message
+func (e *Event) Type() string {
+	return e.msg.Type
+}
+
+// Filter selects the messages delivered to a client. Empty fields match everything.
+type Filter struct {
+	// Resources are the resource types to deliver, e.g. "vm" and "snapshot"
+	Resources  []string
+	Cluster    string
+	Namespaces []string
+	Names      []string
+	// Selector matches the labels of the resource, nil for everything
+	Selector labels.Selector
+}
+
+// Matches reports whether the event passes the filter.
+// Messages that are not about a resource, such as resync, always pass.
+func (f Filter) Matches(e *Event) bool {
+	if e.msg.Resource == "" {
+		return true
+	}
+	if len(f.Resources) > 0 && !contains(f.Resources, e.msg.Resource) {
+		return false
+	}
+	if f.Cluster != "" && e.msg.Cluster != f.Cluster {
+		return false
+	}
+	if len(f.Namespaces) > 0 && !contains(f.Namespaces, e.namespace) {
+		return false
+	}
+	if len(f.Names) > 0 && !contains(f.Names, e.name) {
+		return false
+	}
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(e.labels)) {
+		return false
+	}
+	return true
+}
+
+// Subscription is a named filter requested by a WebSocket client
+type Subscription struct {
+	ID            string   `json:"id"`
+	Resources     []string `json:"resources,omitempty"`
+	Cluster       string   `json:"cluster,omitempty"`
+	Namespaces    []string `json:"namespaces,omitempty"`
+	Names         []string `json:"names,omitempty"`
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	// ResumeAfter is the sequence number of the last message received before reconnecting.
+	// The messages missed since are delivered instead of a sync if still available.
+	ResumeAfter uint64 `json:"resumeAfter,omitempty"`
+}
+
+// Filter returns the filter of the subscription
+func (s Subscription) Filter() (Filter, error) {
+	filter := Filter{
+		Resources:  s.Resources,
+		Cluster:    s.Cluster,
+		Namespaces: s.Namespaces,
+		Names:      s.Names,
+	}
+	if s.LabelSelector != "" {
+		selector, err := labels.Parse(s.LabelSelector)
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid label selector: %w", err)
+		}
+		filter.Selector = selector
+	}
+	return filter, nil
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -4,66 +4,212 @@ import (
 	"context"
 	"encoding/json"
 	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/auth"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
 	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/k8s"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/metrics"
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/operations"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 )
 
-// Hub maintains the set of active clients and broadcasts messages
+const (
+	// sendBufferSize is the number of messages queued per client. A client that
+	// falls further behind is disconnected. It also bounds a replay on resume.
+	sendBufferSize = 1024
+
+	// replayBufferSize is the number of recent messages kept for clients resuming a stream
+	replayBufferSize = 1024
+
+	// maxRequestSize bounds the messages a WebSocket client may send
+	maxRequestSize = 4096
+
+	// syncTimeout bounds listing the resources of a sync message when the
+	// informer cache of a cluster has not synced yet
+	syncTimeout = 5 * time.Second
+
+	// busTopic is the bus topic of hub messages
+	busTopic = "hub"
+
+	// metricsBusTopic is the bus topic of metrics messages, which are neither
+	// sequenced nor replayed since the next ones supersede them
+	metricsBusTopic = "hub-metrics"
+
+	// publishTimeout bounds publishing a message to the bus
+	publishTimeout = 5 * time.Second
+
+	// subscribeRetryInterval is the delay before retrying a failed bus subscription
+	subscribeRetryInterval = 5 * time.Second
+)
+
+// Message types
+const (
+	MessageTypeUpdate = "update"
+	// MessageTypeResync tells a resuming client that messages it missed are no longer
+	// available, so it must reload the current state
+	MessageTypeResync = "resync"
+	// MessageTypeSync carries the current state of the resources selected by a
+	// subscription, one message per cluster and resource type
+	MessageTypeSync = "sync"
+	// MessageTypeMetrics carries the metrics of a running VM, sent at each metrics history sample
+	MessageTypeMetrics = "metrics"
+	// MessageTypeOperation carries an update of a background operation on a resource
+	MessageTypeOperation = "operation"
+	// Replies to subscription requests, carrying the subscription ID
+	MessageTypeSubscribed   = "subscribed"
+	MessageTypeUnsubscribed = "unsubscribed"
+	MessageTypeError        = "error"
+)
+
+// Actions of requests sent by WebSocket clients
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// Request is a message sent by a WebSocket client to manage its subscriptions
+type Request struct {
+	Action string `json:"action"`
+	Subscription
+}
+
+// clientRequest is a Request of a client, prepared by the client's goroutine and
+// applied by the Run loop
+type clientRequest struct {
+	client  *Client
+	request Request
+	// refusal is the error reply to a request that cannot be applied
+	refusal *Event
+	// filter is the filter of a subscription
+	filter Filter
+	// snapshot is the current state of the resources selected by a subscription,
+	// nil if the subscription resumes from the replay buffer
+	snapshot *snapshot
+}
+
+// registration is a client to add to the hub, with the snapshots of its subscriptions,
+// which are nil if the client resumes from the replay buffer
+type registration struct {
+	client    *Client
+	snapshots map[string]*snapshot
+}
+
+// snapshot holds the sync messages of a subscription, read from the informer cache
+// outside the Run loop after the hub had broadcast the message with sequence number seq
+type snapshot struct {
+	seq    uint64
+	events []*Event
+}
+
+// Hub maintains the set of active clients and broadcasts messages.
+// Messages go through a bus, so that the hubs of all replicas deliver the same
+// messages with the same sequence numbers. Only the leading hub publishes
+// resource changes.
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
+	broadcast  chan *Event
+	register   chan registration
 	unregister chan *Client
-	k8sClient  *k8s.Client
+	requests   chan clientRequest
+	registry   *k8s.Registry
+	bus        bus.Bus
 	mu         sync.RWMutex
+	// done is closed when Run returns
+	done chan struct{}
+
+	// leading is set while the hub publishes the changes of the informer caches
+	leading atomic.Bool
+
+	// seq is the sequence number of the last broadcast message
+	seq uint64
+	// replay holds the most recent messages, oldest first
+	replay []*Event
 }
 
-// Client represents a WebSocket client
+// Client represents a WebSocket client or another stream of hub messages
 type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
-	send chan []byte
+	send chan *Event
+	// subscriptions route messages to the client by subscription ID. Until the
+	// client subscribes, it is nil and the client receives every message.
+	// Only accessed by the Run loop.
+	subscriptions map[string]Filter
+	// resumeAfter is the sequence number of the last message the client received
+	// on a previous connection, 0 to start with new messages
+	resumeAfter uint64
+	// user receives the messages about resources it may list, nil for every message
+	user *auth.User
 }
 
 // Message represents a WebSocket message
 type Message struct {
-	Type      string      `json:"type"`
-	Resource  string      `json:"resource"`
-	Action    string      `json:"action"`
-	Data      interface{} `json:"data"`
-	Timestamp int64       `json:"timestamp"`
+	Seq          uint64 `json:"seq,omitempty"`
+	Type         string `json:"type"`
+	Subscription string `json:"subscription,omitempty"`
+	Cluster      string `json:"cluster"`
+	Resource     string `json:"resource"`
+	Action       string `json:"action"`
+	// ResourceVersion of the changed object, for clients that merge updates into a sync
+	ResourceVersion string      `json:"resourceVersion,omitempty"`
+	Data            interface{} `json:"data"`
+	Timestamp       int64       `json:"timestamp"`
+}
+
+// busEvent is the bus encoding of an event, with the fields filters match on
+type busEvent struct {
+	Message   Message           `json:"message"`
+	Namespace string            `json:"namespace,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
 }
 
-// NewHub creates a new Hub
-func NewHub(k8sClient *k8s.Client) *Hub {
+// NewHub creates a new Hub broadcasting changes from every cluster of the registry
+// to the hubs subscribed to the bus
+func NewHub(registry *k8s.Registry, messageBus bus.Bus) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *Client),
+		broadcast:  make(chan *Event, 256),
+		register:   make(chan registration),
 		unregister: make(chan *Client),
-		k8sClient:  k8sClient,
+		requests:   make(chan clientRequest),
+		registry:   registry,
+		bus:        messageBus,
+		done:       make(chan struct{}),
 	}
 }
 
-// Run starts the hub
+// Run starts the hub. When ctx is done, it disconnects every client and returns.
 func (h *Hub) Run(ctx context.Context) {
-	// Start Kubernetes watchers
-	go h.watchWukongs(ctx)
-	go h.watchSnapshots(ctx)
+	defer h.stop()
+
+	// Subscribe to the shared informer cache of every cluster
+	for _, client := range h.registry.Clients() {
+		h.watchResource(client, k8s.WukongGVR, "vm")
+		h.watchResource(client, k8s.WukongSnapshotGVR, "snapshot")
+		h.watchKubeVirt(client, k8s.VirtualMachineGVR)
+		h.watchKubeVirt(client, k8s.VirtualMachineInstanceGVR)
+	}
+	go h.receive(ctx, busTopic)
+	go h.receive(ctx, metricsBusTopic)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case client := <-h.register:
+		case reg := <-h.register:
+			client := reg.client
 			h.mu.Lock()
 			h.clients[client] = true
+			h.start(client, reg.snapshots)
+			metrics.WebSocketClients.Set(float64(len(h.clients)))
 			h.mu.Unlock()
 			log.Printf("Client connected. Total clients: %d", len(h.clients))
 		case client := <-h.unregister:
@@ -72,156 +218,683 @@ func (h *Hub) Run(ctx context.Context) {
 				delete(h.clients, client)
 				close(client.send)
 			}
+			metrics.WebSocketClients.Set(float64(len(h.clients)))
 			h.mu.Unlock()
 			log.Printf("Client disconnected. Total clients: %d", len(h.clients))
-		case message := <-h.broadcast:
-			h.mu.RLock()
+		case req := <-h.requests:
+			h.mu.Lock()
+			if _, ok := h.clients[req.client]; ok {
+				h.handleRequest(req)
+			}
+			h.mu.Unlock()
+		case event := <-h.broadcast:
+			h.mu.Lock()
+			if event.Seq == 0 {
+				if !encode(event) {
+					h.mu.Unlock()
+					continue
+				}
+			} else if !h.sequence(event) {
+				h.mu.Unlock()
+				continue
+			}
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if client.matches(event) {
+					h.deliver(client, event)
 				}
 			}
-			h.mu.RUnlock()
+			metrics.WebSocketClients.Set(float64(len(h.clients)))
+			h.mu.Unlock()
 		}
 	}
 }
 
-// watchWukongs watches for Wukong resource changes
-func (h *Hub) watchWukongs(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
+// stop disconnects every client and marks the hub done, so that clients registering or
+// unregistering later do not block
+func (h *Hub) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		close(client.send)
+		delete(h.clients, client)
+	}
+	metrics.WebSocketClients.Set(0)
+	close(h.done)
+}
+
+// Done returns a channel closed when the hub has stopped
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// deliver queues an event for a client, disconnecting the client if it fell behind.
+// Must be called with h.mu held.
+func (h *Hub) deliver(client *Client, event *Event) {
+	if !h.clients[client] {
+		// Already disconnected
+		return
+	}
+	select {
+	case client.send <- event:
+	default:
+		// The client fell behind, drop the message and disconnect it
+		metrics.WebSocketDroppedMessages.Inc()
+		close(client.send)
+		delete(h.clients, client)
+	}
+}
+
+// prepare decodes a request of a client in the client's goroutine and does the slow part
+// of a subscription there, checking the access of its user and reading the current state
+// of its resources, so that the Run loop keeps broadcasting meanwhile
+func (h *Hub) prepare(client *Client, data []byte) clientRequest {
+	cr := clientRequest{client: client}
+	if err := json.Unmarshal(data, &cr.request); err != nil {
+		cr.refusal = replyEvent(MessageTypeError, "", "invalid request: "+err.Error())
+		return cr
+	}
+	req := cr.request
+	if req.ID == "" {
+		cr.refusal = replyEvent(MessageTypeError, req.ID, "subscription id is required")
+		return cr
+	}
 
-		watcher, err := h.k8sClient.WatchWukongs(ctx)
+	switch req.Action {
+	case ActionSubscribe:
+		filter, err := req.Subscription.Filter()
 		if err != nil {
-			log.Printf("Failed to watch Wukongs: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
+			cr.refusal = replyEvent(MessageTypeError, req.ID, err.Error())
+			return cr
 		}
-
-		h.handleWatch(ctx, watcher, "vm")
-		watcher.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+		err = h.CheckAccess(ctx, client.user, filter)
+		cancel()
+		if err != nil {
+			cr.refusal = replyEvent(MessageTypeError, req.ID, err.Error())
+			return cr
+		}
+		cr.filter = filter
+		if !h.canResume(req.ResumeAfter) {
+			cr.snapshot = h.snapshot(client.user, req.ID, filter)
+		}
+	case ActionUnsubscribe:
+	default:
+		cr.refusal = replyEvent(MessageTypeError, req.ID, "unknown action: "+req.Action)
 	}
+	return cr
 }
 
-// watchSnapshots watches for WukongSnapshot resource changes
-func (h *Hub) watchSnapshots(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
+// handleRequest applies a prepared subscription request of a registered client and replies to it.
+// Must be called with h.mu held.
+func (h *Hub) handleRequest(cr clientRequest) {
+	client, req := cr.client, cr.request
+	if cr.refusal != nil {
+		h.deliver(client, cr.refusal)
+		return
+	}
+
+	switch req.Action {
+	case ActionSubscribe:
+		if client.subscriptions == nil {
+			client.subscriptions = make(map[string]Filter)
+		}
+		client.subscriptions[req.ID] = cr.filter
+		h.deliver(client, replyEvent(MessageTypeSubscribed, req.ID, ""))
+
+		// A resuming subscription receives the messages it missed, otherwise the current state
+		if cr.snapshot != nil {
+			h.deliverSnapshot(client, cr.filter, cr.snapshot)
 			return
-		default:
 		}
+		if missed, ok := h.missed(req.ResumeAfter); ok {
+			for _, event := range missed {
+				if cr.filter.Matches(event) {
+					h.deliver(client, event)
+				}
+			}
+			return
+		}
+		// The missed messages left the replay buffer since the request was prepared
+		h.deliver(client, h.resyncEvent())
+	case ActionUnsubscribe:
+		delete(client.subscriptions, req.ID)
+		h.deliver(client, replyEvent(MessageTypeUnsubscribed, req.ID, ""))
+	}
+}
+
+// replyEvent returns an unsequenced reply to a subscription request
+func replyEvent(msgType, id, errMsg string) *Event {
+	data := map[string]string{"id": id}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	msg := Message{
+		Type:      msgType,
+		Data:      data,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	encoded, _ := json.Marshal(msg)
+	return &Event{Data: encoded, msg: msg}
+}
+
+// matches reports whether any subscription of the client selects the event
+func (c *Client) matches(event *Event) bool {
+	if c.subscriptions == nil {
+		return true
+	}
+	for _, filter := range c.subscriptions {
+		if filter.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
 
-		watcher, err := h.k8sClient.WatchSnapshots(ctx)
+// Lead publishes the changes of the informer caches until ctx is done.
+// Only one replica should lead at a time.
+func (h *Hub) Lead(ctx context.Context) {
+	// Changes made while no hub was leading were not published
+	h.leading.Store(true)
+	h.Broadcast(Message{Type: MessageTypeResync, Timestamp: time.Now().UnixMilli()})
+
+	<-ctx.Done()
+	h.leading.Store(false)
+}
+
+// receive feeds the messages of a bus topic to the Run loop until ctx is done.
+// Messages of the metrics topic are left unsequenced.
+func (h *Hub) receive(ctx context.Context, topic string) {
+	for ctx.Err() == nil {
+		err := h.bus.Subscribe(ctx, topic, func(m bus.Message) {
+			var be busEvent
+			if err := json.Unmarshal(m.Data, &be); err != nil {
+				log.Printf("Failed to decode hub message %d: %v", m.Seq, err)
+				return
+			}
+			event := &Event{
+				msg:       be.Message,
+				namespace: be.Namespace,
+				name:      be.Name,
+				labels:    be.Labels,
+			}
+			if topic == busTopic {
+				event.Seq = m.Seq
+			}
+			select {
+			case h.broadcast <- event:
+			case <-ctx.Done():
+			}
+		})
 		if err != nil {
-			// Check if the error is due to CRD not being installed
-			if isNotFoundError(err) {
-				log.Printf("WukongSnapshot CRD not found. Snapshot watching disabled. To enable, install the WukongSnapshot CRD.")
-				// Wait longer before retrying if CRD is missing
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(30 * time.Second):
-					continue
-				}
+			log.Printf("Hub bus subscription failed, retrying in %s: %v", subscribeRetryInterval, err)
+			select {
+			case <-time.After(subscribeRetryInterval):
+			case <-ctx.Done():
 			}
-			log.Printf("Failed to watch Snapshots: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
 		}
+	}
+}
+
+// publish sends an event to the hubs of all replicas
+func (h *Hub) publish(event *Event) {
+	h.publishTo(busTopic, event)
+}
+
+// publishTo sends an event to the hubs of all replicas on the given bus topic
+func (h *Hub) publishTo(topic string, event *Event) {
+	data, err := json.Marshal(busEvent{
+		Message:   event.msg,
+		Namespace: event.namespace,
+		Name:      event.name,
+		Labels:    event.labels,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
 
-		h.handleWatch(ctx, watcher, "snapshot")
-		watcher.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := h.bus.Publish(ctx, topic, data); err != nil {
+		log.Printf("Failed to publish hub message: %v", err)
 	}
 }
 
-// isNotFoundError checks if the error indicates a resource not found
-func isNotFoundError(err error) bool {
-	if err == nil {
+// PublishMetrics sends a metrics message with the metrics of a running VM to the hubs of
+// all replicas. It is fed by the metrics history collector, which samples every running VM.
+func (h *Hub) PublishMetrics(cluster string, wukong *unstructured.Unstructured, vmMetrics *k8s.MetricsInfo) {
+	h.publishTo(metricsBusTopic, &Event{
+		msg: Message{
+			Type:     MessageTypeMetrics,
+			Cluster:  cluster,
+			Resource: "vm",
+			Data: map[string]interface{}{
+				"namespace": wukong.GetNamespace(),
+				"name":      wukong.GetName(),
+				"metrics":   vmMetrics,
+			},
+			Timestamp: time.Now().UnixMilli(),
+		},
+		namespace: wukong.GetNamespace(),
+		name:      wukong.GetName(),
+		labels:    wukong.GetLabels(),
+	})
+}
+
+// sequence encodes an event received from the bus with its sequence number and adds it
+// to the replay buffer. If messages were lost on the way, the replay buffer is reset and
+// clients are told to resync. Must be called with h.mu held.
+func (h *Hub) sequence(event *Event) bool {
+	if h.seq != 0 && event.Seq != h.seq+1 {
+		log.Printf("Hub messages %d to %d were lost, clients must resync", h.seq+1, event.Seq-1)
+		h.seq = event.Seq - 1
+		h.replay = nil
+		resync := h.resyncEvent()
+		for client := range h.clients {
+			h.deliver(client, resync)
+		}
+	}
+
+	if !encode(event) {
 		return false
 	}
-	errStr := strings.ToLower(err.Error())
-	// Check for common "not found" error patterns
-	return strings.Contains(errStr, "not found") ||
-		strings.Contains(errStr, "the server could not find the requested resource") ||
-		strings.Contains(errStr, "notfound")
+	h.seq = event.Seq
+
+	if len(h.replay) == replayBufferSize {
+		h.replay = append(h.replay[:0], h.replay[1:]...)
+	}
+	h.replay = append(h.replay, event)
+	return true
 }
 
-// handleWatch processes watch events
-func (h *Hub) handleWatch(ctx context.Context, watcher watch.Interface, resourceType string) {
-	for {
-		select {
-		case <-ctx.Done():
+// start queues the first messages of a newly registered client: the messages it
+// missed since its last connection, or the snapshots of its subscriptions.
+// A client whose missed messages are no longer in the replay buffer, and that has
+// no snapshots, gets a resync message instead.
+// Must be called with h.mu held, after the client is added.
+func (h *Hub) start(client *Client, snapshots map[string]*snapshot) {
+	if snapshots == nil {
+		if missed, ok := h.missed(client.resumeAfter); client.resumeAfter > 0 && ok {
+			for _, event := range missed {
+				if client.matches(event) {
+					h.deliver(client, event)
+				}
+			}
 			return
-		case event, ok := <-watcher.ResultChan():
-			if !ok {
-				return
+		}
+		if client.resumeAfter > 0 || client.subscriptions != nil {
+			h.deliver(client, h.resyncEvent())
+		}
+		return
+	}
+	for id, snap := range snapshots {
+		h.deliverSnapshot(client, client.subscriptions[id], snap)
+	}
+}
+
+// canResume reports whether the messages broadcast after the sequence number after
+// are still in the replay buffer
+func (h *Hub) canResume(after uint64) bool {
+	if after == 0 {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.missed(after)
+	return ok
+}
+
+// deliverSnapshot queues the sync messages of a snapshot, followed by the messages the
+// snapshot may have missed: those broadcast since it was started and selected by filter.
+// They may repeat changes the snapshot already holds, which clients merge by resource
+// version. Must be called with h.mu held.
+func (h *Hub) deliverSnapshot(client *Client, filter Filter, snap *snapshot) {
+	for _, event := range snap.events {
+		h.deliver(client, event)
+	}
+
+	missed, ok := h.missed(snap.seq)
+	if snap.seq == 0 {
+		// Nothing had been broadcast yet, so every message since is in the replay buffer
+		missed, ok = h.replay, true
+	}
+	if !ok {
+		h.deliver(client, h.resyncEvent())
+		return
+	}
+	for _, event := range missed {
+		if filter.Matches(event) {
+			h.deliver(client, event)
+		}
+	}
+}
+
+// missed returns the messages broadcast after the given sequence number.
+// It returns false if some of them are no longer in the replay buffer.
+// Must be called with h.mu held.
+func (h *Hub) missed(after uint64) ([]*Event, bool) {
+	if after == h.seq {
+		return nil, true
+	}
+	// A sequence number from the future was issued before the hub restarted
+	if after > h.seq || len(h.replay) == 0 || h.replay[0].Seq > after+1 {
+		return nil, false
+	}
+	return h.replay[after+1-h.replay[0].Seq:], true
+}
+
+// snapshot returns the sync messages with the current state of the resources selected by
+// a subscription that user may list, read from the informer cache. They carry the sequence
+// number of the last message broadcast before, so the messages that follow it are the
+// changes since. Must be called without h.mu held, since it may wait on the API server.
+func (h *Hub) snapshot(user *auth.User, id string, filter Filter) *snapshot {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	h.mu.RLock()
+	snap := &snapshot{seq: h.seq}
+	h.mu.RUnlock()
+
+	for _, k8sClient := range h.registry.Clients() {
+		cluster := k8sClient.Name()
+		if filter.Cluster != "" && filter.Cluster != cluster {
+			continue
+		}
+		for _, resourceType := range []string{"vm", "snapshot"} {
+			if len(filter.Resources) > 0 && !contains(filter.Resources, resourceType) {
+				continue
 			}
 
-			var data interface{}
-			if obj, ok := event.Object.(*unstructured.Unstructured); ok {
-				if resourceType == "vm" {
-					data = k8s.ConvertWukongToVMInfo(obj)
-				} else {
-					data = k8s.ConvertSnapshotToInfo(obj)
+			var objects []map[string]interface{}
+			var err error
+			if resourceType == "vm" {
+				objects, err = k8sClient.ListWukongs(ctx, "")
+			} else {
+				objects, err = k8sClient.ListSnapshots(ctx, "")
+			}
+			if err != nil {
+				log.Printf("Cluster %s: failed to list %s resources for sync: %v", cluster, resourceType, err)
+				snap.events = append(snap.events, replyEvent(MessageTypeError, id, "failed to sync "+resourceType+" resources of cluster "+cluster))
+				continue
+			}
+
+			items := []interface{}{}
+			for _, obj := range objects {
+				u := &unstructured.Unstructured{Object: obj}
+				if !h.userAllowed(ctx, user, cluster, resourceType, u.GetNamespace()) {
+					continue
+				}
+				event := resourceEvent(ctx, k8sClient, watch.Added, u, resourceType)
+				if filter.Matches(event) {
+					items = append(items, event.msg.Data)
 				}
 			}
 
 			msg := Message{
-				Type:      "update",
-				Resource:  resourceType,
-				Action:    string(event.Type),
-				Data:      data,
-				Timestamp: time.Now().UnixMilli(),
+				Seq:          snap.seq,
+				Type:         MessageTypeSync,
+				Subscription: id,
+				Cluster:      cluster,
+				Resource:     resourceType,
+				Data:         items,
+				Timestamp:    time.Now().UnixMilli(),
 			}
-
-			jsonMsg, err := json.Marshal(msg)
+			data, err := json.Marshal(msg)
 			if err != nil {
 				log.Printf("Failed to marshal message: %v", err)
 				continue
 			}
-
-			h.broadcast <- jsonMsg
+			snap.events = append(snap.events, &Event{Seq: snap.seq, Data: data, msg: msg})
 		}
 	}
+	return snap
 }
 
-// Broadcast sends a message to all clients
-func (h *Hub) Broadcast(msg Message) {
-	jsonMsg, err := json.Marshal(msg)
+// encode sets the JSON encoding of an event, with its sequence number
+func encode(event *Event) bool {
+	event.msg.Seq = event.Seq
+	data, err := json.Marshal(event.msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return false
+	}
+	event.Data = data
+	return true
+}
+
+// resyncEvent returns a resync message carrying the current sequence number
+func (h *Hub) resyncEvent() *Event {
+	msg := Message{
+		Seq:       h.seq,
+		Type:      MessageTypeResync,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	data, _ := json.Marshal(msg)
+	return &Event{Seq: h.seq, Data: data, msg: msg}
+}
+
+// watchResource registers informer event handlers that publish changes of the given resource
+// while the hub is leading.
+// The informer resumes a closed watch from the last resourceVersion it has seen. If that
+// version has expired (410 Gone), it relists and delivers the difference to its cache as
+// adds, updates and deletes, so no change is lost between watches.
+func (h *Hub) watchResource(client *k8s.Client, gvr schema.GroupVersionResource, resourceType string) {
+	handler := cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// Objects already present when the hub subscribes are not changes
+			if isInInitialList {
+				return
+			}
+			h.handleEvent(client, watch.Added, obj, resourceType)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Skip periodic resyncs that carry no change
+			oldU, okOld := k8s.ObjectFromEvent(oldObj)
+			newU, okNew := k8s.ObjectFromEvent(newObj)
+			if okOld && okNew && oldU.GetResourceVersion() == newU.GetResourceVersion() {
+				return
+			}
+			h.handleEvent(client, watch.Modified, newObj, resourceType)
+		},
+		DeleteFunc: func(obj interface{}) {
+			h.handleEvent(client, watch.Deleted, obj, resourceType)
+		},
+	}
+
+	if err := client.AddEventHandler(gvr, handler); err != nil {
+		log.Printf("Cluster %s: failed to watch %s: %v. Updates for %s resources are disabled.", client.Name(), gvr.Resource, err, resourceType)
+	}
+}
+
+// watchKubeVirt registers informer event handlers that publish an update of the VM
+// backed by a KubeVirt VirtualMachine or VirtualMachineInstance when its status changes,
+// so that updates carry the same status as the REST API
+func (h *Hub) watchKubeVirt(client *k8s.Client, gvr schema.GroupVersionResource) {
+	handler := cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if isInInitialList {
+				return
+			}
+			h.handleKubeVirtEvent(client, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldU, okOld := k8s.ObjectFromEvent(oldObj)
+			newU, okNew := k8s.ObjectFromEvent(newObj)
+			if okOld && okNew && kubeVirtStatus(oldU) == kubeVirtStatus(newU) {
+				return
+			}
+			h.handleKubeVirtEvent(client, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			h.handleKubeVirtEvent(client, obj)
+		},
+	}
+
+	if err := client.AddEventHandler(gvr, handler); err != nil {
+		log.Printf("Cluster %s: failed to watch %s: %v. VM updates only follow Wukong changes.", client.Name(), gvr.Resource, err)
+	}
+}
+
+// kubeVirtStatus returns the parts of a KubeVirt VM or VMI status that the VM view depends on
+func kubeVirtStatus(u *unstructured.Unstructured) string {
+	printableStatus, _, _ := unstructured.NestedString(u.Object, "status", "printableStatus")
+	phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+	return printableStatus + "/" + phase
+}
+
+// handleKubeVirtEvent publishes an update of the VM backed by a changed KubeVirt
+// VirtualMachine or VirtualMachineInstance while the hub is leading
+func (h *Hub) handleKubeVirtEvent(client *k8s.Client, obj interface{}) {
+	if !h.leading.Load() {
+		return
+	}
+	u, ok := k8s.ObjectFromEvent(obj)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	// VMIs are named after their VM
+	wukong, err := client.GetWukongForVM(ctx, u.GetNamespace(), u.GetName())
 	if err != nil {
-		log.Printf("Failed to marshal broadcast message: %v", err)
+		log.Printf("Cluster %s: failed to find the Wukong of VM %s/%s: %v", client.Name(), u.GetNamespace(), u.GetName(), err)
+		return
+	}
+	if wukong == nil {
+		return
+	}
+	h.publish(resourceEvent(ctx, client, watch.Modified, wukong, "vm"))
+}
+
+// handleEvent converts an informer event into a message and publishes it while the hub is leading
+func (h *Hub) handleEvent(client *k8s.Client, eventType watch.EventType, obj interface{}, resourceType string) {
+	if !h.leading.Load() {
 		return
 	}
-	h.broadcast <- jsonMsg
+	u, _ := k8s.ObjectFromEvent(obj)
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	h.publish(resourceEvent(ctx, client, eventType, u, resourceType))
+}
+
+// resourceEvent returns an unsequenced update message for a resource. u may be nil
+// if the informer delivered an object of an unexpected type. VMs get the same status
+// as from the REST API; their metrics are sent separately in metrics messages.
+func resourceEvent(ctx context.Context, client *k8s.Client, eventType watch.EventType, u *unstructured.Unstructured, resourceType string) *Event {
+	event := &Event{}
+	var data interface{}
+	if u != nil {
+		if resourceType == "vm" {
+			data = client.GetVMInfo(ctx, u, false)
+		} else {
+			data = k8s.ConvertSnapshotToInfo(u)
+		}
+		event.namespace = u.GetNamespace()
+		event.name = u.GetName()
+		event.labels = u.GetLabels()
+		event.msg.ResourceVersion = u.GetResourceVersion()
+	}
+
+	event.msg.Type = MessageTypeUpdate
+	event.msg.Cluster = client.Name()
+	event.msg.Resource = resourceType
+	event.msg.Action = string(eventType)
+	event.msg.Data = data
+	event.msg.Timestamp = time.Now().UnixMilli()
+	return event
+}
+
+// Broadcast sends a message to all clients of every replica
+func (h *Hub) Broadcast(msg Message) {
+	h.publish(&Event{msg: msg})
+}
+
+// BroadcastOperation sends an update of an operation to the clients of every replica
+// subscribed to its resource. Subscriptions with a label selector do not match it.
+func (h *Hub) BroadcastOperation(op operations.Operation) {
+	h.publish(&Event{
+		msg: Message{
+			Type:      MessageTypeOperation,
+			Cluster:   op.Cluster,
+			Resource:  op.Resource,
+			Action:    op.State,
+			Data:      op,
+			Timestamp: op.UpdatedAt.UnixMilli(),
+		},
+		namespace: op.Namespace,
+		name:      op.Name,
+	})
 }
 
-// Register registers a new client
+// Register registers a new client, reading the current state of its subscriptions first
+// unless it resumes. If the hub has stopped, the client is disconnected instead.
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	select {
+	case <-h.done:
+		close(client.send)
+		return
+	default:
+	}
+
+	reg := registration{client: client}
+	if client.subscriptions != nil && !h.canResume(client.resumeAfter) {
+		reg.snapshots = make(map[string]*snapshot)
+		for id, filter := range client.subscriptions {
+			reg.snapshots[id] = h.snapshot(client.user, id, filter)
+		}
+	}
+
+	select {
+	case h.register <- reg:
+	case <-h.done:
+		close(client.send)
+	}
 }
 
-// Unregister unregisters a client
+// Unregister unregisters a client. Clients are already disconnected if the hub has stopped.
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
 }
 
-// NewClient creates a new WebSocket client
-func NewClient(hub *Hub, conn *websocket.Conn) *Client {
+// NewClient creates a new WebSocket client.
+// With resumeAfter set, the messages broadcast since that sequence number are delivered first.
+// With user set, only the messages about resources the user may list are delivered.
+func NewClient(hub *Hub, conn *websocket.Conn, resumeAfter uint64, user *auth.User) *Client {
 	return &Client{
-		hub:  hub,
-		conn: conn,
-		send: make(chan []byte, 256),
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan *Event, sendBufferSize),
+		resumeAfter: resumeAfter,
+		user:        user,
 	}
 }
 
+// NewStream creates a client without a connection, whose messages are read from Events.
+// It starts with the current state of the resources selected by filter, or with
+// resumeAfter set, with the messages broadcast since that sequence number.
+// With user set, only the messages about resources the user may list are delivered.
+func NewStream(hub *Hub, filter Filter, resumeAfter uint64, user *auth.User) *Client {
+	return &Client{
+		hub:           hub,
+		send:          make(chan *Event, sendBufferSize),
+		subscriptions: map[string]Filter{"": filter},
+		resumeAfter:   resumeAfter,
+		user:          user,
+	}
+}
+
+// Events returns the messages for the client, which must be checked with Allowed.
+// The channel is closed when the client is unregistered or falls behind.
+func (c *Client) Events() <-chan *Event {
+	return c.send
+}
+
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Client) ReadPump() {
 	defer func() {
@@ -229,7 +902,7 @@ func (c *Client) ReadPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(maxRequestSize)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -237,13 +910,19 @@ func (c *Client) ReadPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
+
+		select {
+		case c.hub.requests <- c.hub.prepare(c, data):
+		case <-c.hub.done:
+			return
+		}
 	}
 }
 
@@ -263,18 +942,23 @@ func (c *Client) WritePump() {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
+			if !c.Allowed(message) {
+				continue
+			}
 
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
-			w.Write(message)
+			w.Write(message.Data)
 
 			// Add queued messages to the current WebSocket message
 			n := len(c.send)
 			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
+				if queued := <-c.send; queued != nil && c.Allowed(queued) {
+					w.Write([]byte{'\n'})
+					w.Write(queued.Data)
+				}
 			}
 
 			if err := w.Close(); err != nil {
@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/kuihuar/wukong-dashboard/go-backend/pkg/bus"
+)
+
+// testEvent returns a bus event about a VM with the given sequence number
+func testEvent(seq uint64) *Event {
+	return &Event{Seq: seq, msg: Message{Type: MessageTypeUpdate, Cluster: "prod", Resource: "vm"}, namespace: "vms", name: "web-1"}
+}
+
+// drain returns the events queued for a client
+func drain(client *Client) []*Event {
+	var events []*Event
+	for len(client.send) > 0 {
+		events = append(events, <-client.send)
+	}
+	return events
+}
+
+func TestHubSequence(t *testing.T) {
+	h := NewHub(nil, bus.NewMemory())
+	client := NewStream(h, Filter{}, 0, nil)
+	h.clients[client] = true
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		if !h.sequence(testEvent(seq)) {
+			t.Fatalf("sequence(%d) failed", seq)
+		}
+	}
+	if h.seq != 3 || len(h.replay) != 3 {
+		t.Fatalf("seq = %d with %d replayed messages, want 3 and 3", h.seq, len(h.replay))
+	}
+	if events := drain(client); len(events) != 0 {
+		t.Fatalf("sequence queued %d messages without a gap, want none", len(events))
+	}
+
+	missed, ok := h.missed(1)
+	if !ok || len(missed) != 2 || missed[0].Seq != 2 || missed[1].Seq != 3 {
+		t.Errorf("missed(1) = %v, %v, want messages 2 and 3", missed, ok)
+	}
+	if missed, ok := h.missed(3); !ok || len(missed) != 0 {
+		t.Errorf("missed(3) = %v, %v, want nothing missed", missed, ok)
+	}
+	if _, ok := h.missed(4); ok {
+		t.Error("missed(4) succeeded for a sequence number from the future")
+	}
+
+	// Messages 4 and 5 were lost on the bus
+	if !h.sequence(testEvent(6)) {
+		t.Fatal("sequence(6) failed")
+	}
+	events := drain(client)
+	if len(events) != 1 || events[0].Type() != MessageTypeResync || events[0].Seq != 5 {
+		t.Fatalf("sequence queued %v after a gap, want a resync at sequence 5", events)
+	}
+	if h.seq != 6 || len(h.replay) != 1 {
+		t.Errorf("seq = %d with %d replayed messages, want 6 and only the message after the gap", h.seq, len(h.replay))
+	}
+
+	// Clients that missed lost messages must resync, those after the gap can resume
+	if _, ok := h.missed(3); ok {
+		t.Error("missed(3) succeeded across lost messages")
+	}
+	if missed, ok := h.missed(5); !ok || len(missed) != 1 || missed[0].Seq != 6 {
+		t.Errorf("missed(5) = %v, %v, want message 6", missed, ok)
+	}
+}
+
+func TestHubReplayBufferSize(t *testing.T) {
+	h := NewHub(nil, bus.NewMemory())
+	for seq := uint64(1); seq <= replayBufferSize+10; seq++ {
+		h.sequence(testEvent(seq))
+	}
+	if len(h.replay) != replayBufferSize || h.replay[0].Seq != 11 {
+		t.Fatalf("replay holds %d messages from %d, want %d from 11", len(h.replay), h.replay[0].Seq, replayBufferSize)
+	}
+	if _, ok := h.missed(9); ok {
+		t.Error("missed(9) succeeded for messages dropped from the replay buffer")
+	}
+	if missed, ok := h.missed(10); !ok || len(missed) != replayBufferSize {
+		t.Errorf("missed(10) returned %d messages, %v, want the whole replay buffer", len(missed), ok)
+	}
+}
+
+func TestHubStop(t *testing.T) {
+	h := NewHub(nil, bus.NewMemory())
+	client := NewStream(h, Filter{}, 0, nil)
+	h.clients[client] = true
+	h.stop()
+
+	// Streams end when the hub stops, and clients leaving or arriving later do not block
+	if _, ok := <-client.Events(); ok {
+		t.Error("stop() left a stream open")
+	}
+	h.Unregister(client)
+	late := NewStream(h, Filter{}, 0, nil)
+	h.Register(late)
+	if _, ok := <-late.Events(); ok {
+		t.Error("Register() on a stopped hub left the stream open")
+	}
+	h.Unregister(late)
+}
+
+func TestHubSubscribeSnapshot(t *testing.T) {
+	h := NewHub(nil, bus.NewMemory())
+	client := NewStream(h, Filter{}, 0, nil)
+	client.subscriptions = nil
+	h.clients[client] = true
+	h.sequence(testEvent(1))
+
+	// Message 2 is broadcast while the snapshot is read outside the Run loop
+	snap := &snapshot{seq: 1, events: []*Event{{Seq: 1, msg: Message{Type: MessageTypeSync}}}}
+	h.sequence(testEvent(2))
+	drain(client)
+
+	subscribe := Request{Action: ActionSubscribe, Subscription: Subscription{ID: "vms"}}
+	h.handleRequest(clientRequest{client: client, request: subscribe, snapshot: snap})
+	events := drain(client)
+	if len(events) != 3 || events[0].Type() != MessageTypeSubscribed || events[1].Type() != MessageTypeSync || events[2].Seq != 2 {
+		t.Fatalf("subscribe queued %v, want the reply, the sync and message 2", events)
+	}
+
+	// A resuming subscription whose messages left the replay buffer since it was prepared resyncs
+	resume := Request{Action: ActionSubscribe, Subscription: Subscription{ID: "old", ResumeAfter: 1}}
+	h.replay = nil
+	h.handleRequest(clientRequest{client: client, request: resume})
+	events = drain(client)
+	if len(events) != 2 || events[1].Type() != MessageTypeResync {
+		t.Errorf("subscribe queued %v, want the reply and a resync", events)
+	}
+}